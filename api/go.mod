@@ -0,0 +1,3 @@
+module github.com/backend-im/openapi
+
+go 1.21
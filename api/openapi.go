@@ -0,0 +1,210 @@
+// Package openapi is the Backend.im API contract in openapi.json, with just
+// enough of a JSON Schema validator for the CLI and the mock API to test
+// their requests and responses against it.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Object is a JSON object as decoded into an interface{}
+type Object = map[string]interface{}
+
+// Spec is a parsed OpenAPI document
+type Spec struct {
+	Doc Object
+}
+
+// Load parses the contract
+func Load() (*Spec, error) {
+	var doc Object
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, fmt.Errorf("parse openapi.json: %w", err)
+	}
+	return &Spec{Doc: doc}, nil
+}
+
+// Resolve follows a local $ref such as #/components/schemas/DeployResponse
+func (s *Spec) Resolve(node Object) Object {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var target interface{} = s.Doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			target = target.(Object)[part]
+		}
+		node = target.(Object)
+	}
+}
+
+// Operation finds the operation serving method on a concrete path, and the
+// templated path it is declared under
+func (s *Spec) Operation(method, path string) (Object, string) {
+	segments := strings.Split(path, "/")
+	for template, item := range s.Doc["paths"].(Object) {
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		match := true
+		for i, part := range parts {
+			if part != segments[i] && !strings.HasPrefix(part, "{") {
+				match = false
+				break
+			}
+		}
+		if match {
+			op, _ := item.(Object)[strings.ToLower(method)].(Object)
+			return op, template
+		}
+	}
+	return nil, ""
+}
+
+// Operations lists every operation in the spec as "METHOD /path"
+func (s *Spec) Operations() []string {
+	var ops []string
+	for path, item := range s.Doc["paths"].(Object) {
+		for method := range item.(Object) {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Schema returns the JSON schema at a slash-separated path in the document,
+// or nil if there is none
+func (s *Spec) Schema(path ...string) Object {
+	var node interface{} = s.Doc
+	for _, part := range path {
+		next, ok := node.(Object)[part]
+		if !ok {
+			return nil
+		}
+		node = next
+		if obj, ok := node.(Object); ok {
+			node = s.Resolve(obj)
+		}
+	}
+	obj, _ := node.(Object)
+	return obj
+}
+
+// ResponseSchema returns the JSON schema an operation declares for a status
+// (nil if the response has no body), or false if the status isn't declared
+func (s *Spec) ResponseSchema(op Object, status int) (Object, bool) {
+	responses := op["responses"].(Object)
+	response, ok := responses[strconv.Itoa(status)].(Object)
+	if !ok {
+		if response, ok = responses["default"].(Object); !ok {
+			return nil, false
+		}
+	}
+	response = s.Resolve(response)
+	content, ok := response["content"].(Object)
+	if !ok {
+		return nil, true
+	}
+	return content["application/json"].(Object)["schema"].(Object), true
+}
+
+// Validate returns where value doesn't match schema, each prefixed with at
+func (s *Spec) Validate(schema Object, value interface{}, at string) []string {
+	schema = s.Resolve(schema)
+	var errs []string
+	fail := func(format string, args ...interface{}) []string {
+		return append(errs, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if want, ok := schema["const"]; ok && !reflect.DeepEqual(want, value) {
+		return fail("got %v, want %v", value, want)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || reflect.DeepEqual(allowed, value)
+		}
+		if !found {
+			return fail("%v is not one of %v", value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(Object)
+		if !ok {
+			return fail("got %T, want object", value)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = fail("missing required property %q", name)
+			}
+		}
+		properties, _ := schema["properties"].(Object)
+		for name, v := range obj {
+			if property, ok := properties[name].(Object); ok {
+				errs = append(errs, s.Validate(property, v, at+"."+name)...)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					errs = fail("property %q is not in the spec", name)
+				}
+			case Object:
+				errs = append(errs, s.Validate(extra, v, at+"."+name)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fail("got %T, want array", value)
+		}
+		for i, item := range items {
+			errs = append(errs, s.Validate(schema["items"].(Object), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fail("got %T, want string", value)
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fail("%q is not a date-time", str)
+			}
+		case "uri":
+			if u, err := url.Parse(str); err != nil || u.Scheme == "" {
+				return fail("%q is not a URI", str)
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return fail("got %v, want integer", value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fail("got %T, want number", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("got %T, want boolean", value)
+		}
+	}
+	return errs
+}
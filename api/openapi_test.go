@@ -0,0 +1,75 @@
+package openapi
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRefsResolve catches a $ref to a schema that was renamed or removed
+func TestRefsResolve(t *testing.T) {
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var walk func(node interface{}, at string)
+	walk = func(node interface{}, at string) {
+		switch n := node.(type) {
+		case Object:
+			if ref, ok := n["$ref"].(string); ok {
+				var target interface{} = s.Doc
+				for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					obj, _ := target.(Object)
+					target = obj[part]
+				}
+				if _, ok := target.(Object); !ok {
+					t.Errorf("%s: $ref %s does not resolve", at, ref)
+				}
+			}
+			for key, value := range n {
+				walk(value, at+"/"+key)
+			}
+		case []interface{}:
+			for _, value := range n {
+				walk(value, at)
+			}
+		}
+	}
+	walk(s.Doc, "#")
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := Object{
+		"type":     "object",
+		"required": []interface{}{"id"},
+		"properties": Object{
+			"id":      Object{"type": "string"},
+			"count":   Object{"type": "integer"},
+			"state":   Object{"enum": []interface{}{"queued", "done"}},
+			"updated": Object{"type": "string", "format": "date-time"},
+		},
+		"additionalProperties": false,
+	}
+
+	tests := []struct {
+		value interface{}
+		errs  int
+	}{
+		{Object{"id": "a", "count": 2.0, "state": "done", "updated": "2024-01-02T03:04:05Z"}, 0},
+		{Object{}, 1},
+		{Object{"id": "a", "count": 2.5}, 1},
+		{Object{"id": "a", "state": "running"}, 1},
+		{Object{"id": "a", "updated": "yesterday"}, 1},
+		{Object{"id": "a", "extra": true}, 1},
+		{"a", 1},
+	}
+	for _, tt := range tests {
+		if errs := s.Validate(schema, tt.value, "value"); len(errs) != tt.errs {
+			t.Errorf("Validate(%v) = %v, want %d errors", tt.value, errs, tt.errs)
+		}
+	}
+}
@@ -1,37 +1,208 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/backend-im/cli/internal/api"
+	"github.com/backend-im/cli/internal/auth"
 	"github.com/backend-im/cli/internal/commands"
 	"github.com/spf13/cobra"
 )
 
+// Process exit codes, so scripts can tell failures apart without parsing output
+const (
+	exitError       = 1  // Unclassified failure
+	exitAuth        = 3  // Not logged in, or credentials rejected
+	exitForbidden   = 4  // Credentials lack permission (e.g. token scope)
+	exitNotFound    = 5  // Resource does not exist
+	exitConflict    = 6  // Request conflicts with server state
+	exitRateLimited = 7  // Request was throttled
+	exitServer      = 8  // API failed to handle the request
+	exitNetwork     = 9  // API could not be reached
+	exitValidation  = 10 // Request was rejected as invalid
+
+	exitInterrupted = 130 // Cancelled with Ctrl-C (128 + SIGINT, as shells report it)
+)
+
 func main() {
 	var rootCmd = &cobra.Command{
 		Use:   "backend-im",
 		Short: "Backend.im CLI for seamless deployment",
 		Long:  "A CLI tool for deploying backend code to Backend.im platform",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			contextName, _ := cmd.Flags().GetString("context")
+			auth.SetContextOverride(contextName)
+
+			proxy, _ := cmd.Flags().GetString("proxy")
+			caCert, _ := cmd.Flags().GetString("ca-cert")
+			clientCert, _ := cmd.Flags().GetString("client-cert")
+			clientKey, _ := cmd.Flags().GetString("client-key")
+			insecure, _ := cmd.Flags().GetBool("insecure-skip-verify")
+			rewrites, _ := cmd.Flags().GetStringArray("ws-rewrite")
+			if !cmd.Flags().Changed("ws-rewrite") && os.Getenv(api.WSRewriteEnv) != "" {
+				rewrites = strings.Split(os.Getenv(api.WSRewriteEnv), ",")
+			}
+			for _, rule := range rewrites {
+				if _, err := api.ParseHostRewrite(rule); err != nil {
+					return err
+				}
+			}
+			api.SetNetworkOverride(api.NetworkConfig{
+				Proxy:              proxy,
+				CACert:             caCert,
+				ClientCert:         clientCert,
+				ClientKey:          clientKey,
+				InsecureSkipVerify: insecure,
+				WebSocketRewrites:  rewrites,
+			})
+
+			debug, _ := cmd.Flags().GetString("debug")
+			if !cmd.Flags().Changed("debug") {
+				debug = os.Getenv(api.DebugEnv)
+			}
+			if err := api.EnableDebug(debug); err != nil {
+				return err
+			}
+			return useFixture()
+		},
 	}
 
+	rootCmd.PersistentFlags().String("context", "", "Context to use for this command (overrides the current context)")
+	rootCmd.PersistentFlags().String("debug", "", "Trace HTTP requests and WebSocket frames to stderr, or to a file with --debug=<path>")
+	rootCmd.PersistentFlags().Lookup("debug").NoOptDefVal = "stderr"
+
+	// Network settings, overriding the context's (see 'context add')
+	rootCmd.PersistentFlags().String("proxy", "", "Proxy URL for API requests (default: HTTPS_PROXY)")
+	rootCmd.PersistentFlags().String("ca-cert", "", "PEM file with extra CA certificates to trust")
+	rootCmd.PersistentFlags().String("client-cert", "", "PEM client certificate for mutual TLS")
+	rootCmd.PersistentFlags().String("client-key", "", "PEM private key of the client certificate")
+	rootCmd.PersistentFlags().Bool("insecure-skip-verify", false, "INSECURE: don't verify the server's TLS certificate (local testing only)")
+	rootCmd.PersistentFlags().StringArray("ws-rewrite", nil, "Rewrite the host of server-provided WebSocket URLs, as from=to (repeatable)")
+
 	// Authentication
 	rootCmd.AddCommand(commands.NewAuthCommand())  // Keep for backward compatibility
 	rootCmd.AddCommand(commands.NewLoginCommand()) // Preferred command name
-	
+	rootCmd.AddCommand(commands.NewWhoamiCommand())
+	rootCmd.AddCommand(commands.NewTokenCommand())
+
+	// Configuration
+	rootCmd.AddCommand(commands.NewContextCommand())
+
 	// Code generation and editing
 	rootCmd.AddCommand(commands.NewGenerateCommand())
 	rootCmd.AddCommand(commands.NewEditCommand())
-	
+
 	// Git operations
 	rootCmd.AddCommand(commands.NewCommitCommand())
-	
+
 	// Deployment
 	rootCmd.AddCommand(commands.NewDeployCommand())
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		stop()
+		os.Exit(exitCode(err))
 	}
 }
 
+// useFixture routes API traffic through a fixture file when BACKEND_IM_RECORD
+// or BACKEND_IM_REPLAY is set, for testing commands without a live server
+func useFixture() error {
+	if path := os.Getenv(api.RecordEnv); path != "" {
+		fixture, err := api.RecordFixture(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "⏺️  Recording API traffic to %s\n", path)
+		api.SetTransport(fixture)
+	} else if path := os.Getenv(api.ReplayEnv); path != "" {
+		fixture, err := api.ReplayFixture(path)
+		if err != nil {
+			return fmt.Errorf("failed to load fixture: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "▶️  Replaying API traffic from %s\n", path)
+		api.SetTransport(fixture)
+	}
+	return nil
+}
+
+// interruptContext returns a context cancelled by the first Ctrl-C (or
+// SIGTERM), so commands can stop in-flight work and report what they left
+// behind. A second signal exits immediately.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\n🛑 Interrupted - cancelling (press Ctrl-C again to force quit)")
+		cancel()
+
+		<-signals
+		fmt.Fprintln(os.Stderr, "\n🛑 Forced exit")
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// exitCode maps an error to the process exit code for its category
+func exitCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.IsAuth():
+			return exitAuth
+		case apiErr.IsForbidden():
+			return exitForbidden
+		case apiErr.IsNotFound():
+			return exitNotFound
+		case apiErr.IsConflict():
+			return exitConflict
+		case apiErr.IsValidation():
+			return exitValidation
+		case apiErr.IsRateLimited():
+			return exitRateLimited
+		case apiErr.IsServer():
+			return exitServer
+		}
+		return exitError
+	}
+
+	// The token endpoint rejected a grant, e.g. a refresh token that expired
+	// or was revoked
+	var oauthErr *api.OAuthError
+	if errors.Is(err, auth.ErrNotAuthenticated) || errors.As(err, &oauthErr) {
+		return exitAuth
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return exitNetwork
+	}
+
+	return exitError
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/backend-im/cli/internal/api"
+	"github.com/backend-im/cli/internal/auth"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"other", errors.New("boom"), exitError},
+		{"not logged in", fmt.Errorf("deploy: %w", auth.ErrNotAuthenticated), exitAuth},
+		{"refresh rejected", fmt.Errorf("session expired and could not be refreshed: %w", &api.OAuthError{Code: "invalid_grant"}), exitAuth},
+		{"bad request", &api.Error{StatusCode: 400}, exitValidation},
+		{"unauthorized", &api.Error{StatusCode: 401}, exitAuth},
+		{"forbidden", &api.Error{StatusCode: 403}, exitForbidden},
+		{"not found", fmt.Errorf("failed to get token: %w", &api.Error{StatusCode: 404}), exitNotFound},
+		{"conflict", &api.Error{StatusCode: 409}, exitConflict},
+		{"unprocessable", &api.Error{StatusCode: 422}, exitValidation},
+		{"rate limited", &api.Error{StatusCode: 429}, exitRateLimited},
+		{"server", &api.Error{StatusCode: 503}, exitServer},
+		{"other status", &api.Error{StatusCode: 418}, exitError},
+		{"network", &url.Error{Op: "Get", URL: "http://localhost", Err: errors.New("connection refused")}, exitNetwork},
+		{"interrupted", fmt.Errorf("upload: %w", context.Canceled), exitInterrupted},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("%s: exitCode = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
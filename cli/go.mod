@@ -3,12 +3,18 @@ module github.com/backend-im/cli
 go 1.21
 
 require (
+	github.com/backend-im/openapi v0.0.0
 	github.com/gorilla/websocket v1.5.1
 	github.com/spf13/cobra v1.8.0
+	golang.org/x/crypto v0.16.0
+	golang.org/x/term v0.15.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/net v0.19.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 )
+
+replace github.com/backend-im/openapi => ../api
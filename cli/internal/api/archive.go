@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/backend-im/cli/internal/files"
+)
+
+// ArchiveContentType is the media type of a streamed project upload: a
+// gzip-compressed tar of the project files
+const ArchiveContentType = "application/tar+gzip"
+
+// ManifestHeader carries the UploadManifest of an archive upload, as
+// base64-encoded JSON, since the body is the archive itself
+const ManifestHeader = "X-Upload-Manifest"
+
+// UploadManifest describes an archive upload: the fields the JSON request
+// body would have held, plus the archive's size so the server can check it
+type UploadManifest struct {
+	ProjectID  string `json:"projectId"`
+	Message    string `json:"message,omitempty"`
+	FileCount  int    `json:"fileCount"`
+	TotalBytes int64  `json:"totalBytes"`
+}
+
+// postArchive streams project to path as a chunked archive body. The archive
+// is produced while it is sent, and again from disk if the request is retried.
+func (c *Client) postArchive(ctx context.Context, path string, project *files.Project, manifest UploadManifest, response interface{}) error {
+	manifest.FileCount = len(project.Files)
+	manifest.TotalBytes = project.TotalSize()
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	header.Set(ManifestHeader, base64.StdEncoding.EncodeToString(manifestJSON))
+
+	return c.do(ctx, &apiRequest{
+		method:         "POST",
+		path:           path,
+		idempotencyKey: key,
+		contentType:    ArchiveContentType,
+		header:         header,
+		newBody: func() io.ReadCloser {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(project.WriteArchive(pw))
+			}()
+			return pr
+		},
+	}, response)
+}
+
+// fallBackToJSON reports whether err means the server doesn't accept archive
+// uploads, remembering it for later uploads
+func (c *Client) fallBackToJSON(err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnsupportedMediaType {
+		return false
+	}
+
+	fmt.Fprintln(os.Stderr, "⚠️  Server does not accept archive uploads, sending files as JSON")
+	c.archiveUnsupported = true
+	return true
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/backend-im/cli/internal/files"
+)
+
+// readArchive returns the entries of a tar.gz by name
+func readArchive(r io.Reader) (map[string]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries[header.Name] = string(data)
+	}
+}
+
+// testProject writes files to a new project directory and scans it
+func testProject(t *testing.T, contents map[string]string) *files.Project {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range contents {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	project, err := files.ScanProject(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return project
+}
+
+// archiveServer accepts deployments as archives, or only as JSON when
+// jsonOnly is set. failFirst makes the first archive upload fail with 503.
+type archiveServer struct {
+	mu        sync.Mutex
+	jsonOnly  bool
+	failFirst bool
+	uploads   []archiveUpload
+}
+
+type archiveUpload struct {
+	contentType string
+	key         string
+	manifest    UploadManifest
+	entries     map[string]string
+	body        map[string]interface{}
+}
+
+func (s *archiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/deploy" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload := archiveUpload{contentType: r.Header.Get("Content-Type"), key: r.Header.Get("Idempotency-Key")}
+	if upload.contentType == ArchiveContentType {
+		if s.jsonOnly {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			w.Write([]byte(`{"error": {"code": "unsupported_media_type", "message": "JSON only"}}`))
+			return
+		}
+		data, _ := base64.StdEncoding.DecodeString(r.Header.Get(ManifestHeader))
+		json.Unmarshal(data, &upload.manifest)
+		var err error
+		if upload.entries, err = readArchive(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "bad_request", "message": "Invalid archive"}}`))
+			return
+		}
+	} else {
+		json.NewDecoder(r.Body).Decode(&upload.body)
+	}
+	s.uploads = append(s.uploads, upload)
+
+	if s.failFirst && len(s.uploads) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error": {"code": "unavailable", "message": "Try again"}}`))
+		return
+	}
+	w.Write([]byte(`{"deploymentId": "dep-1", "status": "queued"}`))
+}
+
+// newArchiveClient returns a client of server that retries once and skips
+// delta uploads, which server doesn't offer
+func newArchiveClient(t *testing.T, server *archiveServer) *Client {
+	t.Helper()
+	c := newTestClient(t, server)
+	c.SetRetryPolicy(RetryPolicy{MaxRetries: 1})
+	c.deltaUnsupported = true
+	return c
+}
+
+var archiveFiles = map[string]string{
+	"main.py":           "print('hi')\n",
+	"app/models.py":     "class Todo: pass\n",
+	".backend-im/state": "never uploaded",
+}
+
+func TestArchiveUpload(t *testing.T) {
+	server := &archiveServer{}
+	c := newArchiveClient(t, server)
+	project := testProject(t, archiveFiles)
+
+	_, stats, err := c.DeployProject(context.Background(), project, "proj-1")
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if stats.Method != UploadArchive {
+		t.Errorf("uploaded with %s, want %s", stats.Method, UploadArchive)
+	}
+
+	upload := server.uploads[0]
+	if upload.key == "" {
+		t.Errorf("archive upload has no Idempotency-Key")
+	}
+	want := UploadManifest{ProjectID: "proj-1", FileCount: 2, TotalBytes: int64(len(archiveFiles["main.py"]) + len(archiveFiles["app/models.py"]))}
+	if upload.manifest != want {
+		t.Errorf("manifest %+v, want %+v", upload.manifest, want)
+	}
+	if len(upload.entries) != 2 || upload.entries["main.py"] != archiveFiles["main.py"] || upload.entries["app/models.py"] != archiveFiles["app/models.py"] {
+		t.Errorf("archive holds %v", upload.entries)
+	}
+}
+
+// TestArchiveUploadRetry sends the whole archive again when the first
+// attempt fails, under the same Idempotency-Key
+func TestArchiveUploadRetry(t *testing.T) {
+	server := &archiveServer{failFirst: true}
+	c := newArchiveClient(t, server)
+	project := testProject(t, archiveFiles)
+
+	if _, _, err := c.DeployProject(context.Background(), project, "proj-1"); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if len(server.uploads) != 2 {
+		t.Fatalf("sent %d uploads, want 2", len(server.uploads))
+	}
+	first, retry := server.uploads[0], server.uploads[1]
+	if retry.key != first.key || len(retry.entries) != len(first.entries) {
+		t.Errorf("retry sent key %q with %d files, first attempt key %q with %d", retry.key, len(retry.entries), first.key, len(first.entries))
+	}
+}
+
+func TestArchiveUploadFallsBackToJSON(t *testing.T) {
+	server := &archiveServer{jsonOnly: true}
+	c := newArchiveClient(t, server)
+	project := testProject(t, archiveFiles)
+
+	_, stats, err := c.DeployProject(context.Background(), project, "proj-1")
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if stats.Method != UploadJSON || !c.archiveUnsupported {
+		t.Errorf("uploaded with %s, archives unsupported %v", stats.Method, c.archiveUnsupported)
+	}
+	sent, _ := server.uploads[0].body["files"].(map[string]interface{})
+	if len(sent) != 2 || sent["main.py"] != archiveFiles["main.py"] {
+		t.Errorf("JSON upload sent %v", sent)
+	}
+
+	// Later uploads go straight to JSON
+	server.uploads = nil
+	if _, _, err := c.DeployProject(context.Background(), project, "proj-1"); err != nil {
+		t.Fatalf("second deploy: %v", err)
+	}
+	if server.uploads[0].contentType == ArchiveContentType {
+		t.Errorf("tried an archive again after the server turned it down")
+	}
+}
+
+// TestArchiveUploadFollowsSymlinks archives a symlinked file with the content
+// it points to, as the JSON upload does
+func TestArchiveUploadFollowsSymlinks(t *testing.T) {
+	server := &archiveServer{}
+	c := newArchiveClient(t, server)
+	project := testProject(t, archiveFiles)
+	if err := os.Symlink("main.py", filepath.Join(project.Dir, "link.py")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if err := os.Symlink("app", filepath.Join(project.Dir, "applink")); err != nil {
+		t.Fatal(err)
+	}
+	project, err := files.ScanProject(project.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := c.DeployProject(context.Background(), project, "proj-1"); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	entries := server.uploads[0].entries
+	if len(entries) != 3 || entries["link.py"] != archiveFiles["main.py"] {
+		t.Errorf("archive holds %v, want link.py with the content of main.py", entries)
+	}
+}
+
+// TestArchiveFileChanged fails an archive whose file changed size since the scan
+func TestArchiveFileChanged(t *testing.T) {
+	for name, content := range map[string]string{"grew": "print('hi there')\n", "shrank": "p\n"} {
+		t.Run(name, func(t *testing.T) {
+			project := testProject(t, archiveFiles)
+			if err := os.WriteFile(filepath.Join(project.Dir, "main.py"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := project.WriteArchive(io.Discard)
+			if err == nil || !strings.Contains(err.Error(), "changed while uploading") {
+				t.Errorf("err = %v, want main.py reported as changed", err)
+			}
+		})
+	}
+}
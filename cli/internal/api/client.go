@@ -2,37 +2,80 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
+
+	"github.com/backend-im/cli/internal/auth"
 )
 
 const DefaultAPIURL = "http://localhost:8080"
 
+// ClientID identifies the CLI to the Backend.im OAuth server.
+const ClientID = "backend-im-cli"
+
+// DeviceCodeGrantType is the OAuth 2.0 grant type for the device authorization flow (RFC 8628).
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
 type Client struct {
 	baseURL    string
 	authToken  string
+	token      *auth.Token
 	httpClient *http.Client
+	retry      RetryPolicy
+
+	// server is the result of the version handshake, done before the first
+	// request (see negotiate); nil if the server predates it
+	server     *ServerInfo
+	negotiated bool
+
+	// rateLimit is the quota reported with the latest response
+	rateLimit *RateLimit
+
+	// networkErr is why the network settings (proxy, TLS) couldn't be
+	// loaded; every request fails with it
+	networkErr error
+
+	// Set once the server turns down an upload method, so later uploads
+	// skip straight to the next one (see uploadProject)
+	deltaUnsupported    bool
+	archiveUnsupported  bool
+	sessionsUnsupported bool
 }
 
 func NewClient() *Client {
-	apiURL := os.Getenv("BACKEND_IM_API_URL")
-	if apiURL == "" {
-		apiURL = DefaultAPIURL
-	}
-	
-	// Debug: log the API URL being used (can remove later)
-	// fmt.Fprintf(os.Stderr, "DEBUG: Using API URL: %s\n", apiURL)
+	apiURL := ResolveAPIURL()
+
+	tracef("Using API URL: %s", apiURL)
 
+	transport, err := newTransport()
 	return &Client{
 		baseURL: apiURL,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
+		retry:      retryPolicyFromEnv(),
+		networkErr: err,
+	}
+}
+
+// ResolveAPIURL returns the API URL: BACKEND_IM_API_URL if set, otherwise the
+// active context's URL, otherwise DefaultAPIURL
+func ResolveAPIURL() string {
+	if apiURL := os.Getenv("BACKEND_IM_API_URL"); apiURL != "" {
+		return apiURL
+	}
+	if _, ctx, err := auth.CurrentContext(); err == nil && ctx.APIURL != "" {
+		return ctx.APIURL
 	}
+	return DefaultAPIURL
 }
 
 func (c *Client) BaseURL() string {
@@ -43,16 +86,25 @@ func (c *Client) SetAuthToken(token string) {
 	c.authToken = token
 }
 
-func (c *Client) GenerateCode(prompt string) (map[string]string, error) {
+// SetToken authenticates with a saved token. Unlike SetAuthToken, the client
+// refreshes it transparently when it expires and persists the new token.
+func (c *Client) SetToken(token *auth.Token) {
+	c.token = token
+	c.authToken = token.AccessToken
+}
+
+// Token returns the token set with SetToken, including any refresh since
+func (c *Client) Token() *auth.Token {
+	return c.token
+}
+
+func (c *Client) GenerateCode(ctx context.Context, prompt string) (map[string]string, error) {
 	reqBody := map[string]string{
 		"prompt": prompt,
 	}
 
-	var response struct {
-		Files map[string]string `json:"files"`
-	}
-
-	err := c.post("/api/generate", reqBody, &response)
+	var response generateResponse
+	err := c.post(ctx, "/api/generate", reqBody, &response)
 	if err != nil {
 		return nil, err
 	}
@@ -60,14 +112,19 @@ func (c *Client) GenerateCode(prompt string) (map[string]string, error) {
 	return response.Files, nil
 }
 
-func (c *Client) Deploy(files map[string]string, projectID string) (*DeployResponse, error) {
-	reqBody := map[string]interface{}{
-		"files":     files,
-		"projectId": projectID,
+func (c *Client) GetStatus(ctx context.Context, deploymentID string) (*StatusResponse, error) {
+	var response StatusResponse
+	err := c.get(ctx, fmt.Sprintf("/api/status/%s", deploymentID), &response)
+	if err != nil {
+		return nil, err
 	}
 
-	var response DeployResponse
-	err := c.post("/api/deploy", reqBody, &response)
+	return &response, nil
+}
+
+func (c *Client) VerifyAuth(ctx context.Context) (*AuthVerifyResponse, error) {
+	var response AuthVerifyResponse
+	err := c.get(ctx, "/api/auth/verify", &response)
 	if err != nil {
 		return nil, err
 	}
@@ -75,9 +132,14 @@ func (c *Client) Deploy(files map[string]string, projectID string) (*DeployRespo
 	return &response, nil
 }
 
-func (c *Client) GetStatus(deploymentID string) (*StatusResponse, error) {
-	var response StatusResponse
-	err := c.get(fmt.Sprintf("/api/status/%s", deploymentID), &response)
+// RequestDeviceCode starts the OAuth device authorization flow
+func (c *Client) RequestDeviceCode(ctx context.Context) (*DeviceCodeResponse, error) {
+	reqBody := map[string]string{
+		"client_id": ClientID,
+	}
+
+	var response DeviceCodeResponse
+	err := c.post(ctx, "/api/auth/device/code", reqBody, &response)
 	if err != nil {
 		return nil, err
 	}
@@ -85,9 +147,62 @@ func (c *Client) GetStatus(deploymentID string) (*StatusResponse, error) {
 	return &response, nil
 }
 
-func (c *Client) VerifyAuth() (*AuthVerifyResponse, error) {
-	var response AuthVerifyResponse
-	err := c.get("/api/auth/verify", &response)
+// PollDeviceToken exchanges a device code for a token. Until the user approves
+// the request it returns an *OAuthError such as "authorization_pending".
+func (c *Client) PollDeviceToken(ctx context.Context, deviceCode string) (*TokenResponse, error) {
+	return c.requestToken(ctx, map[string]string{
+		"grant_type":  DeviceCodeGrantType,
+		"device_code": deviceCode,
+		"client_id":   ClientID,
+	})
+}
+
+// AuthorizeURL builds the browser authorization URL for the PKCE login flow
+func (c *Client) AuthorizeURL(redirectURI, state, codeChallenge, challengeMethod string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("state", state)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", challengeMethod)
+
+	return c.baseURL + "/api/auth/authorize?" + params.Encode()
+}
+
+// ExchangeAuthCode exchanges an authorization code for a token via the
+// /api/auth/callback endpoint, proving possession of the PKCE verifier
+func (c *Client) ExchangeAuthCode(ctx context.Context, code, codeVerifier, redirectURI string) (*TokenResponse, error) {
+	params := url.Values{}
+	params.Set("code", code)
+	params.Set("code_verifier", codeVerifier)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("client_id", ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/auth/callback?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	return c.doTokenRequest(req)
+}
+
+// RevokeToken revokes an access or refresh token on the server (RFC 7009).
+// tokenTypeHint is "access_token" or "refresh_token".
+func (c *Client) RevokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	reqBody := map[string]string{
+		"token":           token,
+		"token_type_hint": tokenTypeHint,
+		"client_id":       ClientID,
+	}
+
+	return c.post(ctx, "/api/auth/revoke", reqBody, nil)
+}
+
+// RevokeAllSessions revokes every token belonging to the authenticated user
+func (c *Client) RevokeAllSessions(ctx context.Context) (*RevokeSessionsResponse, error) {
+	var response RevokeSessionsResponse
+	err := c.delete(ctx, "/api/auth/sessions", &response)
 	if err != nil {
 		return nil, err
 	}
@@ -95,15 +210,17 @@ func (c *Client) VerifyAuth() (*AuthVerifyResponse, error) {
 	return &response, nil
 }
 
-func (c *Client) CommitChanges(files map[string]string, projectID, message string) (*CommitResponse, error) {
+// CreatePersonalAccessToken creates a long-lived token limited to scopes.
+// expiresIn of zero creates a token that never expires.
+func (c *Client) CreatePersonalAccessToken(ctx context.Context, name string, scopes []string, expiresIn time.Duration) (*PersonalAccessToken, error) {
 	reqBody := map[string]interface{}{
-		"files":     files,
-		"projectId": projectID,
-		"message":   message,
+		"name":      name,
+		"scopes":    scopes,
+		"expiresIn": int(expiresIn.Seconds()),
 	}
 
-	var response CommitResponse
-	err := c.post("/api/commit", reqBody, &response)
+	var response PersonalAccessToken
+	err := c.post(ctx, "/api/tokens", reqBody, &response)
 	if err != nil {
 		return nil, err
 	}
@@ -111,33 +228,80 @@ func (c *Client) CommitChanges(files map[string]string, projectID, message strin
 	return &response, nil
 }
 
-func (c *Client) post(path string, body interface{}, response interface{}) error {
+func (c *Client) ListPersonalAccessTokens(ctx context.Context) ([]PersonalAccessToken, error) {
+	var response tokenListResponse
+	err := c.get(ctx, "/api/tokens", &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Tokens, nil
+}
+
+func (c *Client) RevokePersonalAccessToken(ctx context.Context, id string) error {
+	return c.delete(ctx, fmt.Sprintf("/api/tokens/%s", url.PathEscape(id)), nil)
+}
+
+// apiRequest describes one API call; it is re-sent as-is on retries
+type apiRequest struct {
+	method string
+	path   string
+	body   []byte
+	// idempotencyKey makes a POST safe to retry: the server replays the
+	// original response instead of performing the operation twice
+	idempotencyKey string
+	// safe marks a POST that only reads, so it can be retried without a key
+	safe bool
+	// newBody, if set, streams the body instead of sending body. It is
+	// called again for every attempt.
+	newBody     func() io.ReadCloser
+	contentType string
+	header      http.Header
+	// stream marks a response read for as long as it lasts (Server-Sent
+	// Events), which the client's overall timeout must not cut short
+	stream bool
+}
+
+func (c *Client) post(ctx context.Context, path string, body interface{}, response interface{}) error {
 	jsonData, err := json.Marshal(body)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL+path, bytes.NewBuffer(jsonData))
+	return c.do(ctx, &apiRequest{method: "POST", path: path, body: jsonData}, response)
+}
+
+// postIdempotent sends a POST with a fresh Idempotency-Key so it can be
+// retried without creating duplicates
+func (c *Client) postIdempotent(ctx context.Context, path string, body interface{}, response interface{}) error {
+	jsonData, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to marshal request: %w", err)
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if c.authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
 	}
 
-	resp, err := c.httpClient.Do(req)
+	return c.do(ctx, &apiRequest{method: "POST", path: path, body: jsonData, idempotencyKey: key}, response)
+}
+
+func (c *Client) get(ctx context.Context, path string, response interface{}) error {
+	return c.do(ctx, &apiRequest{method: "GET", path: path}, response)
+}
+
+func (c *Client) delete(ctx context.Context, path string, response interface{}) error {
+	return c.do(ctx, &apiRequest{method: "DELETE", path: path}, response)
+}
+
+// do sends an authenticated request and decodes the JSON response
+func (c *Client) do(ctx context.Context, req *apiRequest, response interface{}) error {
+	resp, err := c.open(ctx, req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error (%d): %s", resp.StatusCode, string(bodyBytes))
-	}
-
 	if response != nil {
 		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
 			return fmt.Errorf("failed to decode response: %w", err)
@@ -147,37 +311,227 @@ func (c *Client) post(path string, body interface{}, response interface{}) error
 	return nil
 }
 
-func (c *Client) get(path string, response interface{}) error {
-	req, err := http.NewRequest("GET", c.baseURL+path, nil)
+// open sends an authenticated request and returns the successful response
+// for the caller to read and close. When the client holds a refreshable
+// token it is renewed shortly before expiry, and a 401 triggers one refresh
+// and retry.
+func (c *Client) open(ctx context.Context, req *apiRequest) (*http.Response, error) {
+	if c.networkErr != nil {
+		return nil, c.networkErr
+	}
+	c.negotiate(ctx)
+	if _, err := c.accessToken(ctx); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.sendWithRetry(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && c.token != nil && c.token.CanRefresh() {
+		resp.Body.Close()
+		if err := c.refresh(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = c.sendWithRetry(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, parseError(resp, bodyBytes)
+	}
+
+	return resp, nil
+}
+
+// accessToken returns the bearer token, refreshed first if it is about to
+// expire
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	if c.token != nil && c.token.CanRefresh() && c.token.ExpiresWithin(refreshSkew) {
+		if err := c.refresh(ctx); err != nil {
+			return "", err
+		}
+	}
+	return c.authToken, nil
+}
+
+func (c *Client) send(ctx context.Context, apiReq *apiRequest) (*http.Response, error) {
+	var bodyReader io.Reader
+	var upload *uploadWatch
+	if apiReq.newBody != nil {
+		// A streamed upload can take longer than the client's overall
+		// timeout, so it is given up when it stalls instead
+		upload, ctx = watchUpload(ctx, apiReq.newBody())
+		bodyReader = upload
+	} else if apiReq.body != nil {
+		bodyReader = bytes.NewReader(apiReq.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, apiReq.method, c.baseURL+apiReq.path, bodyReader)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	if apiReq.contentType != "" {
+		req.Header.Set("Content-Type", apiReq.contentType)
+	} else if apiReq.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range apiReq.header {
+		req.Header[name] = values
+	}
+	if apiReq.idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", apiReq.idempotencyKey)
+	}
 	if c.authToken != "" {
 		req.Header.Set("Authorization", "Bearer "+c.authToken)
 	}
 
+	httpClient := c.httpClient
+	if apiReq.stream || upload != nil {
+		httpClient = &http.Client{Transport: c.httpClient.Transport}
+	}
+	resp, err := httpClient.Do(req)
+	if upload != nil {
+		resp, err = upload.done(resp, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if rateLimit := parseRateLimit(resp.Header); rateLimit != nil {
+		c.rateLimit = rateLimit
+	}
+
+	return resp, nil
+}
+
+// uploadStallTimeout is how long a streamed upload may go without sending
+// a byte, or without an answer once it is all sent, before it is abandoned.
+// Like any network failure, the abandoned upload is retried.
+var uploadStallTimeout = 30 * time.Second
+
+// errUploadStalled is the cause of an upload abandoned after uploadStallTimeout
+var errUploadStalled = errors.New("upload stalled")
+
+// uploadWatch is a streamed upload body that cancels its request when it
+// stops moving. Every read from the body restarts the stall timer.
+type uploadWatch struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// watchUpload starts the stall timer for body, returning the context to send
+// the request with
+func watchUpload(ctx context.Context, body io.ReadCloser) (*uploadWatch, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	u := &uploadWatch{ReadCloser: body, ctx: ctx, cancel: cancel, timeout: uploadStallTimeout}
+	u.timer = time.AfterFunc(u.timeout, func() {
+		cancel(fmt.Errorf("%w: nothing sent or received for %s", errUploadStalled, u.timeout))
+		// The transport waits for the body to be written, so a read stuck
+		// producing it must end too
+		body.Close()
+	})
+	return u, ctx
+}
+
+func (u *uploadWatch) Read(p []byte) (int, error) {
+	n, err := u.ReadCloser.Read(p)
+	if n > 0 || err == io.EOF {
+		u.timer.Reset(u.timeout)
+	}
+	return n, err
+}
+
+// done stops the timer once the response has arrived. The request's context
+// lives until the response body is closed.
+func (u *uploadWatch) done(resp *http.Response, err error) (*http.Response, error) {
+	u.timer.Stop()
+	if err != nil {
+		var urlErr *url.Error
+		if cause := context.Cause(u.ctx); errors.Is(cause, errUploadStalled) && errors.As(err, &urlErr) {
+			urlErr.Err = cause
+		}
+		u.cancel(nil)
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: u.cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+// requestToken posts a grant to the token endpoint
+func (c *Client) requestToken(ctx context.Context, body map[string]string) (*TokenResponse, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/auth/token", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.doTokenRequest(req)
+}
+
+// doTokenRequest sends a token request, decoding OAuth error responses into
+// *OAuthError so callers can act on the error code
+func (c *Client) doTokenRequest(req *http.Request) (*TokenResponse, error) {
+	if c.networkErr != nil {
+		return nil, c.networkErr
+	}
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error (%d): %s", resp.StatusCode, string(bodyBytes))
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	if response != nil {
-		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
-			return fmt.Errorf("failed to decode response: %w", err)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var oauthErr OAuthError
+		if json.Unmarshal(bodyBytes, &oauthErr) == nil && oauthErr.Code != "" {
+			return nil, &oauthErr
 		}
+		return nil, parseError(resp, bodyBytes)
 	}
 
-	return nil
+	var response TokenResponse
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &response, nil
 }
 
 // Response types
+type generateResponse struct {
+	Files map[string]string `json:"files"`
+}
+
 type DeployResponse struct {
 	DeploymentID string `json:"deploymentId"`
 	ProjectID    string `json:"projectId"`
@@ -196,9 +550,43 @@ type StatusResponse struct {
 }
 
 type AuthVerifyResponse struct {
-	Valid  bool   `json:"valid"`
-	UserID string `json:"userId"`
-	Email  string `json:"email"`
+	Valid     bool      `json:"valid"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	TokenType string    `json:"tokenType,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+}
+
+// Token types reported by /api/auth/verify
+const (
+	TokenTypeUser           = "user"
+	TokenTypeServiceAccount = "service_account"
+	TokenTypePersonalAccess = "personal_access_token"
+)
+
+// IsServiceAccount reports whether the verified token belongs to a service account
+func (r *AuthVerifyResponse) IsServiceAccount() bool {
+	return r.TokenType == TokenTypeServiceAccount
+}
+
+// PersonalAccessToken is a scoped automation token. Token (the secret) is
+// only returned when the token is created.
+type PersonalAccessToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Token     string     `json:"token,omitempty"`
+}
+
+type tokenListResponse struct {
+	Tokens []PersonalAccessToken `json:"tokens"`
+}
+
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
 }
 
 type CommitResponse struct {
@@ -208,3 +596,36 @@ type CommitResponse struct {
 	Message    string `json:"message"`
 }
 
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Token converts the response into a token stamped with its issue and expiry time
+func (r *TokenResponse) Token() *auth.Token {
+	return auth.NewToken(r.AccessToken, r.TokenType, r.RefreshToken, r.ExpiresIn)
+}
+
+// OAuthError is an error response from the token endpoint (RFC 6749 section 5.2)
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+	return e.Code
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+// newTestClient starts handler as the API (see testenv.NewAPI) and returns a
+// client for it, authenticated with a test token
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	testenv.NewAPI(t, handler)
+	c := NewClient()
+	c.SetAuthToken("test-token")
+	return c
+}
+
+// TestRequestCancelled stops waiting for a slow response as soon as the
+// context is cancelled, as Ctrl-C does
+func TestRequestCancelled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	project := testProject(t, map[string]string{"main.py": "print('hi')\n"})
+
+	calls := map[string]func(ctx context.Context) error{
+		"GetStatus": func(ctx context.Context) error { _, err := c.GetStatus(ctx, "dep-1"); return err },
+		"DeployProject (JSON)": func(ctx context.Context) error {
+			c.deltaUnsupported, c.archiveUnsupported = true, true
+			_, _, err := c.DeployProject(ctx, project, "proj-1")
+			return err
+		},
+		"DeployProject (archive)": func(ctx context.Context) error {
+			c.deltaUnsupported, c.archiveUnsupported = true, false
+			_, _, err := c.DeployProject(ctx, project, "proj-1")
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			start := time.Now()
+			if err := call(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("err = %v, want context.Canceled", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("returned %s after cancelling", elapsed)
+			}
+		})
+	}
+}
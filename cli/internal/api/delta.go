@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/backend-im/cli/internal/files"
+)
+
+// postDelta uploads the project content-addressed: it sends the hash of every
+// file, uploads only the blobs the server doesn't already have, then posts a
+// manifest mapping paths to hashes instead of the files themselves
+func (c *Client) postDelta(ctx context.Context, path string, project *files.Project, manifest UploadManifest, response interface{}, stats *UploadStats) error {
+	if err := project.HashFiles(); err != nil {
+		return err
+	}
+
+	pathHashes := make(map[string]string, len(project.Files))
+	var hashes []string
+	for _, f := range project.Files {
+		pathHashes[f.Path] = f.Hash
+		hashes = append(hashes, f.Hash)
+	}
+
+	missing, err := c.missingBlobs(ctx, hashes)
+	if err != nil {
+		var apiErr *Error
+		if errors.As(err, &apiErr) && (apiErr.IsNotFound() || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+			return errDeltaUnsupported
+		}
+		return err
+	}
+
+	stats.ChangedFiles, stats.SentBytes = 0, 0
+	sent := make(map[string]bool)
+	for _, f := range project.Files {
+		if !missing[f.Hash] {
+			continue
+		}
+		stats.ChangedFiles++
+		if !sent[f.Hash] {
+			sent[f.Hash] = true
+			stats.SentBytes += f.Size
+		}
+	}
+
+	c.pruneUploads(project, missing)
+	if len(missing) > 0 {
+		if err := c.sendBlobs(ctx, project, missing, stats.SentBytes); err != nil {
+			return err
+		}
+	}
+
+	body := map[string]interface{}{
+		"projectId": manifest.ProjectID,
+		"manifest":  pathHashes,
+	}
+	if manifest.Message != "" {
+		body["message"] = manifest.Message
+	}
+	return c.postIdempotent(ctx, path, body, response)
+}
+
+type missingBlobsResponse struct {
+	Missing []string `json:"missing"`
+}
+
+// missingBlobs asks the server which of hashes it doesn't have stored
+func (c *Client) missingBlobs(ctx context.Context, hashes []string) (map[string]bool, error) {
+	body, err := json.Marshal(map[string]interface{}{"hashes": hashes})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var response missingBlobsResponse
+	err = c.do(ctx, &apiRequest{method: "POST", path: "/api/blobs/missing", body: body, safe: true}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[string]bool, len(response.Missing))
+	for _, hash := range response.Missing {
+		missing[hash] = true
+	}
+	return missing, nil
+}
+
+// sendBlobs uploads the blobs with the given hashes, through a resumable
+// session when there are enough bytes that restarting would hurt. This is
+// the only resumable path: archive and JSON uploads are single requests.
+func (c *Client) sendBlobs(ctx context.Context, project *files.Project, hashes map[string]bool, size int64) error {
+	if size >= resumableThreshold && !c.sessionsUnsupported {
+		err := c.uploadBlobsResumable(ctx, project, hashes)
+		if !errors.Is(err, errSessionsUnsupported) {
+			return err
+		}
+		c.sessionsUnsupported = true
+	}
+
+	return c.uploadBlobs(ctx, project, hashes)
+}
+
+// uploadBlobs streams the files with the given hashes as an archive of blobs
+// named by hash. Storing a blob is naturally idempotent, so it is sent with
+// PUT and retried like any other idempotent request.
+func (c *Client) uploadBlobs(ctx context.Context, project *files.Project, hashes map[string]bool) error {
+	return c.do(ctx, &apiRequest{
+		method:      "PUT",
+		path:        "/api/blobs",
+		contentType: ArchiveContentType,
+		newBody: func() io.ReadCloser {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(project.WriteBlobArchive(pw, hashes))
+			}()
+			return pr
+		},
+	}, nil)
+}
+
+// errDeltaUnsupported means the server has no blob store: the negotiation
+// endpoint doesn't exist
+var errDeltaUnsupported = errors.New("server does not support delta uploads")
+
+// fallBackFromDelta reports whether err means the server doesn't support
+// delta uploads, remembering it for later uploads
+func (c *Client) fallBackFromDelta(err error) bool {
+	if !errors.Is(err, errDeltaUnsupported) {
+		return false
+	}
+
+	fmt.Fprintln(os.Stderr, "⚠️  Server does not support delta uploads, sending the whole project")
+	c.deltaUnsupported = true
+	return true
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/backend-im/cli/internal/files"
+)
+
+// blobServer is a content-addressed blob store in memory. Like the real one,
+// it rejects blobs whose content doesn't match their hash, and deployments
+// must name only stored blobs.
+type blobServer struct {
+	mu       sync.Mutex
+	blobs    map[string]string
+	uploaded []string
+	manifest map[string]string
+}
+
+func (s *blobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/blobs/missing":
+		var req struct{ Hashes []string }
+		json.NewDecoder(r.Body).Decode(&req)
+		missing := []string{}
+		for _, hash := range req.Hashes {
+			if _, ok := s.blobs[hash]; !ok {
+				missing = append(missing, hash)
+			}
+		}
+		json.NewEncoder(w).Encode(missingBlobsResponse{Missing: missing})
+	case r.Method == "PUT" && r.URL.Path == "/api/blobs":
+		entries, err := readArchive(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for hash, content := range entries {
+			if sum := sha256.Sum256([]byte(content)); hex.EncodeToString(sum[:]) != hash {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": {"code": "bad_request", "message": "Blob ` + hash + ` does not match its hash"}}`))
+				return
+			}
+		}
+		for hash, content := range entries {
+			s.blobs[hash] = content
+			s.uploaded = append(s.uploaded, hash)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && r.URL.Path == "/api/deploy":
+		var req struct{ Manifest map[string]string }
+		json.NewDecoder(r.Body).Decode(&req)
+		for path, hash := range req.Manifest {
+			if _, ok := s.blobs[hash]; !ok {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(`{"error": {"code": "missing_blobs", "message": "Blob missing for ` + path + `"}}`))
+				return
+			}
+		}
+		s.manifest = req.Manifest
+		w.Write([]byte(`{"deploymentId": "dep-1", "status": "queued"}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDeltaUpload(t *testing.T) {
+	server := &blobServer{blobs: make(map[string]string)}
+	c := newTestClient(t, server)
+
+	project := testProject(t, map[string]string{
+		"main.py":          "print('hi')\n",
+		"copy.py":          "print('hi')\n",
+		"requirements.txt": "fastapi\n",
+	})
+
+	// The first deployment sends every distinct blob once
+	_, stats, err := c.DeployProject(context.Background(), project, "proj-1")
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if stats.Method != UploadDelta || len(server.uploaded) != 2 {
+		t.Errorf("uploaded %d blobs with %s, want 2 with %s", len(server.uploaded), stats.Method, UploadDelta)
+	}
+	if stats.ChangedFiles != 3 || stats.SentBytes != 20 || stats.SavedBytes() != 12 {
+		t.Errorf("stats %+v, want 3 changed files and 20 of 32 bytes sent", stats)
+	}
+	if len(server.manifest) != 3 || server.manifest["main.py"] != server.manifest["copy.py"] {
+		t.Errorf("manifest %v", server.manifest)
+	}
+
+	// The next one sends only what changed
+	server.uploaded = nil
+	if err := os.WriteFile(filepath.Join(project.Dir, "main.py"), []byte("print('bye')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if project, err = files.ScanProject(project.Dir); err != nil {
+		t.Fatal(err)
+	}
+	_, stats, err = c.DeployProject(context.Background(), project, "proj-1")
+	if err != nil {
+		t.Fatalf("second deploy: %v", err)
+	}
+	if len(server.uploaded) != 1 || server.blobs[server.uploaded[0]] != "print('bye')\n" {
+		t.Errorf("second deploy uploaded %v, want only the new main.py", server.uploaded)
+	}
+	if stats.ChangedFiles != 1 || stats.SentBytes != 13 {
+		t.Errorf("stats %+v, want 1 changed file and 13 bytes sent", stats)
+	}
+}
+
+// TestDeltaUploadFollowsSymlinks uploads a symlinked file as the blob of the
+// content it points to
+func TestDeltaUploadFollowsSymlinks(t *testing.T) {
+	server := &blobServer{blobs: make(map[string]string)}
+	c := newTestClient(t, server)
+
+	project := testProject(t, map[string]string{"r.py": "print('a longer file than its link')\n"})
+	if err := os.Symlink("r.py", filepath.Join(project.Dir, "link.py")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	project, err := files.ScanProject(project.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stats, err := c.DeployProject(context.Background(), project, "proj-1")
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if stats.Method != UploadDelta || len(server.uploaded) != 1 {
+		t.Errorf("uploaded %d blobs with %s, want 1 with %s", len(server.uploaded), stats.Method, UploadDelta)
+	}
+	if hash := server.manifest["link.py"]; hash == "" || hash != server.manifest["r.py"] {
+		t.Errorf("manifest %v, want link.py to share the blob of r.py", server.manifest)
+	}
+}
+
+// TestDeltaUploadFallsBack sends the whole project when the server has no
+// blob store, and doesn't ask again
+func TestDeltaUploadFallsBack(t *testing.T) {
+	server := &archiveServer{}
+	c := newArchiveClient(t, server)
+	c.deltaUnsupported = false
+	project := testProject(t, archiveFiles)
+
+	_, stats, err := c.DeployProject(context.Background(), project, "proj-1")
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if stats.Method != UploadArchive || !c.deltaUnsupported || stats.SavedBytes() != 0 {
+		t.Errorf("uploaded with %s saving %d bytes, delta unsupported %v", stats.Method, stats.SavedBytes(), c.deltaUnsupported)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Machine-readable error codes sent by the API
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal_error"
+	CodeUnavailable      = "unavailable"
+)
+
+// Error is a failed API response. The API sends errors as a JSON envelope:
+//
+//	{"error": {"code": "not_found", "message": "...", "details": {...}, "requestId": "..."}}
+//
+// Responses that are not in that shape still produce an Error, with the code
+// derived from the status and the raw body as the message.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    map[string]interface{}
+	RequestID  string
+
+	// RetryAfter is how long the server asked us to wait before retrying
+	// (429 and 503 responses); zero if it didn't say
+	RetryAfter time.Duration
+}
+
+// errorEnvelope is the wire format of an API error
+type errorEnvelope struct {
+	Error *struct {
+		Code      string                 `json:"code"`
+		Message   string                 `json:"message"`
+		Details   map[string]interface{} `json:"details,omitempty"`
+		RequestID string                 `json:"requestId,omitempty"`
+	} `json:"error"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("API error (%d %s): %s", e.StatusCode, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request ID: %s)", e.RequestID)
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(" - retry after %s", e.RetryAfter.Round(time.Second))
+	}
+	return msg
+}
+
+// IsAuth reports whether the request was rejected for missing or invalid credentials
+func (e *Error) IsAuth() bool {
+	return e.StatusCode == http.StatusUnauthorized
+}
+
+// IsForbidden reports whether the credentials lack permission for the request
+func (e *Error) IsForbidden() bool {
+	return e.StatusCode == http.StatusForbidden
+}
+
+// IsNotFound reports whether the requested resource does not exist
+func (e *Error) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether the request conflicts with the resource's current state
+func (e *Error) IsConflict() bool {
+	return e.StatusCode == http.StatusConflict
+}
+
+// IsValidation reports whether the server rejected the request itself as
+// malformed or invalid, e.g. a checksum mismatch or a reused idempotency key
+func (e *Error) IsValidation() bool {
+	return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+}
+
+// IsRateLimited reports whether the request was throttled
+func (e *Error) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsServer reports whether the API failed to handle a valid request
+func (e *Error) IsServer() bool {
+	return e.StatusCode >= 500
+}
+
+// parseError builds an Error from a non-2xx response body
+func parseError(resp *http.Response, body []byte) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+
+	var envelope errorEnvelope
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != nil {
+		apiErr.Code = envelope.Error.Code
+		apiErr.Message = envelope.Error.Message
+		apiErr.Details = envelope.Error.Details
+		if envelope.Error.RequestID != "" {
+			apiErr.RequestID = envelope.Error.RequestID
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+
+	if apiErr.Code == "" {
+		apiErr.Code = codeForStatus(resp.StatusCode)
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	if wait, ok := retryAfter(resp); ok {
+		apiErr.RetryAfter = wait
+	}
+
+	return apiErr
+}
+
+// codeForStatus picks a code for responses that did not include one
+func codeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return CodeUnauthorized
+	case status == http.StatusForbidden:
+		return CodeForbidden
+	case status == http.StatusNotFound:
+		return CodeNotFound
+	case status == http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case status == http.StatusConflict:
+		return CodeConflict
+	case status == http.StatusTooManyRequests:
+		return CodeRateLimited
+	case status == http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case status >= 500:
+		return CodeInternal
+	default:
+		return CodeBadRequest
+	}
+}
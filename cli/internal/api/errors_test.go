@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		body   string
+		want   Error
+	}{
+		{
+			name:   "envelope",
+			status: http.StatusNotFound,
+			header: http.Header{"X-Request-Id": {"req_header"}},
+			body:   `{"error": {"code": "token_not_found", "message": "Token not found", "details": {"id": "tok_1"}, "requestId": "req_body"}}`,
+			want:   Error{StatusCode: 404, Code: "token_not_found", Message: "Token not found", RequestID: "req_body"},
+		},
+		{
+			name:   "request ID from header",
+			status: http.StatusConflict,
+			header: http.Header{"X-Request-Id": {"req_header"}},
+			body:   `{"error": {"message": "Already deploying"}}`,
+			want:   Error{StatusCode: 409, Code: CodeConflict, Message: "Already deploying", RequestID: "req_header"},
+		},
+		{
+			name:   "plain text body",
+			status: http.StatusBadGateway,
+			body:   "upstream connect error\n",
+			want:   Error{StatusCode: 502, Code: CodeInternal, Message: "upstream connect error"},
+		},
+		{
+			name:   "empty body",
+			status: http.StatusUnauthorized,
+			want:   Error{StatusCode: 401, Code: CodeUnauthorized, Message: "Unauthorized"},
+		},
+		{
+			name:   "retry after",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After": {"30"}},
+			body:   `{"error": {"code": "rate_limited", "message": "Slow down"}}`,
+			want:   Error{StatusCode: 429, Code: CodeRateLimited, Message: "Slow down", RetryAfter: 30 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			got := parseError(&http.Response{StatusCode: tt.status, Header: header}, []byte(tt.body))
+			if got.StatusCode != tt.want.StatusCode || got.Code != tt.want.Code || got.Message != tt.want.Message ||
+				got.RequestID != tt.want.RequestID || got.RetryAfter != tt.want.RetryAfter {
+				t.Errorf("parseError = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	tests := []struct {
+		status int
+		is     func(*Error) bool
+	}{
+		{http.StatusBadRequest, (*Error).IsValidation},
+		{http.StatusUnauthorized, (*Error).IsAuth},
+		{http.StatusForbidden, (*Error).IsForbidden},
+		{http.StatusNotFound, (*Error).IsNotFound},
+		{http.StatusConflict, (*Error).IsConflict},
+		{http.StatusUnprocessableEntity, (*Error).IsValidation},
+		{http.StatusTooManyRequests, (*Error).IsRateLimited},
+		{http.StatusServiceUnavailable, (*Error).IsServer},
+	}
+
+	for _, tt := range tests {
+		if !tt.is(&Error{StatusCode: tt.status}) {
+			t.Errorf("%d is not in its category", tt.status)
+		}
+	}
+	if (&Error{StatusCode: http.StatusUnprocessableEntity}).IsConflict() {
+		t.Errorf("422 is reported as a conflict")
+	}
+}
@@ -0,0 +1,432 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// RecordEnv and ReplayEnv route API traffic through a fixture file, so
+// commands can be tested without a server: record against a live API once,
+// then replay the file offline
+const (
+	RecordEnv = "BACKEND_IM_RECORD"
+	ReplayEnv = "BACKEND_IM_REPLAY"
+)
+
+// Fixture is a recording of API traffic: HTTP request/response pairs and the
+// frames of WebSocket streams. Secrets are scrubbed as they are recorded,
+// like in the debug trace, so fixtures can be committed.
+//
+// A recording Fixture passes requests on to the network and appends them to
+// its file; a replaying one answers from the file without touching the
+// network. Requests are matched by method, path, query and body, with JSON
+// bodies normalized, and each recorded answer is served once, in order; the
+// last match is repeated once they're used up, e.g. for status polling.
+type Fixture struct {
+	Interactions []*Interaction    `json:"interactions"`
+	WebSockets   []*WebSocketTrace `json:"websockets,omitempty"`
+
+	mu        sync.Mutex
+	path      string
+	recording bool
+	base      http.RoundTripper
+}
+
+// Interaction is one recorded HTTP exchange
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+	replayed bool
+}
+
+// RecordedRequest is a request as recorded, with secrets scrubbed
+type RecordedRequest struct {
+	Method string        `json:"method"`
+	Path   string        `json:"path"`
+	Query  string        `json:"query,omitempty"`
+	Header http.Header   `json:"header,omitempty"`
+	Body   *RecordedBody `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response as recorded, with secrets scrubbed
+type RecordedResponse struct {
+	Status int           `json:"status"`
+	Header http.Header   `json:"header,omitempty"`
+	Body   *RecordedBody `json:"body,omitempty"`
+}
+
+// RecordedBody holds a JSON body as JSON, text as-is and anything else as
+// base64. Binary request bodies such as upload archives only keep their
+// hash, which is all matching needs.
+type RecordedBody struct {
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Text   string          `json:"text,omitempty"`
+	Base64 []byte          `json:"base64,omitempty"`
+	SHA256 string          `json:"sha256,omitempty"`
+	Size   int             `json:"size"`
+}
+
+// WebSocketTrace is one recorded WebSocket stream: the frames the server
+// sent and the close code it ended with, if any
+type WebSocketTrace struct {
+	Path      string          `json:"path"`
+	Query     string          `json:"query,omitempty"`
+	Frames    []*RecordedBody `json:"frames"`
+	CloseCode int             `json:"closeCode,omitempty"`
+	replayed  bool
+}
+
+// RecordFixture records API traffic to path. Recording to an existing
+// fixture adds to it, so a sequence of commands can build one fixture.
+func RecordFixture(path string) (*Fixture, error) {
+	base, err := networkTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Fixture{path: path, recording: true, base: base}
+	if err := f.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReplayFixture answers API requests from the fixture at path
+func ReplayFixture(path string) (*Fixture, error) {
+	f := &Fixture{path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Fixture) load() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, f); err != nil {
+		return fmt.Errorf("invalid fixture %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// save rewrites the fixture after every recorded exchange, so the recording
+// survives however the command ends. Caller holds f.mu.
+func (f *Fixture) save() error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, data, 0600)
+}
+
+// RoundTrip records or replays one request
+func (f *Fixture) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := recordRequest(req, body)
+
+	if !f.recording {
+		return f.replay(req, recorded)
+	}
+
+	// The body was consumed above, so send a copy
+	sent := req.Clone(req.Context())
+	sent.Body = io.NopCloser(bytes.NewReader(body))
+	sent.ContentLength = int64(len(body))
+	resp, err := f.base.RoundTrip(sent)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Interactions = append(f.Interactions, &Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: scrubHeader(resp.Header),
+			Body:   recordBody(req.URL.Path, resp.Header.Get("Content-Type"), data, true),
+		},
+	})
+	if err := f.save(); err != nil {
+		return nil, fmt.Errorf("failed to save fixture: %w", err)
+	}
+	return resp, nil
+}
+
+func (f *Fixture) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := recorded.matchKey()
+	var match *Interaction
+	for _, interaction := range f.Interactions {
+		if interaction.Request.matchKey() != key {
+			continue
+		}
+		match = interaction
+		if !interaction.replayed {
+			break
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no recorded response for %s %s in fixture %s", recorded.Method, recorded.Path, f.path)
+	}
+	match.replayed = true
+
+	var body []byte
+	if b := match.Response.Body; b != nil {
+		body = b.bytes()
+	}
+	header := match.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", match.Response.Status, http.StatusText(match.Response.Status)),
+		StatusCode:    match.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// dialWebSocket connects a WebSocket through the fixture: recording wraps
+// the connection dial makes, replaying serves the recorded frames instead
+func (f *Fixture) dialWebSocket(rawURL string, dial func() (wsConn, error)) (wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	path, query := u.Path, scrubQuery(u.Path, u.Query())
+
+	if !f.recording {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		var match *WebSocketTrace
+		for _, stream := range f.WebSockets {
+			if stream.Path != path || stream.Query != query {
+				continue
+			}
+			match = stream
+			if !stream.replayed {
+				break
+			}
+		}
+		if match == nil {
+			return nil, fmt.Errorf("no recorded WebSocket for %s in fixture %s", path, f.path)
+		}
+		match.replayed = true
+		return &replayConn{frames: match.Frames, closeCode: match.CloseCode}, nil
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stream := &WebSocketTrace{Path: path, Query: query, Frames: []*RecordedBody{}}
+	f.WebSockets = append(f.WebSockets, stream)
+	if err := f.save(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to save fixture: %w", err)
+	}
+	return &recordingConn{wsConn: conn, fixture: f, stream: stream}, nil
+}
+
+// recordingConn appends every frame it reads to the fixture
+type recordingConn struct {
+	wsConn
+	fixture *Fixture
+	stream  *WebSocketTrace
+}
+
+func (c *recordingConn) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.wsConn.ReadMessage()
+
+	c.fixture.mu.Lock()
+	defer c.fixture.mu.Unlock()
+	var closeErr *websocket.CloseError
+	switch {
+	case err == nil:
+		contentType := ""
+		if messageType == websocket.BinaryMessage {
+			contentType = "application/octet-stream"
+		}
+		c.stream.Frames = append(c.stream.Frames, recordBody(c.stream.Path, contentType, data, true))
+	case errors.As(err, &closeErr):
+		c.stream.CloseCode = closeErr.Code
+	default:
+		return messageType, data, err
+	}
+	if saveErr := c.fixture.save(); saveErr != nil && err == nil {
+		err = fmt.Errorf("failed to save fixture: %w", saveErr)
+	}
+	return messageType, data, err
+}
+
+// replayConn plays back recorded frames, then the recorded close
+type replayConn struct {
+	frames    []*RecordedBody
+	closeCode int
+}
+
+func (c *replayConn) ReadMessage() (int, []byte, error) {
+	if len(c.frames) == 0 {
+		code := c.closeCode
+		if code == 0 {
+			code = websocket.CloseAbnormalClosure
+		}
+		return 0, nil, &websocket.CloseError{Code: code}
+	}
+
+	frame := c.frames[0]
+	c.frames = c.frames[1:]
+	if frame.Base64 != nil {
+		return websocket.BinaryMessage, frame.Base64, nil
+	}
+	return websocket.TextMessage, frame.bytes(), nil
+}
+
+func (c *replayConn) WriteControl(int, []byte, time.Time) error { return nil }
+func (c *replayConn) SetReadDeadline(time.Time) error           { return nil }
+func (c *replayConn) SetPongHandler(func(string) error)         {}
+func (c *replayConn) SetPingHandler(func(string) error)         {}
+func (c *replayConn) Close() error                              { return nil }
+
+// readRequestBody reads and closes a request's body, streamed or not
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return body, nil
+}
+
+func recordRequest(req *http.Request, body []byte) RecordedRequest {
+	return RecordedRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  scrubQuery(req.URL.Path, req.URL.Query()),
+		Header: scrubHeader(req.Header),
+		Body:   recordBody(req.URL.Path, req.Header.Get("Content-Type"), body, false),
+	}
+}
+
+// recordBody scrubs and stores a body sent to or received from path.
+// keepBinary keeps binary content rather than just its hash.
+func recordBody(path, contentType string, body []byte, keepBinary bool) *RecordedBody {
+	if len(body) == 0 {
+		return nil
+	}
+
+	recorded := &RecordedBody{Size: len(body)}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case json.Valid(body) && (mediaType == "application/json" || mediaType == ""):
+		recorded.JSON = redactJSON(path, body)
+	case strings.HasPrefix(mediaType, "text/") || (mediaType == "" && utf8.Valid(body)):
+		recorded.Text = string(body)
+	case keepBinary:
+		recorded.Base64 = body
+	default:
+		sum := sha256.Sum256(body)
+		recorded.SHA256 = hex.EncodeToString(sum[:])
+	}
+	return recorded
+}
+
+func (b *RecordedBody) bytes() []byte {
+	switch {
+	case b.JSON != nil:
+		return b.JSON
+	case b.Base64 != nil:
+		return b.Base64
+	}
+	return []byte(b.Text)
+}
+
+// matchKey identifies a request for replay. JSON bodies were normalized by
+// scrubbing, which re-encodes them with sorted keys; compacting undoes the
+// indentation they get in the fixture file.
+func (r *RecordedRequest) matchKey() string {
+	key := r.Method + " " + r.Path + "?" + r.Query
+	if b := r.Body; b != nil {
+		switch {
+		case b.JSON != nil:
+			var compact bytes.Buffer
+			json.Compact(&compact, b.JSON)
+			key += "\n" + compact.String()
+		case b.SHA256 != "":
+			key += "\nsha256:" + b.SHA256
+		default:
+			key += "\n" + string(b.bytes())
+		}
+	}
+	return key
+}
+
+// scrubHeader copies a header with credentials redacted
+func scrubHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	clean := header.Clone()
+	for name, values := range clean {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			for i, value := range values {
+				values[i] = redactHeader(value)
+			}
+		}
+	}
+	clean.Del("Date")
+	return clean
+}
+
+// scrubQuery encodes a query to path with secret parameters redacted and
+// the parameters sorted
+func scrubQuery(path string, query url.Values) string {
+	for key := range query {
+		if isRedacted(path, key) {
+			query.Set(key, redacted)
+		}
+	}
+	return query.Encode()
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/backend-im/cli/internal/files"
+	"github.com/backend-im/cli/internal/testenv"
+	"github.com/gorilla/websocket"
+)
+
+// fixtureServer is a minimal API that hands out a secret and streams a
+// deployment
+func fixtureServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/auth/token":
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "secret-access", TokenType: "Bearer", ExpiresIn: 3600})
+		case "/api/deploy":
+			var req map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(DeployResponse{DeploymentID: "dep-1", ProjectID: req["projectId"].(string), Status: "queued"})
+		case "/ws":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for _, status := range []string{"building", "complete"} {
+				conn.WriteJSON(DeploymentUpdate{DeploymentID: "dep-1", Status: status})
+			}
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "not_found", "message": "Not found"}}`))
+		}
+	}))
+}
+
+// newFixtureClient returns a client that uploads projects as JSON, the
+// format fixtureServer understands
+func newFixtureClient() *Client {
+	c := NewClient()
+	c.SetRetryPolicy(RetryPolicy{})
+	c.deltaUnsupported, c.archiveUnsupported = true, true
+	return c
+}
+
+// session runs the calls a fixture should capture
+func session(t *testing.T, apiURL string, project *files.Project) (*TokenResponse, *DeployResponse, []string) {
+	t.Helper()
+	ctx := context.Background()
+	c := newFixtureClient()
+	c.SetAuthToken("secret-bearer")
+
+	token, err := c.RefreshAccessToken(ctx, "secret-refresh")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	deploy, _, err := c.DeployProject(ctx, project, "proj-1")
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+
+	ws := NewWebSocketClient(apiURL)
+	if err := ws.Connect(ctx, deploy.DeploymentID); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	var statuses []string
+	err = ws.StreamUpdates(ctx, func(update *DeploymentUpdate) error {
+		statuses = append(statuses, update.Status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	return token, deploy, statuses
+}
+
+func TestFixtureRecordAndReplay(t *testing.T) {
+	t.Cleanup(func() { SetTransport(nil) })
+	path := filepath.Join(t.TempDir(), "fixture.json")
+
+	recorder, err := RecordFixture(path)
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	SetTransport(recorder)
+	server := fixtureServer(t)
+	project := testProject(t, map[string]string{"main.py": "print('hi')"})
+	wantToken, wantDeploy, wantStatuses := session(t, server.URL, project)
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	for _, secret := range []string{"secret-access", "secret-bearer", "secret-refresh"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("fixture contains %q", secret)
+		}
+	}
+
+	// The server is gone: everything must come from the fixture
+	replayer, err := ReplayFixture(path)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	SetTransport(replayer)
+	token, deploy, statuses := session(t, server.URL, project)
+
+	if token.AccessToken != redacted || token.ExpiresIn != wantToken.ExpiresIn {
+		t.Errorf("replayed token = %+v, want %+v with the secret scrubbed", token, wantToken)
+	}
+	if *deploy != *wantDeploy {
+		t.Errorf("replayed deploy = %+v, want %+v", deploy, wantDeploy)
+	}
+	if strings.Join(statuses, ",") != strings.Join(wantStatuses, ",") {
+		t.Errorf("replayed statuses = %v, want %v", statuses, wantStatuses)
+	}
+
+	// Requests are matched on their body too
+	changed := testProject(t, map[string]string{"main.py": "changed"})
+	if _, _, err := newFixtureClient().DeployProject(context.Background(), changed, "proj-1"); err == nil ||
+		!strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("deploy with a different body: err = %v, want no recorded response", err)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// longPollWait is how long each poll asks the server to wait for an update,
+// well within the client's request timeout
+const longPollWait = 20 * time.Second
+
+// longPollInterval is the least time between polls that came back empty,
+// for servers that answer at once instead of waiting
+const longPollInterval = time.Second
+
+// DeploymentUpdateList is the response of a long poll
+type DeploymentUpdateList struct {
+	Updates []DeploymentUpdate `json:"updates"`
+
+	// Finished is set once the deployment is complete or failed: Updates
+	// holds every update left, and polling again won't bring more
+	Finished bool `json:"finished"`
+}
+
+// PollDeploymentUpdates returns the deployment's updates after sequence
+// number since. When there are none yet and the deployment is running, the
+// server waits up to wait for the next one, and answers with none if it
+// doesn't come.
+func (c *Client) PollDeploymentUpdates(ctx context.Context, deploymentID string, since int64, wait time.Duration) (*DeploymentUpdateList, error) {
+	var response DeploymentUpdateList
+	path := fmt.Sprintf("/api/deployments/%s/updates?since=%d&wait=%d", deploymentID, since, int(wait.Seconds()))
+	if err := c.get(ctx, path, &response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// longPollWatcher follows a deployment by long polling, for networks that
+// let nothing but plain requests through
+type longPollWatcher struct {
+	api *Client
+}
+
+func (w *longPollWatcher) Transport() string {
+	return TransportLongPoll
+}
+
+func (w *longPollWatcher) Watch(ctx context.Context, deploymentID string, since int64, callback func(*DeploymentUpdate) error) error {
+	for {
+		start := time.Now()
+		list, err := w.api.PollDeploymentUpdates(ctx, deploymentID, since, longPollWait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		for i := range list.Updates {
+			update := &list.Updates[i]
+			if update.Seq == 0 {
+				return fmt.Errorf("the server doesn't number updates, so they can't be long-polled")
+			}
+			if update.Seq <= since {
+				continue
+			}
+			if err := callback(update); err != nil {
+				return err
+			}
+			since = update.Seq
+			if update.final() {
+				return nil
+			}
+		}
+
+		if list.Finished {
+			// Everything left was delivered, e.g. when resuming after the
+			// final update
+			return nil
+		}
+		if len(list.Updates) == 0 {
+			timer := time.NewTimer(longPollInterval - time.Since(start))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+}
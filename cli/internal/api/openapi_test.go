@@ -0,0 +1,441 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/files"
+	"github.com/backend-im/cli/internal/testenv"
+	"github.com/backend-im/openapi"
+	"github.com/gorilla/websocket"
+)
+
+type object = openapi.Object
+
+// spec is the API contract the client must conform to
+type spec struct {
+	*openapi.Spec
+}
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	s, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &spec{s}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// checkType returns where a Go type decodes JSON the schema doesn't
+// describe: fields the spec doesn't have, or of a different type
+func (s *spec) checkType(t reflect.Type, schema object, at string) []string {
+	schema = s.Resolve(schema)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	want, _ := schema["type"].(string)
+	mismatch := func() []string {
+		return []string{fmt.Sprintf("%s: Go type %s, spec type %q", at, t, want)}
+	}
+
+	if t == timeType {
+		if want != "string" || schema["format"] != "date-time" {
+			return mismatch()
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if want != "object" {
+			return mismatch()
+		}
+		properties, _ := schema["properties"].(object)
+		var errs []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property, ok := properties[name].(object)
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: field %s (%q) is not in the spec", at, field.Name, name))
+				continue
+			}
+			errs = append(errs, s.checkType(field.Type, property, at+"."+name)...)
+		}
+		return errs
+	case reflect.Map:
+		if want != "object" {
+			return mismatch()
+		}
+		if values, ok := schema["additionalProperties"].(object); ok {
+			return s.checkType(t.Elem(), values, at+"[]")
+		}
+	case reflect.Slice:
+		if want != "array" {
+			return mismatch()
+		}
+		return s.checkType(t.Elem(), schema["items"].(object), at+"[]")
+	case reflect.String:
+		if want != "string" {
+			return mismatch()
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if want != "integer" {
+			return mismatch()
+		}
+	case reflect.Bool:
+		if want != "boolean" {
+			return mismatch()
+		}
+	}
+	return nil
+}
+
+// clientTypes are the types the client decodes responses into, by the
+// operation and status that return them
+var clientTypes = []struct {
+	operation string
+	status    string
+	value     interface{}
+}{
+	{"GET /api/version", "200", ServerInfo{}},
+	{"POST /api/generate", "200", generateResponse{}},
+	{"POST /api/deploy", "200", DeployResponse{}},
+	{"POST /api/commit", "200", CommitResponse{}},
+	{"GET /api/status/{deploymentId}", "200", StatusResponse{}},
+	{"GET /api/status/{deploymentId}", "default", errorEnvelope{}},
+	{"GET /api/auth/callback", "200", TokenResponse{}},
+	{"GET /api/auth/callback", "400", OAuthError{}},
+	{"GET /api/auth/verify", "200", AuthVerifyResponse{}},
+	{"POST /api/auth/device/code", "200", DeviceCodeResponse{}},
+	{"POST /api/auth/token", "200", TokenResponse{}},
+	{"POST /api/auth/token", "400", OAuthError{}},
+	{"DELETE /api/auth/sessions", "200", RevokeSessionsResponse{}},
+	{"GET /api/tokens", "200", tokenListResponse{}},
+	{"POST /api/tokens", "201", PersonalAccessToken{}},
+	{"POST /api/blobs/missing", "200", missingBlobsResponse{}},
+	{"POST /api/uploads", "201", UploadSession{}},
+	{"GET /api/uploads/{uploadId}", "200", UploadSession{}},
+	{"POST /api/ws/tickets", "201", WebSocketTicket{}},
+	{"GET /api/deployments/{deploymentId}/updates", "200", DeploymentUpdateList{}},
+}
+
+func TestClientTypesMatchSpec(t *testing.T) {
+	s := loadSpec(t)
+
+	for _, ct := range clientTypes {
+		method, path, _ := strings.Cut(ct.operation, " ")
+		schema := s.Schema("paths", path, strings.ToLower(method), "responses", ct.status, "content", "application/json", "schema")
+		if schema == nil {
+			t.Errorf("%s %s: the spec declares no JSON response", ct.operation, ct.status)
+			continue
+		}
+		for _, err := range s.checkType(reflect.TypeOf(ct.value), schema, fmt.Sprintf("%T", ct.value)) {
+			t.Errorf("%s %s: %s", ct.operation, ct.status, err)
+		}
+	}
+
+	messages := s.Schema("paths", "/ws", "get", "x-websocket", "serverMessages")
+	for _, err := range s.checkType(reflect.TypeOf(DeploymentUpdate{}), messages, "DeploymentUpdate") {
+		t.Errorf("GET /ws: %s", err)
+	}
+	events := s.Schema("paths", "/api/deployments/{deploymentId}/events", "get", "x-sse", "events", "update")
+	for _, err := range s.checkType(reflect.TypeOf(DeploymentUpdate{}), events, "DeploymentUpdate") {
+		t.Errorf("GET /api/deployments/{deploymentId}/events: %s", err)
+	}
+
+	manifest := s.Schema("components", "schemas", "ArchiveManifest")
+	for _, err := range s.checkType(reflect.TypeOf(UploadManifest{}), manifest, "UploadManifest") {
+		t.Errorf("%s: %s", ManifestHeader, err)
+	}
+}
+
+// checkRequest returns where a request the client sent strays from its
+// operation: undeclared or missing parameters, or a body the spec doesn't
+// accept. It also returns the body.
+func (s *spec) checkRequest(op object, r *http.Request) ([]byte, []string) {
+	var errs []string
+
+	declared := make(map[string]bool)
+	parameters, _ := op["parameters"].([]interface{})
+	for _, p := range parameters {
+		param := s.Resolve(p.(object))
+		name, in := param["name"].(string), param["in"].(string)
+		required, _ := param["required"].(bool)
+		switch in {
+		case "query":
+			declared[name] = true
+			if required && !r.URL.Query().Has(name) {
+				errs = append(errs, fmt.Sprintf("missing query parameter %q", name))
+			}
+			schema := param["schema"].(object)
+			for _, value := range r.URL.Query()[name] {
+				var typed interface{} = value
+				if n, err := strconv.ParseFloat(value, 64); err == nil && schema["type"] == "integer" {
+					// Query parameters are strings on the wire
+					typed = n
+				}
+				errs = append(errs, s.Validate(schema, typed, "query "+name)...)
+			}
+		case "header":
+			if required && r.Header.Get(name) == "" {
+				errs = append(errs, fmt.Sprintf("missing header %q", name))
+			}
+		}
+	}
+	for name := range r.URL.Query() {
+		if !declared[name] {
+			errs = append(errs, fmt.Sprintf("query parameter %q is not in the spec", name))
+		}
+	}
+
+	if encoded := r.Header.Get(ManifestHeader); encoded != "" {
+		var manifest interface{}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil {
+			err = json.Unmarshal(data, &manifest)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s is not base64 JSON: %v", ManifestHeader, err))
+		} else {
+			errs = append(errs, s.Validate(s.Schema("components", "schemas", "ArchiveManifest"), manifest, ManifestHeader)...)
+		}
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	requestBody, ok := op["requestBody"].(object)
+	if !ok {
+		if len(body) != 0 {
+			errs = append(errs, "sends a body but the spec declares none")
+		}
+		return body, errs
+	}
+	requestBody = s.Resolve(requestBody)
+	if len(body) == 0 {
+		if required, _ := requestBody["required"].(bool); required {
+			errs = append(errs, "body is required")
+		}
+		return body, errs
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	content, ok := requestBody["content"].(object)[mediaType].(object)
+	if !ok {
+		return body, append(errs, fmt.Sprintf("content type %q is not in the spec", mediaType))
+	}
+	if mediaType == "application/json" {
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return body, append(errs, fmt.Sprintf("body is not JSON: %v", err))
+		}
+		errs = append(errs, s.Validate(content["schema"].(object), value, "body")...)
+	}
+	return body, errs
+}
+
+// successStatus is the status an operation answers with when it works, and
+// whether that response has a body
+func (s *spec) successStatus(op object) (int, bool) {
+	var statuses []string
+	for status := range op["responses"].(object) {
+		if strings.HasPrefix(status, "2") {
+			statuses = append(statuses, status)
+		}
+	}
+	sort.Strings(statuses)
+
+	status, _ := strconv.Atoi(statuses[0])
+	_, hasBody := s.Resolve(op["responses"].(object)[statuses[0]].(object))["content"]
+	return status, hasBody
+}
+
+// TestClientRequestsMatchSpec runs every client call against a server that
+// checks each request against the spec
+func TestClientRequestsMatchSpec(t *testing.T) {
+	s := loadSpec(t)
+
+	var mu sync.Mutex
+	covered := make(map[string]bool)
+	check := func(r *http.Request) (object, string, []byte) {
+		op, template := s.Operation(r.Method, r.URL.Path)
+		if op == nil {
+			t.Errorf("%s %s is not in the spec", r.Method, r.URL.Path)
+			return nil, "", nil
+		}
+		operation := r.Method + " " + template
+		mu.Lock()
+		covered[operation] = true
+		mu.Unlock()
+
+		body, errs := s.checkRequest(op, r)
+		for _, err := range errs {
+			t.Errorf("%s: %s", operation, err)
+		}
+		return op, operation, body
+	}
+
+	upgrader := websocket.Upgrader{}
+	server := testenv.NewVersionedAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, operation, body := check(r)
+		switch {
+		case op == nil:
+			http.NotFound(w, r)
+		case operation == "GET /ws":
+			if r.URL.Query().Get("ticket") != "wst_1" {
+				t.Errorf("GET /ws: handshake without the ticket: %s", r.URL.RawQuery)
+			}
+			if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+				conn.Close()
+			}
+		case operation == "GET /api/deployments/{deploymentId}/events":
+			if r.Header.Get("Last-Event-ID") != "1" {
+				t.Errorf("%s: resumed with Last-Event-ID %q, want 1", operation, r.Header.Get("Last-Event-ID"))
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			data, _ := json.Marshal(DeploymentUpdate{Seq: 2, DeploymentID: "dep-1", Status: "complete"})
+			fmt.Fprintf(w, "id: 2\nevent: update\ndata: %s\n\n", data)
+		case operation == "POST /api/ws/tickets":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(WebSocketTicket{Ticket: "wst_1", DeploymentID: "dep-1", ExpiresAt: time.Now().Add(time.Minute)})
+		case operation == "GET /api/version":
+			json.NewEncoder(w).Encode(ServerInfo{
+				Version:    "test",
+				APIVersion: APIVersion,
+				Capabilities: []string{
+					CapabilityDeltaUploads, CapabilityArchiveUploads, CapabilityUploadSessions,
+					CapabilityWebSocketTickets, CapabilitySSEUpdates, CapabilityLongPollUpdates,
+				},
+			})
+		case operation == "POST /api/blobs/missing":
+			// Missing everything, so the blobs get uploaded
+			var req struct {
+				Hashes []string `json:"hashes"`
+			}
+			json.Unmarshal(body, &req)
+			json.NewEncoder(w).Encode(missingBlobsResponse{Missing: req.Hashes})
+		default:
+			status, hasBody := s.successStatus(op)
+			w.WriteHeader(status)
+			if hasBody {
+				io.WriteString(w, "{}")
+			}
+		}
+	}))
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("fastapi\n"), 0644)
+	project, err := files.ScanProject(dir)
+	if err != nil {
+		t.Fatalf("scan project: %v", err)
+	}
+
+	ctx := context.Background()
+	c := NewClient()
+	c.SetAuthToken("test-token")
+	c.SetRetryPolicy(RetryPolicy{})
+
+	calls := []struct {
+		name string
+		call func() error
+	}{
+		{"GenerateCode", func() error { _, err := c.GenerateCode(ctx, "a todo API"); return err }},
+		{"DeployProject (delta)", func() error { _, _, err := c.DeployProject(ctx, project, "proj-1"); return err }},
+		{"CommitProject (archive)", func() error {
+			c.deltaUnsupported = true
+			_, _, err := c.CommitProject(ctx, project, "proj-1", "message")
+			return err
+		}},
+		{"DeployProject (JSON)", func() error {
+			c.archiveUnsupported = true
+			_, _, err := c.DeployProject(ctx, project, "proj-1")
+			return err
+		}},
+		{"CommitProject (JSON)", func() error {
+			_, _, err := c.CommitProject(ctx, project, "proj-1", "message")
+			return err
+		}},
+		{"GetStatus", func() error { _, err := c.GetStatus(ctx, "dep-1"); return err }},
+		{"VerifyAuth", func() error { _, err := c.VerifyAuth(ctx); return err }},
+		{"RequestDeviceCode", func() error { _, err := c.RequestDeviceCode(ctx); return err }},
+		{"PollDeviceToken", func() error { _, err := c.PollDeviceToken(ctx, "device-code"); return err }},
+		{"ExchangeAuthCode", func() error {
+			_, err := c.ExchangeAuthCode(ctx, "code", "verifier", "http://127.0.0.1:8765/callback")
+			return err
+		}},
+		{"RefreshAccessToken", func() error { _, err := c.RefreshAccessToken(ctx, "refresh-token"); return err }},
+		{"RevokeToken", func() error { return c.RevokeToken(ctx, "refresh-token", "refresh_token") }},
+		{"RevokeAllSessions", func() error { _, err := c.RevokeAllSessions(ctx); return err }},
+		{"CreatePersonalAccessToken", func() error {
+			_, err := c.CreatePersonalAccessToken(ctx, "ci", []string{"deploy"}, time.Hour)
+			return err
+		}},
+		{"ListPersonalAccessTokens", func() error { _, err := c.ListPersonalAccessTokens(ctx); return err }},
+		{"RevokePersonalAccessToken", func() error { return c.RevokePersonalAccessToken(ctx, "tok_1") }},
+		{"CreateUploadSession", func() error { _, err := c.CreateUploadSession(ctx, 10, "abc", defaultChunkSize); return err }},
+		{"GetUploadSession", func() error { _, err := c.GetUploadSession(ctx, "up_1"); return err }},
+		{"UploadChunk", func() error { return c.UploadChunk(ctx, "up_1", 0, []byte("chunk")) }},
+		{"FinalizeUpload", func() error { return c.FinalizeUpload(ctx, "up_1") }},
+		{"WebSocketClient.Connect", func() error {
+			ws := NewWebSocketClient(server.URL)
+			ws.Authenticate(c)
+			ws.UseServerURL("ws" + strings.TrimPrefix(server.URL, "http") + "/ws?deploymentId=dep-1")
+			if err := ws.Connect(ctx, "dep-1"); err != nil {
+				return err
+			}
+			return ws.Close()
+		}},
+		{"DeploymentWatcher (SSE)", func() error {
+			watcher, err := NewDeploymentWatcher(c, TransportSSE, "")
+			if err != nil {
+				return err
+			}
+			return watcher.Watch(ctx, "dep-1", 1, func(*DeploymentUpdate) error { return nil })
+		}},
+		{"PollDeploymentUpdates", func() error { _, err := c.PollDeploymentUpdates(ctx, "dep-1", 1, time.Second); return err }},
+		{"AuthorizeURL", func() error {
+			req, err := http.NewRequest("GET", c.AuthorizeURL("http://127.0.0.1:8765/callback", "state", "challenge", "S256"), nil)
+			if err != nil {
+				return err
+			}
+			check(req)
+			return nil
+		}},
+	}
+	for _, call := range calls {
+		if err := call.call(); err != nil {
+			t.Errorf("%s: %v", call.name, err)
+		}
+	}
+
+	for _, op := range s.Operations() {
+		if !covered[op] {
+			t.Errorf("%s is in the spec but the client never calls it", op)
+		}
+	}
+}
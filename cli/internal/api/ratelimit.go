@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the request quota the API reported on its latest response
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	// Reset is when the quota refills; zero if the server didn't say
+	Reset time.Time
+}
+
+// RateLimit returns the quota reported with the latest response, or nil if
+// the server hasn't sent rate-limit headers
+func (c *Client) RateLimit() *RateLimit {
+	return c.rateLimit
+}
+
+// parseRateLimit reads the RateLimit-* headers, or their older X-RateLimit-*
+// form
+func parseRateLimit(header http.Header) *RateLimit {
+	limit, limitErr := strconv.Atoi(rateLimitHeader(header, "Limit"))
+	remaining, remainingErr := strconv.Atoi(rateLimitHeader(header, "Remaining"))
+	if limitErr != nil || remainingErr != nil {
+		return nil
+	}
+
+	rl := &RateLimit{Limit: limit, Remaining: remaining}
+	if wait, ok := parseReset(rateLimitHeader(header, "Reset")); ok {
+		rl.Reset = time.Now().Add(wait)
+	}
+	return rl
+}
+
+func rateLimitHeader(header http.Header, name string) string {
+	if value := header.Get("RateLimit-" + name); value != "" {
+		return value
+	}
+	return header.Get("X-RateLimit-" + name)
+}
+
+// parseReset reads a rate-limit reset, which servers send either as seconds
+// until the reset or as a Unix timestamp
+func parseReset(value string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	// No sane window is longer than a year; anything larger is a timestamp
+	if n > 365*24*60*60 {
+		return time.Until(time.Unix(n, 0)), true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// retryAfter returns how long the server asked us to wait before retrying:
+// Retry-After (seconds or an HTTP date), or for 429 responses without it,
+// the time until the quota resets
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			wait := time.Until(date)
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if wait, ok := parseReset(rateLimitHeader(resp.Header, "Reset")); ok {
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+	}
+	return 0, false
+}
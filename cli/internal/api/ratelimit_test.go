@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		wait   time.Duration
+		ok     bool
+	}{
+		{"seconds", 503, http.Header{"Retry-After": {"7"}}, 7 * time.Second, true},
+		{"past date", 503, http.Header{"Retry-After": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, 0, true},
+		{"quota reset", 429, http.Header{"Ratelimit-Reset": {"30"}}, 30 * time.Second, true},
+		{"quota reset without 429", 503, http.Header{"Ratelimit-Reset": {"30"}}, 0, false},
+		{"none", 429, http.Header{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := retryAfter(&http.Response{StatusCode: tt.status, Header: tt.header})
+			if wait != tt.wait || ok != tt.ok {
+				t.Errorf("retryAfter = %s, %v; want %s, %v", wait, ok, tt.wait, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	rl := parseRateLimit(http.Header{"X-Ratelimit-Limit": {"100"}, "X-Ratelimit-Remaining": {"42"}, "X-Ratelimit-Reset": {"60"}})
+	if rl == nil || rl.Limit != 100 || rl.Remaining != 42 || time.Until(rl.Reset) < 59*time.Second {
+		t.Errorf("parseRateLimit = %+v", rl)
+	}
+	if rl := parseRateLimit(http.Header{}); rl != nil {
+		t.Errorf("parseRateLimit without headers = %+v, want nil", rl)
+	}
+}
+
+// rateLimitedServer throttles the first request with Retry-After: wait
+func rateLimitedServer(t *testing.T, wait int) (*atomic.Int32, *Client) {
+	t.Helper()
+	var requests atomic.Int32
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RateLimit-Limit", "10")
+		if requests.Add(1) == 1 {
+			w.Header().Set("RateLimit-Remaining", "0")
+			w.Header().Set("Retry-After", strconv.Itoa(wait))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"code": "rate_limited", "message": "Slow down"}}`))
+			return
+		}
+		w.Header().Set("RateLimit-Remaining", "9")
+		w.Write([]byte(`{"generatedText": "ok"}`))
+	}))
+	c.SetRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxWait: 5 * time.Second})
+	return &requests, c
+}
+
+// TestRateLimitedRequestIsRetried waits out Retry-After, even for a POST
+// without an idempotency key, as the throttled request was never processed
+func TestRateLimitedRequestIsRetried(t *testing.T) {
+	requests, c := rateLimitedServer(t, 1)
+
+	start := time.Now()
+	if _, err := c.GenerateCode(context.Background(), "a todo API"); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, before Retry-After", elapsed)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("sent %d requests, want 2", requests.Load())
+	}
+	if rl := c.RateLimit(); rl == nil || rl.Remaining != 9 {
+		t.Errorf("RateLimit = %+v, want the latest response's quota", rl)
+	}
+}
+
+// TestRateLimitedWaitTooLong fails right away when the server asks for a
+// longer wait than MaxWait, saying when to try again
+func TestRateLimitedWaitTooLong(t *testing.T) {
+	requests, c := rateLimitedServer(t, 3600)
+
+	_, err := c.GenerateCode(context.Background(), "a todo API")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !apiErr.IsRateLimited() || apiErr.RetryAfter != time.Hour {
+		t.Fatalf("generate: err = %v, want rate limited for an hour", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("sent %d requests, want 1", requests.Load())
+	}
+}
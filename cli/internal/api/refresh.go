@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/backend-im/cli/internal/auth"
+)
+
+// refreshSkew is how long before expiry a token is proactively refreshed
+const refreshSkew = 60 * time.Second
+
+// RefreshAccessToken exchanges a refresh token for a new token
+func (c *Client) RefreshAccessToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	return c.requestToken(ctx, map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+		"client_id":     ClientID,
+	})
+}
+
+// refresh renews the client's token and saves it. The token file is locked
+// for the duration so concurrent CLI processes don't both spend the same
+// refresh token; a process that waited for the lock picks up the token the
+// other one saved instead of refreshing again.
+func (c *Client) refresh(ctx context.Context) error {
+	stale := c.token
+
+	token, err := auth.UpdateToken(func(current *auth.Token) (*auth.Token, error) {
+		refreshToken := stale.RefreshToken
+		if current != nil && current.AccessToken != stale.AccessToken {
+			if !current.Expired() {
+				return current, nil
+			}
+			// Refresh tokens rotate, so only the latest saved one is valid
+			refreshToken = current.RefreshToken
+		}
+
+		resp, err := c.RefreshAccessToken(ctx, refreshToken)
+		if err != nil {
+			return nil, err
+		}
+
+		next := resp.Token()
+		if next.RefreshToken == "" {
+			// Server did not rotate the refresh token - keep using the old one
+			next.RefreshToken = refreshToken
+		}
+		return next, nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("session expired and could not be refreshed: %w\nRun 'backend-im login' again", err)
+	}
+
+	c.SetToken(token)
+	return nil
+}
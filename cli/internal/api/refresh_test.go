@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+// tokenServer rotates refresh tokens the way the API does: each refresh
+// token works once, and only the latest access token is accepted
+type tokenServer struct {
+	mu        sync.Mutex
+	access    string
+	refresh   string
+	refreshes int
+	reused    int
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/api/auth/token":
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["grant_type"] != "refresh_token" || req["refresh_token"] != s.refresh {
+			s.reused++
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		s.refreshes++
+		s.access = fmt.Sprintf("access-%d", s.refreshes)
+		s.refresh = fmt.Sprintf("refresh-%d", s.refreshes)
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: s.access, TokenType: "Bearer", ExpiresIn: 3600, RefreshToken: s.refresh})
+	case "/api/auth/verify":
+		if r.Header.Get("Authorization") != "Bearer "+s.access {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": "unauthorized", "message": "Invalid token"}}`))
+			return
+		}
+		w.Write([]byte(`{"valid": true}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// startTokenServer serves the API with saved as the latest token, and saves
+// it as a login would
+func startTokenServer(t *testing.T, saved *auth.Token) *tokenServer {
+	t.Helper()
+	server := &tokenServer{access: saved.AccessToken, refresh: saved.RefreshToken}
+	testenv.NewAPI(t, server)
+
+	if err := auth.SaveToken(saved); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	return server
+}
+
+// TestConcurrentRefresh runs several CLI processes whose saved token is about
+// to expire: one refreshes it, the others pick up what it saved instead of
+// spending the same refresh token again
+func TestConcurrentRefresh(t *testing.T) {
+	server := startTokenServer(t, auth.NewToken("access-0", "Bearer", "refresh-0", 1))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := auth.LoadToken()
+			if err != nil {
+				errs <- err
+				return
+			}
+			c := NewClient()
+			c.SetToken(token)
+			_, err = c.VerifyAuth(context.Background())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("verify: %v", err)
+		}
+	}
+
+	if server.refreshes != 1 || server.reused != 0 {
+		t.Errorf("%d refreshes and %d reused refresh tokens, want 1 and 0", server.refreshes, server.reused)
+	}
+	saved, err := auth.LoadToken()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if saved.AccessToken != "access-1" || saved.RefreshToken != "refresh-1" {
+		t.Errorf("saved %s/%s, want the refreshed access-1/refresh-1", saved.AccessToken, saved.RefreshToken)
+	}
+}
+
+// TestRefreshOnUnauthorized refreshes a token the server rejects before it
+// was due to expire, and retries the request once
+func TestRefreshOnUnauthorized(t *testing.T) {
+	saved := auth.NewToken("access-0", "Bearer", "refresh-0", 3600)
+	server := startTokenServer(t, saved)
+	server.access = "revoked"
+
+	c := NewClient()
+	c.SetToken(saved)
+	if _, err := c.VerifyAuth(context.Background()); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if server.refreshes != 1 || c.token.AccessToken != "access-1" {
+		t.Errorf("%d refreshes, client holds %s", server.refreshes, c.token.AccessToken)
+	}
+}
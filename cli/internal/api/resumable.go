@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/backend-im/cli/internal/files"
+)
+
+const (
+	// resumableThreshold is the blob upload size from which a resumable
+	// session is used instead of a single request
+	resumableThreshold = 4 << 20
+
+	// defaultChunkSize is the chunk size proposed to the server, which may
+	// pick another
+	defaultChunkSize = 1 << 20
+
+	// maxChunkSize is the largest chunk size accepted from the server: each
+	// chunk is held in memory and sent as one request
+	maxChunkSize = 16 << 20
+)
+
+// UploadSession is a resumable upload: the payload is sent in numbered,
+// checksummed chunks that can arrive in any order and across CLI runs, then
+// finalized once they're all there
+type UploadSession struct {
+	UploadID  string          `json:"uploadId"`
+	Size      int64           `json:"size"`
+	SHA256    string          `json:"sha256"`
+	ChunkSize int64           `json:"chunkSize"`
+	Received  []ReceivedChunk `json:"received"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+}
+
+// ReceivedChunk is a chunk the server has stored
+type ReceivedChunk struct {
+	Index  int   `json:"index"`
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// CreateUploadSession starts a resumable upload of size bytes whose SHA-256
+// is sha256Hex. The session's ChunkSize is the one the server chose.
+func (c *Client) CreateUploadSession(ctx context.Context, size int64, sha256Hex string, chunkSize int64) (*UploadSession, error) {
+	reqBody := map[string]interface{}{
+		"size":      size,
+		"sha256":    sha256Hex,
+		"chunkSize": chunkSize,
+	}
+
+	var response UploadSession
+	err := c.postIdempotent(ctx, "/api/uploads", reqBody, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// GetUploadSession returns a session, including which chunks were received
+func (c *Client) GetUploadSession(ctx context.Context, uploadID string) (*UploadSession, error) {
+	var response UploadSession
+	err := c.get(ctx, "/api/uploads/"+url.PathEscape(uploadID), &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// UploadChunk stores chunk number index of a session. The server checks it
+// against the SHA-256 sent alongside.
+func (c *Client) UploadChunk(ctx context.Context, uploadID string, index int, data []byte) error {
+	sum := sha256.Sum256(data)
+	header := make(http.Header)
+	header.Set("X-Chunk-SHA256", hex.EncodeToString(sum[:]))
+
+	return c.do(ctx, &apiRequest{
+		method:      "PUT",
+		path:        fmt.Sprintf("/api/uploads/%s/chunks/%d", url.PathEscape(uploadID), index),
+		body:        data,
+		contentType: "application/octet-stream",
+		header:      header,
+	}, nil)
+}
+
+// FinalizeUpload completes a session once every chunk was received. The
+// server verifies the assembled payload against the session's SHA-256.
+func (c *Client) FinalizeUpload(ctx context.Context, uploadID string) error {
+	return c.postIdempotent(ctx, "/api/uploads/"+url.PathEscape(uploadID)+"/finalize", struct{}{}, nil)
+}
+
+// uploadState is what's kept on disk to resume an interrupted upload: the
+// spooled payload next to it and the server session it belongs to
+type uploadState struct {
+	UploadID  string `json:"uploadId,omitempty"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	ChunkSize int64  `json:"chunkSize,omitempty"`
+}
+
+// uploadBlobsResumable uploads the blobs with the given hashes through a
+// resumable session. The blob archive is spooled under the project's state
+// directory first, so a failed or interrupted upload is picked up where it
+// left off the next time the same blobs are uploaded.
+func (c *Client) uploadBlobsResumable(ctx context.Context, project *files.Project, hashes map[string]bool) error {
+	dir := filepath.Join(project.StateDir(), "uploads")
+	key := c.uploadKey(hashes)
+	statePath := filepath.Join(dir, key+".json")
+	archivePath := filepath.Join(dir, key+".tar.gz")
+
+	state := loadUploadState(statePath, archivePath)
+	if state == nil {
+		var err error
+		state, err = spoolBlobArchive(project, hashes, archivePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	var received map[int]bool
+	if state.UploadID != "" {
+		session, err := c.GetUploadSession(ctx, state.UploadID)
+		var apiErr *Error
+		switch {
+		case errors.As(err, &apiErr) && apiErr.IsNotFound():
+			// Expired or unknown - start a new session with the same payload
+			state.UploadID = ""
+		case err != nil:
+			return err
+		default:
+			if err := checkChunkSize(session.ChunkSize); err != nil {
+				return err
+			}
+			received = make(map[int]bool, len(session.Received))
+			for _, chunk := range session.Received {
+				received[chunk.Index] = true
+			}
+			state.ChunkSize = session.ChunkSize
+			fmt.Printf("♻️  Resuming upload %s: %d of %d chunks already received\n",
+				state.UploadID, len(received), chunkCount(state.Size, state.ChunkSize))
+		}
+	}
+
+	if state.UploadID == "" {
+		session, err := c.CreateUploadSession(ctx, state.Size, state.SHA256, defaultChunkSize)
+		if err != nil {
+			var apiErr *Error
+			if errors.As(err, &apiErr) && (apiErr.IsNotFound() || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+				os.Remove(statePath)
+				os.Remove(archivePath)
+				return errSessionsUnsupported
+			}
+			return err
+		}
+		if err := checkChunkSize(session.ChunkSize); err != nil {
+			return err
+		}
+		state.UploadID = session.UploadID
+		state.ChunkSize = session.ChunkSize
+		fmt.Printf("📤 Uploading in %d chunks (upload %s)\n", chunkCount(state.Size, state.ChunkSize), state.UploadID)
+	}
+
+	if err := saveUploadState(statePath, state); err != nil {
+		return err
+	}
+
+	if err := c.uploadChunks(ctx, archivePath, state, received); err != nil {
+		fmt.Fprintf(os.Stderr, "💾 Upload progress saved in %s - run the command again to resume\n", dir)
+		return err
+	}
+
+	if err := c.FinalizeUpload(ctx, state.UploadID); err != nil {
+		fmt.Fprintf(os.Stderr, "💾 Upload progress saved in %s - run the command again to resume\n", dir)
+		return err
+	}
+
+	os.Remove(statePath)
+	os.Remove(archivePath)
+	return nil
+}
+
+// pruneUploads deletes saved uploads other than the one for hashes. They
+// belong to blob sets the project no longer needs, e.g. because an upload was
+// finalized but the CLI never heard back.
+func (c *Client) pruneUploads(project *files.Project, hashes map[string]bool) {
+	dir := filepath.Join(project.StateDir(), "uploads")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	keep := c.uploadKey(hashes)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), keep+".") {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// uploadChunks sends every chunk of the spooled payload the server hasn't received
+func (c *Client) uploadChunks(ctx context.Context, archivePath string, state *uploadState, received map[int]bool) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, state.ChunkSize)
+	for index := 0; index < chunkCount(state.Size, state.ChunkSize); index++ {
+		if received[index] {
+			continue
+		}
+
+		n, err := file.ReadAt(buf, int64(index)*state.ChunkSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read upload: %w", err)
+		}
+		if err := c.UploadChunk(ctx, state.UploadID, index, buf[:n]); err != nil {
+			return fmt.Errorf("failed to upload chunk %d: %w", index, err)
+		}
+	}
+
+	return nil
+}
+
+// errSessionsUnsupported means the server has no resumable upload endpoint
+var errSessionsUnsupported = errors.New("server does not support resumable uploads")
+
+// uploadKey names the state of an upload after what it contains and where
+// it goes, so re-running the same upload finds it
+func (c *Client) uploadKey(hashes map[string]bool) string {
+	sorted := make([]string, 0, len(hashes))
+	for hash := range hashes {
+		sorted = append(sorted, hash)
+	}
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(c.baseURL + "\n" + strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// spoolBlobArchive writes the blob archive to path, returning its size and hash
+func spoolBlobArchive(project *files.Project, hashes map[string]bool, path string) (*uploadState, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(file, hasher)}
+	if err := project.WriteBlobArchive(counter, hashes); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write upload file: %w", err)
+	}
+
+	return &uploadState{Size: counter.n, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// loadUploadState returns the saved state of an interrupted upload, or nil if
+// there is none or its spooled payload is missing or damaged
+func loadUploadState(statePath, archivePath string) *uploadState {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil || size != state.Size || hex.EncodeToString(hasher.Sum(nil)) != state.SHA256 {
+		return nil
+	}
+
+	return &state
+}
+
+func saveUploadState(path string, state *uploadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload state: %w", err)
+	}
+	if err := auth.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to save upload state: %w", err)
+	}
+	return nil
+}
+
+// checkChunkSize rejects a chunk size from the server that chunks can't be
+// cut to, or that is too large to hold in memory
+func checkChunkSize(chunkSize int64) error {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return fmt.Errorf("server chose an invalid upload chunk size of %d bytes (must be 1 to %d)", chunkSize, maxChunkSize)
+	}
+	return nil
+}
+
+func chunkCount(size, chunkSize int64) int {
+	if chunkSize <= 0 {
+		return 0
+	}
+	return int((size + chunkSize - 1) / chunkSize)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
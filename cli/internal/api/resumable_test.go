@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/files"
+)
+
+// sessionServer keeps one upload session in memory. failChunk, when not
+// negative, is rejected the first time it is sent.
+type sessionServer struct {
+	mu        sync.Mutex
+	chunkSize int64
+	failChunk int
+	size      int64
+	received  map[int]int64
+	sent      []int
+	finalized bool
+}
+
+func (s *sessionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/uploads":
+		var req struct{ Size int64 }
+		json.NewDecoder(r.Body).Decode(&req)
+		s.size = req.Size
+		s.received = make(map[int]int64)
+		json.NewEncoder(w).Encode(UploadSession{UploadID: "up-1", Size: req.Size, ChunkSize: s.chunkSize, Received: []ReceivedChunk{}})
+	case r.Method == "GET" && r.URL.Path == "/api/uploads/up-1":
+		session := UploadSession{UploadID: "up-1", Size: s.size, ChunkSize: s.chunkSize, Received: []ReceivedChunk{}}
+		for index, size := range s.received {
+			session.Received = append(session.Received, ReceivedChunk{Index: index, Offset: int64(index) * s.chunkSize, Size: size})
+		}
+		json.NewEncoder(w).Encode(session)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/api/uploads/up-1/chunks/"):
+		index, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/uploads/up-1/chunks/"))
+		data, _ := io.ReadAll(r.Body)
+		s.sent = append(s.sent, index)
+		if index == s.failChunk {
+			s.failChunk = -1
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "bad_request", "message": "Connection reset"}}`))
+			return
+		}
+		s.received[index] = int64(len(data))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && r.URL.Path == "/api/uploads/up-1/finalize":
+		s.finalized = true
+		w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": "not_found", "message": "Not found"}}`))
+	}
+}
+
+// largeProject is a project whose blob archive takes a resumable session
+func largeProject(t *testing.T) (*files.Project, map[string]bool) {
+	t.Helper()
+	dir := t.TempDir()
+	data := make([]byte, resumableThreshold+defaultChunkSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(filepath.Join(dir, "model.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := files.ScanProject(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.HashFiles(); err != nil {
+		t.Fatal(err)
+	}
+	return project, map[string]bool{project.Files[0].Hash: true}
+}
+
+func TestResumableUploadResumes(t *testing.T) {
+	server := &sessionServer{chunkSize: defaultChunkSize, failChunk: 2}
+	c := newTestClient(t, server)
+	c.SetRetryPolicy(RetryPolicy{})
+	project, hashes := largeProject(t)
+
+	if err := c.uploadBlobsResumable(context.Background(), project, hashes); err == nil {
+		t.Fatalf("first upload succeeded despite the failed chunk")
+	}
+	if got := len(server.sent); got != 3 {
+		t.Fatalf("first run sent %d chunks, want it to stop at chunk 2", got)
+	}
+
+	// The next run picks up the saved session and sends only what's missing
+	server.sent = nil
+	if err := c.uploadBlobsResumable(context.Background(), project, hashes); err != nil {
+		t.Fatalf("resumed upload: %v", err)
+	}
+	for _, index := range server.sent {
+		if index < 2 {
+			t.Errorf("resent chunk %d the server already had", index)
+		}
+	}
+	if want := chunkCount(server.size, server.chunkSize); len(server.received) != want || !server.finalized {
+		t.Errorf("server holds %d of %d chunks, finalized %v", len(server.received), want, server.finalized)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(project.StateDir(), "uploads")); len(entries) != 0 {
+		t.Errorf("left %d files of the finished upload behind", len(entries))
+	}
+}
+
+func TestResumableUploadRejectsChunkSize(t *testing.T) {
+	for _, chunkSize := range []int64{0, -1, maxChunkSize + 1} {
+		t.Run(strconv.FormatInt(chunkSize, 10), func(t *testing.T) {
+			server := &sessionServer{chunkSize: chunkSize, failChunk: -1}
+			c := newTestClient(t, server)
+			project, hashes := largeProject(t)
+
+			err := c.uploadBlobsResumable(context.Background(), project, hashes)
+			if err == nil || !strings.Contains(err.Error(), "chunk size") {
+				t.Errorf("upload: err = %v, want an invalid chunk size error", err)
+			}
+			if len(server.sent) != 0 {
+				t.Errorf("sent %d chunks", len(server.sent))
+			}
+		})
+	}
+}
+
+func TestUploadSessionPathsEscapeID(t *testing.T) {
+	var paths []string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		w.Write([]byte(`{}`))
+	}))
+	ctx := context.Background()
+
+	c.GetUploadSession(ctx, "up/../1")
+	c.UploadChunk(ctx, "up/../1", 0, []byte("x"))
+	c.FinalizeUpload(ctx, "up/../1")
+
+	want := []string{"/api/uploads/up%2F..%2F1", "/api/uploads/up%2F..%2F1/chunks/0", "/api/uploads/up%2F..%2F1/finalize"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("requested %q, want %q", paths, want)
+	}
+}
+
+// dripReader yields one byte per read, waiting delay before each
+type dripReader struct {
+	n     int
+	delay time.Duration
+}
+
+func (r *dripReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	r.n--
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestUploadStallTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { uploadStallTimeout = timeout }(uploadStallTimeout)
+	uploadStallTimeout = 100 * time.Millisecond
+
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/blobs" {
+			http.NotFound(w, r)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	c.SetRetryPolicy(RetryPolicy{})
+	upload := func(body io.ReadCloser) error {
+		return c.do(context.Background(), &apiRequest{
+			method:  "PUT",
+			path:    "/api/blobs",
+			newBody: func() io.ReadCloser { return body },
+		}, nil)
+	}
+
+	// An upload that keeps moving may take longer than the stall timeout
+	if err := upload(io.NopCloser(&dripReader{n: 10, delay: 20 * time.Millisecond})); err != nil {
+		t.Errorf("slow upload: %v", err)
+	}
+
+	// One that stops moving is abandoned
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	if err := upload(pr); !errors.Is(err, errUploadStalled) {
+		t.Errorf("stalled upload: err = %v, want errUploadStalled", err)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Only requests that
+// are safe to repeat are retried: GET/DELETE, and POSTs carrying an
+// Idempotency-Key. Rate-limited (429) requests were never processed, so
+// they are retried whatever the method.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt (0 disables)
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	// MaxWait caps how long to wait when the server asks for a delay with
+	// Retry-After; longer waits fail right away instead
+	MaxWait time.Duration
+}
+
+// DefaultRetryPolicy retries up to 3 times with roughly 0.5s, 1s, 2s delays,
+// or as long as the server asks for, up to a minute
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   10 * time.Second,
+	MaxWait:    60 * time.Second,
+}
+
+// MaxRetriesEnv overrides DefaultRetryPolicy.MaxRetries
+const MaxRetriesEnv = "BACKEND_IM_MAX_RETRIES"
+
+// MaxRetryWaitEnv overrides DefaultRetryPolicy.MaxWait, in seconds
+const MaxRetryWaitEnv = "BACKEND_IM_MAX_RETRY_WAIT"
+
+func retryPolicyFromEnv() RetryPolicy {
+	policy := DefaultRetryPolicy
+	if n, err := strconv.Atoi(os.Getenv(MaxRetriesEnv)); err == nil && n >= 0 {
+		policy.MaxRetries = n
+	}
+	if n, err := strconv.Atoi(os.Getenv(MaxRetryWaitEnv)); err == nil && n >= 0 {
+		policy.MaxWait = time.Duration(n) * time.Second
+	}
+	return policy
+}
+
+// SetRetryPolicy replaces the client's retry policy
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// backoff returns the delay before retry number attempt (starting at 1):
+// exponential growth capped at MaxDelay, with "equal jitter" so concurrent
+// clients don't retry in lockstep
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	half := delay / 2
+	return time.Duration(half + mathrand.Float64()*half)
+}
+
+func (r *apiRequest) retryable() bool {
+	switch r.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.safe || r.idempotencyKey != ""
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// sendWithRetry sends req, retrying transport errors, 429 and 502/503/504
+// responses according to the client's retry policy. The server's Retry-After
+// is honoured over the backoff. Waiting between attempts stops as soon as
+// ctx is cancelled.
+func (c *Client) sendWithRetry(ctx context.Context, req *apiRequest) (*http.Response, error) {
+	maxRetries := 0
+	if req.retryable() {
+		maxRetries = c.retry.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+
+		var reason string
+		retries := maxRetries
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != nil:
+			reason = err.Error()
+		case resp.StatusCode == http.StatusTooManyRequests:
+			reason = "rate limited"
+			retries = c.retry.MaxRetries
+		case isRetryableStatus(resp.StatusCode):
+			reason = fmt.Sprintf("server returned %d", resp.StatusCode)
+		default:
+			return resp, nil
+		}
+
+		if attempt >= retries {
+			return resp, err
+		}
+
+		delay := c.retry.backoff(attempt + 1)
+		if resp != nil {
+			if wait, ok := retryAfter(resp); ok {
+				if wait > c.retry.MaxWait {
+					// Not worth blocking on; the error says when to retry
+					return resp, nil
+				}
+				delay = wait
+			}
+			resp.Body.Close()
+		}
+
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			fmt.Fprintf(os.Stderr, "⏳ %s %s rate limited, retrying in %s (%d/%d)\n",
+				req.method, req.path, delay.Round(100*time.Millisecond), attempt+1, retries)
+		} else {
+			fmt.Fprintf(os.Stderr, "⚠️  %s %s failed (%s), retrying in %s (%d/%d)\n",
+				req.method, req.path, reason, delay.Round(100*time.Millisecond), attempt+1, retries)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func newIdempotencyKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate idempotency key: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
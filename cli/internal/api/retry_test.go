@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	for attempt, ceiling := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 300 * time.Millisecond,
+		6: 300 * time.Millisecond,
+	} {
+		for i := 0; i < 20; i++ {
+			if delay := policy.backoff(attempt); delay < ceiling/2 || delay > ceiling {
+				t.Errorf("backoff(%d) = %s, want %s to %s", attempt, delay, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+// flakyServer answers 503 to the first failures requests, then 200
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	keys     []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, r.Header.Get("Idempotency-Key"))
+	if len(s.keys) <= s.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error": {"code": "unavailable", "message": "Try again"}}`))
+		return
+	}
+	w.Write([]byte(`{"deploymentId": "dep-1", "status": "queued", "generatedText": "ok"}`))
+}
+
+// newFlakyClient returns a client of a flakyServer that retries right away
+func newFlakyClient(t *testing.T, failures int) (*flakyServer, *Client) {
+	t.Helper()
+	server := &flakyServer{failures: failures}
+	c := newTestClient(t, server)
+	c.SetRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxWait: time.Second})
+	// Projects go up as one archive request
+	c.deltaUnsupported = true
+	return server, c
+}
+
+func TestRetryReusesIdempotencyKey(t *testing.T) {
+	server, c := newFlakyClient(t, 2)
+	project := testProject(t, map[string]string{"main.py": ""})
+
+	if _, _, err := c.DeployProject(context.Background(), project, "proj-1"); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if len(server.keys) != 3 {
+		t.Fatalf("sent %d attempts, want 3", len(server.keys))
+	}
+	for _, key := range server.keys {
+		if key == "" || key != server.keys[0] {
+			t.Errorf("attempts sent Idempotency-Keys %q, want one key reused", server.keys)
+			break
+		}
+	}
+
+	// The next deployment is a different operation with a new key
+	if _, _, err := c.DeployProject(context.Background(), project, "proj-1"); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if last := server.keys[len(server.keys)-1]; last == server.keys[0] {
+		t.Errorf("a second deployment reused the first one's key %q", last)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	server, c := newFlakyClient(t, 10)
+
+	_, _, err := c.DeployProject(context.Background(), testProject(t, map[string]string{"main.py": ""}), "proj-1")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("deploy: err = %v, want the last 503", err)
+	}
+	if len(server.keys) != 4 {
+		t.Errorf("sent %d attempts, want 1 and 3 retries", len(server.keys))
+	}
+}
+
+func TestNoRetryWithoutIdempotencyKey(t *testing.T) {
+	server, c := newFlakyClient(t, 1)
+
+	if _, err := c.GenerateCode(context.Background(), "a todo API"); err == nil {
+		t.Fatalf("generate succeeded, want the 503")
+	}
+	if len(server.keys) != 1 || server.keys[0] != "" {
+		t.Errorf("sent %d attempts with keys %q, want 1 without a key", len(server.keys), server.keys)
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	server, c := newFlakyClient(t, 10)
+	c.SetRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Minute, MaxDelay: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetStatus(ctx, "dep-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("status: err = %v, want the context's", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second || len(server.keys) != 1 {
+		t.Errorf("waited %s after %d attempts", elapsed, len(server.keys))
+	}
+}
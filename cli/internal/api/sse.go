@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sseWatcher follows a deployment's Server-Sent Events stream. If the
+// stream drops it reconnects with backoff and resumes with Last-Event-ID.
+type sseWatcher struct {
+	api       *Client
+	reconnect RetryPolicy
+
+	// liveness is how long the stream may stay silent, keepalive comments
+	// included, before it is given up and resumed (0 disables)
+	liveness time.Duration
+
+	deploymentID string
+	lastSeq      int64
+	unsequenced  bool
+
+	// resp is the open stream; nil once the server said there is nothing
+	// left to send
+	resp *http.Response
+}
+
+// newSSEWatcher reconnects like the WebSocket client and takes the
+// WebSocket's ping interval plus pong timeout as the stream's liveness
+func newSSEWatcher(apiClient *Client) *sseWatcher {
+	w := &sseWatcher{api: apiClient, reconnect: DefaultReconnectPolicy}
+	if heartbeat := heartbeatPolicyFromEnv(); heartbeat.PingInterval > 0 {
+		w.liveness = heartbeat.PingInterval + heartbeat.PongTimeout
+	}
+	return w
+}
+
+func (w *sseWatcher) Transport() string {
+	return TransportSSE
+}
+
+func (w *sseWatcher) Watch(ctx context.Context, deploymentID string, since int64, callback func(*DeploymentUpdate) error) error {
+	w.deploymentID = deploymentID
+	w.lastSeq = since
+	if err := w.connect(ctx); err != nil {
+		return err
+	}
+	return followStream(ctx, w, w.reconnect, callback)
+}
+
+// connect opens the event stream, resuming after lastSeq
+func (w *sseWatcher) connect(ctx context.Context) error {
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if w.lastSeq > 0 {
+		header.Set("Last-Event-ID", strconv.FormatInt(w.lastSeq, 10))
+	}
+	resp, err := w.api.open(ctx, &apiRequest{
+		method: "GET",
+		path:   fmt.Sprintf("/api/deployments/%s/events", w.deploymentID),
+		header: header,
+		stream: true,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		// Resumed after the last update: nothing left to send
+		resp.Body.Close()
+		w.resp = nil
+		return nil
+	}
+	if !isEventStream(resp.Header) {
+		resp.Body.Close()
+		return fmt.Errorf("expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+	w.resp = resp
+	return nil
+}
+
+func (w *sseWatcher) resumePoint() (int64, bool) {
+	return w.lastSeq, w.unsequenced
+}
+
+// read reads the open stream's events. A stream that ends early or goes
+// silent is reported as a *connectionLostError.
+func (w *sseWatcher) read(ctx context.Context, callback func(*DeploymentUpdate) error) error {
+	resp := w.resp
+	if resp == nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	// The idle timer closes the body to unblock the pending read when not
+	// even keepalives arrive any more
+	var idle *time.Timer
+	var expired atomic.Bool
+	if w.liveness > 0 {
+		idle = time.AfterFunc(w.liveness, func() {
+			expired.Store(true)
+			resp.Body.Close()
+		})
+		defer idle.Stop()
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if expired.Load() {
+				return &connectionLostError{stream: "Event stream", cause: fmt.Sprintf("nothing received for %s - connection may be stale", w.liveness)}
+			}
+			if errors.Is(err, io.EOF) {
+				return &connectionLostError{stream: "Event stream", cause: "ended before the deployment finished"}
+			}
+			return &connectionLostError{stream: "Event stream", cause: err.Error()}
+		}
+		if idle != nil {
+			idle.Reset(w.liveness)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			event.parse(line)
+			continue
+		}
+
+		// A blank line dispatches the event
+		done, err := w.dispatch(event, callback)
+		event = sseEvent{}
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// dispatch delivers an update event, reporting whether it was the final one
+func (w *sseWatcher) dispatch(event sseEvent, callback func(*DeploymentUpdate) error) (bool, error) {
+	if event.data == "" {
+		return false, nil
+	}
+	if event.kind != "" && event.kind != "update" {
+		tracef("SSE ← %s event ignored", event.kind)
+		return false, nil
+	}
+	tracef("SSE ← update %s: %s", event.id, traceBody("", "application/json", []byte(event.data)))
+
+	var update DeploymentUpdate
+	if err := json.Unmarshal([]byte(event.data), &update); err != nil {
+		return false, fmt.Errorf("failed to decode event: %w", err)
+	}
+
+	// Skip updates replayed after a reconnect that were already delivered
+	if update.Seq == 0 {
+		w.unsequenced = true
+	} else if update.Seq <= w.lastSeq {
+		tracef("SSE skipping update %d, already delivered", update.Seq)
+		return false, nil
+	}
+
+	if err := callback(&update); err != nil {
+		return false, err
+	}
+	if update.Seq > w.lastSeq {
+		w.lastSeq = update.Seq
+	}
+	return update.final(), nil
+}
+
+// sseEvent is an event being read from the stream
+type sseEvent struct {
+	id   string
+	kind string
+	data string
+}
+
+// parse applies one line of the event stream to the event
+func (e *sseEvent) parse(line string) {
+	if strings.HasPrefix(line, ":") {
+		tracef("SSE ← comment%s", line[1:])
+		return
+	}
+
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch field {
+	case "id":
+		e.id = value
+	case "event":
+		e.kind = value
+	case "data":
+		if e.data != "" {
+			e.data += "\n"
+		}
+		e.data += value
+	}
+}
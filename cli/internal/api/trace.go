@@ -0,0 +1,267 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DebugEnv enables the debug trace like the --debug flag: "1" or "stderr"
+// traces to stderr, any other value is a file to append the trace to
+const DebugEnv = "BACKEND_IM_DEBUG"
+
+// traceBodyLimit is how much of each body the trace shows
+const traceBodyLimit = 2048
+
+const redacted = "[REDACTED]"
+
+// redactedHeaders are never written to the trace
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// redactedFields are top-level JSON fields and query parameters holding
+// secrets on any endpoint
+var redactedFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"token":         true,
+	"device_code":   true,
+	"code_verifier": true,
+	"ticket":        true,
+	"passphrase":    true,
+	"password":      true,
+}
+
+// oauthEndpoints exchange an authorization code, sent as "code". Elsewhere
+// "code" is not a secret: it's e.g. the code of an API error.
+var oauthEndpoints = map[string]bool{
+	"/api/auth/token":    true,
+	"/api/auth/callback": true,
+	"/api/auth/revoke":   true,
+}
+
+// isRedacted reports whether field (or query parameter) name of a request
+// to path holds a secret
+func isRedacted(path, name string) bool {
+	return redactedFields[name] || (name == "code" && oauthEndpoints[path])
+}
+
+// tracer writes the debug trace. It is nil unless debugging is enabled.
+type tracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var trace *tracer
+
+// EnableDebug turns on tracing of every HTTP request and WebSocket frame.
+// dest is "stderr" (or "1"/"true") or the path of a file to append to; an
+// empty or false value leaves tracing off.
+func EnableDebug(dest string) error {
+	switch strings.ToLower(dest) {
+	case "", "0", "false", "off":
+		return nil
+	case "1", "true", "on", "stderr":
+		trace = &tracer{w: os.Stderr}
+	default:
+		file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open debug file: %w", err)
+		}
+		trace = &tracer{w: file}
+	}
+	return nil
+}
+
+// tracef writes one trace entry; extra lines are indented under it
+func tracef(format string, args ...interface{}) {
+	if trace == nil {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	msg = strings.ReplaceAll(strings.TrimRight(msg, "\n"), "\n", "\n    ")
+
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	fmt.Fprintf(trace.w, "[debug %s] %s\n", time.Now().Format("15:04:05.000"), msg)
+}
+
+// tracingTransport traces requests and responses passing through it
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var entry strings.Builder
+	fmt.Fprintf(&entry, "→ %s %s", req.Method, redactURL(req.URL))
+	writeTraceHeaders(&entry, req.Header)
+	entry.WriteString(requestBodyTrace(req))
+	tracef("%s", entry.String())
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		tracef("✗ %s %s failed after %s: %v", req.Method, redactURL(req.URL), elapsed, err)
+		return nil, err
+	}
+
+	entry.Reset()
+	fmt.Fprintf(&entry, "← %s %s %s (%s)", resp.Status, req.Method, redactURL(req.URL), elapsed)
+	writeTraceHeaders(&entry, resp.Header)
+
+	if isEventStream(resp.Header) {
+		// Events arrive for as long as the stream lasts; each is traced as
+		// it's read
+		entry.WriteString("\nbody: <event stream>")
+		tracef("%s", entry.String())
+		return resp, nil
+	}
+
+	// Buffer the body so it can be traced and still be read by the caller
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		fmt.Fprintf(&entry, "\nbody: read failed: %v", readErr)
+	} else if len(body) > 0 {
+		fmt.Fprintf(&entry, "\nbody: %s", traceBody(req.URL.Path, resp.Header.Get("Content-Type"), body))
+	}
+	tracef("%s", entry.String())
+
+	return resp, readErr
+}
+
+// isEventStream reports whether a response is a Server-Sent Events stream
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+func writeTraceHeaders(entry *strings.Builder, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = redactHeader(value)
+		}
+		fmt.Fprintf(entry, "\n%s: %s", name, value)
+	}
+}
+
+// redactHeader keeps the auth scheme (e.g. "Bearer") so the trace still
+// shows what kind of credentials were sent
+func redactHeader(value string) string {
+	if scheme, _, found := strings.Cut(value, " "); found {
+		return scheme + " " + redacted
+	}
+	return redacted
+}
+
+func requestBodyTrace(req *http.Request) string {
+	if req.Body == nil || req.Body == http.NoBody {
+		return ""
+	}
+	if req.GetBody == nil {
+		// Streamed bodies (archives) are produced while they're sent
+		return fmt.Sprintf("\nbody: <streamed %s>", req.Header.Get("Content-Type"))
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return ""
+	}
+	return "\nbody: " + traceBody(req.URL.Path, req.Header.Get("Content-Type"), data)
+}
+
+// traceBody renders a body sent to or received from path for the trace:
+// JSON with secrets redacted, text as-is, binary as its size only; truncated
+// to traceBodyLimit on a character boundary
+func traceBody(path, contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var text string
+	switch {
+	case mediaType == "application/json" || json.Valid(body):
+		text = string(redactJSON(path, body))
+	case strings.HasPrefix(mediaType, "text/"):
+		text = string(body)
+	default:
+		return fmt.Sprintf("<%d bytes %s>", len(body), mediaType)
+	}
+
+	if len(text) > traceBodyLimit {
+		cut := traceBodyLimit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		return fmt.Sprintf("%s... (%d bytes total)", text[:cut], len(text))
+	}
+	return text
+}
+
+// redactJSON replaces the values of the secret top-level fields of a JSON
+// document sent to or received from path. Secrets are never nested in API
+// bodies, and nested fields such as the error envelope's "code" stay visible.
+func redactJSON(path string, body []byte) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body
+	}
+
+	if fields, ok := doc.(map[string]interface{}); ok {
+		for key := range fields {
+			if isRedacted(path, key) {
+				fields[key] = redacted
+			}
+		}
+	}
+
+	clean, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return clean
+}
+
+// redactURL hides secret query parameters
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+
+	query := u.Query()
+	for key := range query {
+		if isRedacted(u.Path, key) {
+			query.Set(key, redacted)
+		}
+	}
+	clean := *u
+	clean.RawQuery = query.Encode()
+	return clean.String()
+}
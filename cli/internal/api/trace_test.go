@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name, path, body, want string
+	}{
+		{
+			name: "token response",
+			path: "/api/auth/token",
+			body: `{"access_token": "at", "refresh_token": "rt", "token_type": "Bearer"}`,
+			want: `{"access_token":"[REDACTED]","refresh_token":"[REDACTED]","token_type":"Bearer"}`,
+		},
+		{
+			name: "authorization code",
+			path: "/api/auth/token",
+			body: `{"grant_type": "authorization_code", "code": "abc", "code_verifier": "xyz"}`,
+			want: `{"code":"[REDACTED]","code_verifier":"[REDACTED]","grant_type":"authorization_code"}`,
+		},
+		{
+			name: "error envelope",
+			path: "/api/tokens",
+			body: `{"error": {"code": "not_found", "message": "Token not found", "details": {"token": "tok_1"}}}`,
+			want: `{"error":{"code":"not_found","details":{"token":"tok_1"},"message":"Token not found"}}`,
+		},
+		{
+			name: "code outside OAuth",
+			path: "/api/deploy",
+			body: `{"code": "print(1)"}`,
+			want: `{"code":"print(1)"}`,
+		},
+		{
+			name: "not an object",
+			path: "/api/auth/token",
+			body: `["access_token"]`,
+			want: `["access_token"]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactJSON(tt.path, []byte(tt.body))); got != tt.want {
+				t.Errorf("redactJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"http://api/api/auth/callback?code=abc&state=s", "http://api/api/auth/callback?code=%5BREDACTED%5D&state=s"},
+		{"http://api/ws?deploymentId=d&ticket=t", "http://api/ws?deploymentId=d&ticket=%5BREDACTED%5D"},
+		{"http://api/api/projects?code=web", "http://api/api/projects?code=web"},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.raw)
+		if got := redactURL(u); got != tt.want {
+			t.Errorf("redactURL(%s) = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestTraceHeadersRedacted(t *testing.T) {
+	var entry strings.Builder
+	writeTraceHeaders(&entry, http.Header{
+		"Authorization":       {"Bearer secret-1"},
+		"Proxy-Authorization": {"Basic secret-2"},
+		"Accept":              {"application/json"},
+	})
+
+	if strings.Contains(entry.String(), "secret") {
+		t.Errorf("trace shows credentials:%s", entry.String())
+	}
+	for _, want := range []string{"Authorization: Bearer [REDACTED]", "Proxy-Authorization: Basic [REDACTED]", "Accept: application/json"} {
+		if !strings.Contains(entry.String(), want) {
+			t.Errorf("trace lacks %q:%s", want, entry.String())
+		}
+	}
+}
+
+func TestTraceRequest(t *testing.T) {
+	var out strings.Builder
+	defer func(saved *tracer) { trace = saved }(trace)
+	trace = &tracer{w: &out}
+
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "tok_1", "name": "ci", "token": "secret-2"}`))
+	}))
+	c.SetAuthToken("secret-1")
+	if _, err := c.CreatePersonalAccessToken(context.Background(), "ci", []string{"deploy"}, time.Hour); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if strings.Contains(out.String(), "secret") {
+		t.Errorf("trace shows credentials:\n%s", out.String())
+	}
+	for _, want := range []string{"→ POST ", "Authorization: Bearer [REDACTED]", "← 200 OK POST ", `"id":"tok_1"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("trace lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestTraceBodyTruncatesOnRuneBoundary(t *testing.T) {
+	// Put a multi-byte character across the limit
+	body := strings.Repeat("a", traceBodyLimit-1) + "é" + strings.Repeat("b", 10)
+	got := traceBody("", "text/plain", []byte(body))
+
+	if !utf8.ValidString(got) {
+		t.Errorf("truncated body is not valid UTF-8")
+	}
+	if !strings.HasPrefix(got, strings.Repeat("a", traceBodyLimit-1)+"...") {
+		t.Errorf("body cut at the wrong place: %q", got[traceBodyLimit-5:])
+	}
+}
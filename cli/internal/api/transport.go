@@ -0,0 +1,177 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/backend-im/cli/internal/auth"
+)
+
+// NetworkConfig is how the CLI reaches the API: an explicit proxy, extra CA
+// certificates, a client certificate for mutual TLS, and - for local testing
+// only - skipping certificate verification. WebSocketRewrites are
+// HostRewrite rules (from=to) for the WebSocket URLs the server hands out.
+type NetworkConfig struct {
+	Proxy              string
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+	WebSocketRewrites  []string
+}
+
+// networkOverride is set from the global network flags and takes precedence
+// over the active context's settings
+var networkOverride NetworkConfig
+
+var (
+	networkOnce sync.Once
+	network     *networkSettings
+	networkErr  error
+)
+
+// networkSettings is a NetworkConfig loaded and ready to use
+type networkSettings struct {
+	proxy func(*http.Request) (*url.URL, error)
+	tls   *tls.Config
+}
+
+// SetNetworkOverride applies network settings for this process (from flags)
+func SetNetworkOverride(config NetworkConfig) {
+	networkOverride = config
+}
+
+// resolveNetworkConfig merges the flags over the active context's settings
+func resolveNetworkConfig() NetworkConfig {
+	config := NetworkConfig{}
+	if _, ctx, err := auth.CurrentContext(); err == nil {
+		config = NetworkConfig{
+			Proxy:              ctx.Proxy,
+			CACert:             ctx.CACert,
+			ClientCert:         ctx.ClientCert,
+			ClientKey:          ctx.ClientKey,
+			InsecureSkipVerify: ctx.InsecureSkipVerify,
+			WebSocketRewrites:  ctx.WSRewrites,
+		}
+	}
+
+	if networkOverride.Proxy != "" {
+		config.Proxy = networkOverride.Proxy
+	}
+	if networkOverride.CACert != "" {
+		config.CACert = networkOverride.CACert
+	}
+	if networkOverride.ClientCert != "" || networkOverride.ClientKey != "" {
+		config.ClientCert = networkOverride.ClientCert
+		config.ClientKey = networkOverride.ClientKey
+	}
+	if networkOverride.InsecureSkipVerify {
+		config.InsecureSkipVerify = true
+	}
+	if len(networkOverride.WebSocketRewrites) > 0 {
+		// Tried first, so they take precedence
+		config.WebSocketRewrites = append(append([]string{}, networkOverride.WebSocketRewrites...), config.WebSocketRewrites...)
+	}
+	return config
+}
+
+// loadNetwork loads the network settings once per process, so every client
+// and the WebSocket dialer share them
+func loadNetwork() (*networkSettings, error) {
+	networkOnce.Do(func() {
+		config := resolveNetworkConfig()
+		network, networkErr = config.load()
+		if networkErr == nil && config.InsecureSkipVerify {
+			fmt.Fprintln(os.Stderr, "⚠️  INSECURE: TLS certificate verification is disabled - only use this for local testing")
+		}
+	})
+	return network, networkErr
+}
+
+func (n NetworkConfig) load() (*networkSettings, error) {
+	settings := &networkSettings{
+		proxy: http.ProxyFromEnvironment,
+		tls:   &tls.Config{InsecureSkipVerify: n.InsecureSkipVerify},
+	}
+
+	if n.Proxy != "" {
+		proxyURL, err := url.Parse(n.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q - use e.g. http://proxy.example.com:3128", n.Proxy)
+		}
+		settings.proxy = http.ProxyURL(proxyURL)
+	}
+
+	if n.CACert != "" {
+		// Extra CAs are trusted in addition to the system's
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(n.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", n.CACert)
+		}
+		settings.tls.RootCAs = pool
+	}
+
+	if n.ClientCert != "" || n.ClientKey != "" {
+		if n.ClientCert == "" || n.ClientKey == "" {
+			return nil, fmt.Errorf("a client certificate and its key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(n.ClientCert, n.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		settings.tls.Certificates = []tls.Certificate{cert}
+	}
+
+	return settings, nil
+}
+
+// transportOverride replaces the network for every client, see SetTransport
+var transportOverride http.RoundTripper
+
+// SetTransport sends the requests of every client created afterwards through
+// rt instead of the network, e.g. a Fixture; nil restores the network. If rt
+// is a Fixture, WebSocket streams go through it too.
+func SetTransport(rt http.RoundTripper) {
+	transportOverride = rt
+}
+
+// newTransport returns the transport API requests go through
+func newTransport() (http.RoundTripper, error) {
+	transport := transportOverride
+	if transport == nil {
+		var err error
+		if transport, err = networkTransport(); err != nil {
+			return nil, err
+		}
+	}
+
+	if trace != nil {
+		return &tracingTransport{base: transport}, nil
+	}
+	return transport, nil
+}
+
+// networkTransport returns a transport using the network settings
+func networkTransport() (http.RoundTripper, error) {
+	settings, err := loadNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = settings.proxy
+	transport.TLSClientConfig = settings.tls.Clone()
+	return transport, nil
+}
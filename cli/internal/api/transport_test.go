@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configClient returns an HTTP client using config the way networkTransport does
+func configClient(t *testing.T, config NetworkConfig) *http.Client {
+	t.Helper()
+	settings, err := config.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = settings.proxy
+	transport.TLSClientConfig = settings.tls.Clone()
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+// writePEM writes one PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clientCertificate creates a self-signed client certificate and its key
+func clientCertificate(t *testing.T) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "backend-im-cli"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, der, keyDER
+}
+
+func TestNetworkConfigProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte(`{}`))
+	}))
+	defer proxy.Close()
+
+	client := configClient(t, NetworkConfig{Proxy: proxy.URL})
+	resp, err := client.Get("http://api.backend-im.invalid/api/version")
+	if err != nil {
+		t.Fatalf("request through proxy: %v", err)
+	}
+	resp.Body.Close()
+	if len(proxied) != 1 || proxied[0] != "http://api.backend-im.invalid/api/version" {
+		t.Errorf("proxy saw %v", proxied)
+	}
+
+	for _, invalid := range []string{"proxy.example.com:3128", "://"} {
+		if _, err := (NetworkConfig{Proxy: invalid}).load(); err == nil || !strings.Contains(err.Error(), "invalid proxy URL") {
+			t.Errorf("proxy %q: err = %v, want an invalid proxy URL error", invalid, err)
+		}
+	}
+}
+
+func TestNetworkConfigCACert(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	// Not trusted by default
+	if _, err := configClient(t, NetworkConfig{}).Get(ts.URL); err == nil {
+		t.Errorf("request to a server with an unknown CA succeeded")
+	}
+
+	dir := t.TempDir()
+	caCert := writePEM(t, dir, "ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+	resp, err := configClient(t, NetworkConfig{CACert: caCert}).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request with the CA certificate: %v", err)
+	}
+	resp.Body.Close()
+
+	notPEM := filepath.Join(dir, "ca.txt")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0600)
+	if _, err := (NetworkConfig{CACert: notPEM}).load(); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("CA file without certificates: err = %v", err)
+	}
+	if _, err := (NetworkConfig{CACert: filepath.Join(dir, "missing.pem")}).load(); err == nil {
+		t.Errorf("missing CA file: no error")
+	}
+}
+
+func TestNetworkConfigClientCert(t *testing.T) {
+	cert, certDER, keyDER := clientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caCert := writePEM(t, dir, "ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+	clientCert := writePEM(t, dir, "client.pem", "CERTIFICATE", certDER)
+	clientKey := writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+
+	// The server requires a client certificate
+	if _, err := configClient(t, NetworkConfig{CACert: caCert}).Get(ts.URL); err == nil {
+		t.Errorf("request without a client certificate succeeded")
+	}
+
+	resp, err := configClient(t, NetworkConfig{CACert: caCert, ClientCert: clientCert, ClientKey: clientKey}).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request with the client certificate: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, config := range []NetworkConfig{{ClientCert: clientCert}, {ClientKey: clientKey}} {
+		if _, err := config.load(); err == nil || !strings.Contains(err.Error(), "must be set together") {
+			t.Errorf("%+v: err = %v, want the certificate and key to be required together", config, err)
+		}
+	}
+}
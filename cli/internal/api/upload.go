@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+
+	"github.com/backend-im/cli/internal/files"
+)
+
+// Upload methods, from most to least efficient. The client falls back to the
+// next one when the server doesn't support a method.
+const (
+	UploadDelta   = "delta"   // only blobs the server is missing
+	UploadArchive = "archive" // the whole project as a streamed tar.gz
+	UploadJSON    = "json"    // the whole project as a JSON map
+)
+
+// UploadStats reports how a project was uploaded and how much was sent
+type UploadStats struct {
+	Method       string
+	Files        int
+	ChangedFiles int   // files whose contents had to be sent
+	TotalBytes   int64 // size of all project files
+	SentBytes    int64 // file bytes actually sent
+}
+
+// SavedBytes is how much less was sent than a full upload
+func (s *UploadStats) SavedBytes() int64 {
+	return s.TotalBytes - s.SentBytes
+}
+
+// DeployProject deploys the project using the most efficient upload method
+// the server supports
+func (c *Client) DeployProject(ctx context.Context, project *files.Project, projectID string) (*DeployResponse, *UploadStats, error) {
+	var response DeployResponse
+	stats, err := c.uploadProject(ctx, "/api/deploy", project, UploadManifest{ProjectID: projectID}, &response)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	return &response, stats, nil
+}
+
+// CommitProject commits the project using the most efficient upload method
+// the server supports
+func (c *Client) CommitProject(ctx context.Context, project *files.Project, projectID, message string) (*CommitResponse, *UploadStats, error) {
+	var response CommitResponse
+	stats, err := c.uploadProject(ctx, "/api/commit", project, UploadManifest{ProjectID: projectID, Message: message}, &response)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	return &response, stats, nil
+}
+
+func (c *Client) uploadProject(ctx context.Context, path string, project *files.Project, manifest UploadManifest, response interface{}) (*UploadStats, error) {
+	// The handshake tells which upload methods the server supports
+	c.negotiate(ctx)
+
+	stats := &UploadStats{
+		Files:        len(project.Files),
+		ChangedFiles: len(project.Files),
+		TotalBytes:   project.TotalSize(),
+		SentBytes:    project.TotalSize(),
+	}
+
+	if !c.deltaUnsupported {
+		stats.Method = UploadDelta
+		err := c.postDelta(ctx, path, project, manifest, response, stats)
+		if !c.fallBackFromDelta(err) {
+			return stats, err
+		}
+		stats.ChangedFiles = stats.Files
+		stats.SentBytes = stats.TotalBytes
+	}
+
+	if !c.archiveUnsupported {
+		stats.Method = UploadArchive
+		err := c.postArchive(ctx, path, project, manifest, response)
+		if !c.fallBackToJSON(err) {
+			return stats, err
+		}
+	}
+
+	stats.Method = UploadJSON
+	fileMap, err := project.ReadFiles()
+	if err != nil {
+		return stats, err
+	}
+	body := map[string]interface{}{
+		"files":     fileMap,
+		"projectId": manifest.ProjectID,
+	}
+	if manifest.Message != "" {
+		body["message"] = manifest.Message
+	}
+	return stats, c.postIdempotent(ctx, path, body, response)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/backend-im/cli/internal/auth"
+)
+
+// APIVersion is the version of the Backend.im API this CLI speaks. The
+// server reports the range of API versions it supports.
+const APIVersion = 1
+
+// Capabilities a server may report. Servers that predate the version
+// handshake report none; the client then probes for features instead.
+const (
+	CapabilityDeltaUploads     = "delta_uploads"
+	CapabilityArchiveUploads   = "archive_uploads"
+	CapabilityUploadSessions   = "upload_sessions"
+	CapabilityWebSocketTickets = "websocket_tickets"
+	CapabilitySSEUpdates       = "sse_updates"
+	CapabilityLongPollUpdates  = "longpoll_updates"
+)
+
+// serverInfoTTL is how long a handshake result is reused
+const serverInfoTTL = time.Hour
+
+const serverInfoFile = "server.json"
+
+// ServerInfo is the server's answer to the version handshake
+type ServerInfo struct {
+	Version       string   `json:"version"`
+	APIVersion    int      `json:"apiVersion"`
+	MinAPIVersion int      `json:"minApiVersion"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// Has reports whether the server supports a capability
+func (s *ServerInfo) Has(capability string) bool {
+	for _, c := range s.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// serverInfoCache is the handshake result saved per context. Server is nil
+// when the server has no version endpoint.
+type serverInfoCache struct {
+	APIURL    string      `json:"apiUrl"`
+	CheckedAt time.Time   `json:"checkedAt"`
+	Server    *ServerInfo `json:"server"`
+}
+
+// ServerInfo returns the server's version and capabilities, or nil if the
+// server predates the version handshake
+func (c *Client) ServerInfo(ctx context.Context) *ServerInfo {
+	c.negotiate(ctx)
+	return c.server
+}
+
+// negotiate runs the version handshake once per client, warns if the CLI and
+// server don't speak a common API version, and turns off the code paths for
+// features the server lacks. Failures are ignored: the client then probes
+// for features as it goes.
+func (c *Client) negotiate(ctx context.Context) {
+	if c.negotiated {
+		return
+	}
+	c.negotiated = true
+
+	cachePath := serverInfoCachePath()
+	cache := loadServerInfo(cachePath, c.baseURL)
+	if cache == nil {
+		server, err := c.fetchServerInfo(ctx)
+		if err != nil {
+			tracef("Version handshake failed: %v", err)
+			return
+		}
+		cache = &serverInfoCache{APIURL: c.baseURL, CheckedAt: time.Now(), Server: server}
+		saveServerInfo(cachePath, cache)
+	}
+
+	c.server = cache.Server
+	if c.server == nil {
+		return
+	}
+
+	switch {
+	case c.server.MinAPIVersion > APIVersion:
+		fmt.Fprintf(os.Stderr, "⚠️  This CLI is too old for %s: the server requires API version %d or later, the CLI speaks %d - please upgrade the CLI\n",
+			c.baseURL, c.server.MinAPIVersion, APIVersion)
+	case c.server.APIVersion < APIVersion:
+		fmt.Fprintf(os.Stderr, "⚠️  This CLI is newer than the server at %s (version %s, API version %d; the CLI speaks %d) - some features may not work\n",
+			c.baseURL, c.server.Version, c.server.APIVersion, APIVersion)
+	}
+
+	c.deltaUnsupported = !c.server.Has(CapabilityDeltaUploads)
+	c.archiveUnsupported = !c.server.Has(CapabilityArchiveUploads)
+	c.sessionsUnsupported = !c.server.Has(CapabilityUploadSessions)
+}
+
+// fetchServerInfo calls GET /api/version once, without retries: the
+// handshake must not hold up the request it precedes. A server without the
+// endpoint yields nil.
+func (c *Client) fetchServerInfo(ctx context.Context) (*ServerInfo, error) {
+	resp, err := c.send(ctx, &apiRequest{method: "GET", path: "/api/version"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, parseError(resp, body)
+	}
+
+	var server ServerInfo
+	if err := json.Unmarshal(body, &server); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &server, nil
+}
+
+// serverInfoCachePath is where the active context keeps its handshake result
+func serverInfoCachePath() string {
+	name, _, err := auth.CurrentContext()
+	if err != nil {
+		return ""
+	}
+	dir, err := auth.ContextDir(name)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, serverInfoFile)
+}
+
+// loadServerInfo returns the saved handshake for apiURL, unless it is missing
+// or stale
+func loadServerInfo(path, apiURL string) *serverInfoCache {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var cache serverInfoCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil
+	}
+	if cache.APIURL != apiURL || time.Since(cache.CheckedAt) > serverInfoTTL {
+		return nil
+	}
+	return &cache
+}
+
+// saveServerInfo caches a handshake; failing to is harmless
+func saveServerInfo(path string, cache *serverInfoCache) {
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return
+	}
+	auth.WriteFileAtomic(path, data)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+func TestNegotiateCachesHandshake(t *testing.T) {
+	var handshakes atomic.Int32
+	testenv.NewVersionedAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/version" {
+			http.NotFound(w, r)
+			return
+		}
+		handshakes.Add(1)
+		json.NewEncoder(w).Encode(ServerInfo{
+			Version:      "test",
+			APIVersion:   APIVersion,
+			Capabilities: []string{CapabilityArchiveUploads},
+		})
+	}))
+
+	c := NewClient()
+	info := c.ServerInfo(context.Background())
+	if info == nil || info.Version != "test" {
+		t.Fatalf("ServerInfo = %+v, want the server's answer", info)
+	}
+	if !c.deltaUnsupported || c.archiveUnsupported || !c.sessionsUnsupported {
+		t.Errorf("upload methods not set from capabilities: delta off %v, archive off %v, sessions off %v",
+			c.deltaUnsupported, c.archiveUnsupported, c.sessionsUnsupported)
+	}
+
+	// Another client reuses the saved handshake
+	if info := NewClient().ServerInfo(context.Background()); info == nil || !info.Has(CapabilityArchiveUploads) {
+		t.Errorf("cached ServerInfo = %+v", info)
+	}
+	if n := handshakes.Load(); n != 1 {
+		t.Errorf("made %d handshakes, want 1", n)
+	}
+	if _, err := os.Stat(serverInfoCachePath()); err != nil {
+		t.Errorf("handshake not saved: %v", err)
+	}
+}
+
+func TestNegotiateWithoutVersionEndpoint(t *testing.T) {
+	testenv.NewAPI(t, http.NotFoundHandler())
+
+	c := NewClient()
+	if info := c.ServerInfo(context.Background()); info != nil {
+		t.Errorf("ServerInfo = %+v, want nil from a server without /api/version", info)
+	}
+	// Without a handshake every upload method is tried
+	if c.deltaUnsupported || c.archiveUnsupported || c.sessionsUnsupported {
+		t.Errorf("upload methods turned off without a handshake")
+	}
+}
+
+func TestLoadServerInfoChecksAPIURLAndAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), serverInfoFile)
+	saveServerInfo(path, &serverInfoCache{APIURL: "https://a.example", CheckedAt: time.Now(), Server: &ServerInfo{Version: "a"}})
+
+	if cache := loadServerInfo(path, "https://a.example"); cache == nil || cache.Server.Version != "a" {
+		t.Errorf("saved handshake not loaded: %+v", cache)
+	}
+	if cache := loadServerInfo(path, "https://b.example"); cache != nil {
+		t.Errorf("reused the handshake of another API URL")
+	}
+
+	saveServerInfo(path, &serverInfoCache{APIURL: "https://a.example", CheckedAt: time.Now().Add(-2 * serverInfoTTL)})
+	if cache := loadServerInfo(path, "https://a.example"); cache != nil {
+		t.Errorf("reused a stale handshake")
+	}
+}
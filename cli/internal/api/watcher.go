@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transports a deployment's updates can be watched over
+const (
+	TransportAuto      = "auto"
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+	TransportLongPoll  = "longpoll"
+)
+
+// Transports lists the transports from the best to the last resort
+var Transports = []string{TransportWebSocket, TransportSSE, TransportLongPoll}
+
+// DefaultStageTimeout is how long a deployment may stay in one status,
+// however alive the connection, before watching it fails
+const DefaultStageTimeout = 30 * time.Minute
+
+// StageTimeoutEnv overrides DefaultStageTimeout, in seconds (0 disables)
+const StageTimeoutEnv = "BACKEND_IM_STAGE_TIMEOUT"
+
+// transportNames are the transports as shown to the user
+var transportNames = map[string]string{
+	TransportWebSocket: "WebSocket",
+	TransportSSE:       "Server-Sent Events",
+	TransportLongPoll:  "long polling",
+}
+
+// DeploymentWatcher follows a deployment's updates. Whatever the transport,
+// callers get the same DeploymentUpdates in order, each exactly once.
+type DeploymentWatcher interface {
+	// Transport names the transport in use, e.g. "websocket"
+	Transport() string
+
+	// Watch calls callback with each update after sequence number since
+	// until the deployment is complete or failed, the server ends the
+	// stream, callback returns an error or ctx is cancelled
+	Watch(ctx context.Context, deploymentID string, since int64, callback func(*DeploymentUpdate) error) error
+}
+
+// NewDeploymentWatcher returns a watcher using transport. With "auto" (or
+// "") it uses the best transport the server offers and falls back to the
+// next one, resuming after the last update received, when a transport is
+// unavailable - e.g. a proxy that blocks WebSockets. websocketURL is the
+// one the API returned for the deployment, if any.
+func NewDeploymentWatcher(apiClient *Client, transport, websocketURL string) (DeploymentWatcher, error) {
+	if err := ValidateTransport(transport); err != nil {
+		return nil, err
+	}
+
+	w := &autoWatcher{
+		api:          apiClient,
+		websocketURL: websocketURL,
+		stageTimeout: durationFromEnv(StageTimeoutEnv, DefaultStageTimeout),
+	}
+	if transport != "" && transport != TransportAuto {
+		w.transports = []string{transport}
+	}
+	return w, nil
+}
+
+// ValidateTransport checks a transport name, as given to --transport
+func ValidateTransport(transport string) error {
+	switch transport {
+	case "", TransportAuto, TransportWebSocket, TransportSSE, TransportLongPoll:
+		return nil
+	}
+	return fmt.Errorf("unknown transport %q (use %s, %s)", transport, TransportAuto, strings.Join(Transports, ", "))
+}
+
+// autoWatcher watches over each candidate transport in turn until one
+// works. It also times the deployment's stages, across transports and
+// reconnects.
+type autoWatcher struct {
+	api          *Client
+	websocketURL string
+	stageTimeout time.Duration
+
+	// transports are the candidates; nil means every transport the server
+	// offers
+	transports []string
+
+	// current is the transport last used
+	current string
+}
+
+func (w *autoWatcher) Transport() string {
+	if w.current == "" {
+		return TransportAuto
+	}
+	return w.current
+}
+
+func (w *autoWatcher) Watch(ctx context.Context, deploymentID string, since int64, callback func(*DeploymentUpdate) error) error {
+	stageCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stage := newStageTimer(w.stageTimeout, cancel)
+	defer stage.stop()
+
+	// Updates are tracked here so the next transport resumes where the last
+	// one stopped
+	var callbackErr error
+	unsequenced := false
+	deliver := func(update *DeploymentUpdate) error {
+		if update.Seq == 0 {
+			unsequenced = true
+		} else if update.Seq <= since {
+			return nil
+		}
+		stage.progress(update.Status)
+		if err := callback(update); err != nil {
+			callbackErr = err
+			return err
+		}
+		if update.Seq > since {
+			since = update.Seq
+		}
+		return nil
+	}
+
+	transports := w.candidates(ctx)
+	for i, transport := range transports {
+		w.current = transport
+		err := w.watcher(transport).Watch(stageCtx, deploymentID, since, deliver)
+		if ctx.Err() == nil && stageCtx.Err() != nil {
+			// The stage timed out: another transport won't speed it up
+			return context.Cause(stageCtx)
+		}
+		if err == nil || callbackErr != nil || ctx.Err() != nil || !canFallBack(err) || i == len(transports)-1 {
+			return err
+		}
+		if unsequenced {
+			return fmt.Errorf("%w (the server doesn't number updates, so another transport can't resume the stream)", err)
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  %s unavailable: %v - falling back to %s\n",
+			transportNames[transport], err, transportNames[transports[i+1]])
+	}
+	return nil
+}
+
+// candidates returns the transports to try in order: the one asked for, or
+// those the server reports. A server that predates the version handshake
+// may offer any of them.
+func (w *autoWatcher) candidates(ctx context.Context) []string {
+	if w.transports != nil {
+		return w.transports
+	}
+
+	server := w.api.ServerInfo(ctx)
+	if server == nil {
+		return Transports
+	}
+	transports := []string{TransportWebSocket}
+	if server.Has(CapabilitySSEUpdates) {
+		transports = append(transports, TransportSSE)
+	}
+	if server.Has(CapabilityLongPollUpdates) {
+		transports = append(transports, TransportLongPoll)
+	}
+	return transports
+}
+
+func (w *autoWatcher) watcher(transport string) DeploymentWatcher {
+	switch transport {
+	case TransportSSE:
+		return newSSEWatcher(w.api)
+	case TransportLongPoll:
+		return &longPollWatcher{api: w.api}
+	default:
+		return &webSocketWatcher{api: w.api, websocketURL: w.websocketURL}
+	}
+}
+
+// canFallBack reports whether err may be specific to the transport, so
+// another one is worth trying. Rejected credentials are rejected by all.
+func canFallBack(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.IsAuth() {
+		return false
+	}
+	var oauthErr *OAuthError
+	return !errors.As(err, &oauthErr)
+}
+
+// stageTimer cancels the watch with a stage timeout error when the
+// deployment stays in one status too long
+type stageTimer struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	stage   string
+	timer   *time.Timer
+}
+
+func newStageTimer(timeout time.Duration, cancel context.CancelCauseFunc) *stageTimer {
+	s := &stageTimer{timeout: timeout}
+	if timeout <= 0 {
+		return s
+	}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		stage := s.stage
+		s.mu.Unlock()
+		if stage == "" {
+			cancel(fmt.Errorf("no deployment updates for %s (stage timeout)", timeout))
+			return
+		}
+		cancel(fmt.Errorf("deployment made no progress in %s for %s (stage timeout)", stage, timeout))
+	})
+	return s
+}
+
+// progress restarts the timer when the deployment enters a new status
+func (s *stageTimer) progress(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status != s.stage {
+		s.stage = status
+		if s.timer != nil {
+			s.timer.Reset(s.timeout)
+		}
+	}
+}
+
+func (s *stageTimer) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// resumableStream is a transport's connection to the update stream that
+// can be reopened after it drops, resuming after the last update delivered
+type resumableStream interface {
+	// connect opens a new connection, resuming after the last update
+	connect(ctx context.Context) error
+
+	// read delivers updates from the connection until it ends. A dropped
+	// connection is reported as a *connectionLostError.
+	read(ctx context.Context, callback func(*DeploymentUpdate) error) error
+
+	// resumePoint returns the sequence number of the last update delivered,
+	// and whether the server sent any without one
+	resumePoint() (lastSeq int64, unsequenced bool)
+}
+
+// followStream reads an open stream until it ends for any reason but a lost
+// connection. When the connection drops it reconnects with backoff and
+// resumes, so updates are neither lost nor repeated.
+func followStream(ctx context.Context, stream resumableStream, policy RetryPolicy, callback func(*DeploymentUpdate) error) error {
+	attempt := 0
+	for {
+		seq, _ := stream.resumePoint()
+		err := stream.read(ctx, callback)
+
+		var lost *connectionLostError
+		if !errors.As(err, &lost) {
+			return err
+		}
+		lastSeq, unsequenced := stream.resumePoint()
+		if unsequenced {
+			return fmt.Errorf("%w (the server doesn't number updates, so the stream can't be resumed)", err)
+		}
+		if lastSeq > seq {
+			// The last connection made progress: start backing off afresh
+			attempt = 0
+		}
+		if err := reconnectStream(ctx, stream, policy, err, &attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnectStream reopens a stream that was lost, backing off between
+// attempts
+func reconnectStream(ctx context.Context, stream resumableStream, policy RetryPolicy, lost error, attempt *int) error {
+	reason := lost
+	for {
+		*attempt++
+		if *attempt > policy.MaxRetries {
+			if reason != lost {
+				return fmt.Errorf("%w - gave up after %d reconnect attempts: %v", lost, policy.MaxRetries, reason)
+			}
+			return fmt.Errorf("%w - gave up after %d reconnect attempts", lost, policy.MaxRetries)
+		}
+
+		delay := policy.backoff(*attempt)
+		fmt.Fprintf(os.Stderr, "🔌 %v, reconnecting in %s (%d/%d)\n",
+			reason, delay.Round(100*time.Millisecond), *attempt, policy.MaxRetries)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		if err := stream.connect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			reason = err
+			continue
+		}
+		lastSeq, _ := stream.resumePoint()
+		fmt.Fprintf(os.Stderr, "🔌 Reconnected, resuming after update %d\n", lastSeq)
+		return nil
+	}
+}
+
+// webSocketWatcher follows the deployment over /ws, reconnecting as
+// WebSocketClient does
+type webSocketWatcher struct {
+	api          *Client
+	websocketURL string
+}
+
+func (w *webSocketWatcher) Transport() string {
+	return TransportWebSocket
+}
+
+func (w *webSocketWatcher) Watch(ctx context.Context, deploymentID string, since int64, callback func(*DeploymentUpdate) error) error {
+	// Prefer the URL the API returned. In containers it may name a host that
+	// isn't reachable (e.g. localhost): --ws-rewrite rules map it, and the
+	// API client's base URL (e.g. http://mock-api:8080) is the fallback.
+	ws := NewWebSocketClient(w.api.BaseURL())
+	ws.UseServerURL(w.websocketURL)
+	ws.Authenticate(w.api)
+	ws.lastSeq = since
+
+	if err := ws.Connect(ctx, deploymentID); err != nil {
+		return err
+	}
+	return ws.StreamUpdates(ctx, callback)
+}
+
+// final reports whether the update is the deployment's last
+func (u *DeploymentUpdate) final() bool {
+	return u.Status == "complete" || u.Status == "failed"
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/testenv"
+	"github.com/gorilla/websocket"
+)
+
+func TestDeploymentWatcherFallsBack(t *testing.T) {
+	var mu sync.Mutex
+	var sinces []string
+	testenv.NewVersionedAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			json.NewEncoder(w).Encode(ServerInfo{
+				Version:      "test",
+				APIVersion:   APIVersion,
+				Capabilities: []string{CapabilitySSEUpdates, CapabilityLongPollUpdates},
+			})
+		case "/ws":
+			// A proxy that doesn't do WebSockets
+			w.WriteHeader(http.StatusBadGateway)
+		case "/api/deployments/dep-1/events":
+			// ...nor event streams: it buffers them into a page
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Please wait</html>"))
+		case "/api/deployments/dep-1/updates":
+			mu.Lock()
+			since := r.URL.Query().Get("since")
+			sinces = append(sinces, since)
+			mu.Unlock()
+
+			updates := []DeploymentUpdate{
+				{Seq: 1, DeploymentID: "dep-1", Status: "building"},
+				{Seq: 2, DeploymentID: "dep-1", Status: "deploying"},
+			}
+			if since == "2" {
+				updates = []DeploymentUpdate{{Seq: 3, DeploymentID: "dep-1", Status: "complete"}}
+			}
+			json.NewEncoder(w).Encode(DeploymentUpdateList{Updates: updates})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	c := NewClient()
+	c.SetAuthToken("test-token")
+	c.SetRetryPolicy(RetryPolicy{})
+	watcher, err := NewDeploymentWatcher(c, TransportAuto, "")
+	if err != nil {
+		t.Fatalf("new watcher: %v", err)
+	}
+
+	var got []string
+	err = watcher.Watch(context.Background(), "dep-1", 0, func(update *DeploymentUpdate) error {
+		got = append(got, update.Status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	if strings.Join(got, ",") != "building,deploying,complete" {
+		t.Errorf("delivered %v, want building,deploying,complete", got)
+	}
+	if watcher.Transport() != TransportLongPoll {
+		t.Errorf("watched over %s, want %s", watcher.Transport(), TransportLongPoll)
+	}
+	if strings.Join(sinces, ",") != "0,2" {
+		t.Errorf("polled with since=%q, want 0 then 2", sinces)
+	}
+}
+
+func TestDeploymentWatcherDoesNotFallBackOnAuth(t *testing.T) {
+	var requests []string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": {"code": "unauthorized", "message": "Invalid token"}}`))
+	}))
+	c.SetAuthToken("expired")
+	watcher, _ := NewDeploymentWatcher(c, TransportAuto, "")
+	err := watcher.Watch(context.Background(), "dep-1", 0, func(*DeploymentUpdate) error { return nil })
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !apiErr.IsAuth() {
+		t.Errorf("watch: err = %v, want the 401", err)
+	}
+	for _, path := range requests {
+		if path != "/api/version" && path != "/ws" {
+			t.Errorf("fell back to %s after the 401", path)
+		}
+	}
+
+	if _, err := NewDeploymentWatcher(c, "carrier-pigeon", ""); err == nil {
+		t.Errorf("NewDeploymentWatcher accepted an unknown transport")
+	}
+}
+
+func TestSSEWatcherResumes(t *testing.T) {
+	var mu sync.Mutex
+	var lastEventIDs []string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/deployments/dep-1/events" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		first := len(lastEventIDs) == 1
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		send := func(seq int64, status string) {
+			data, _ := json.Marshal(DeploymentUpdate{Seq: seq, DeploymentID: "dep-1", Status: status})
+			fmt.Fprintf(w, "id: %d\nevent: update\ndata: %s\n\n", seq, data)
+		}
+		if first {
+			// Two updates, then the stream ends early
+			send(1, "committing")
+			send(2, "building")
+			return
+		}
+		// Replay one update too many: the watcher must not deliver it twice
+		fmt.Fprint(w, ": keepalive\n\n")
+		send(2, "building")
+		send(3, "complete")
+	}))
+	watcher := newSSEWatcher(c)
+	watcher.reconnect = RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	var got []string
+	err := watcher.Watch(context.Background(), "dep-1", 0, func(update *DeploymentUpdate) error {
+		got = append(got, update.Status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	if strings.Join(got, ",") != "committing,building,complete" {
+		t.Errorf("delivered %v, want each of committing,building,complete once", got)
+	}
+	if strings.Join(lastEventIDs, ",") != ",2" {
+		t.Errorf("connected with Last-Event-ID %q, want none then 2", lastEventIDs)
+	}
+}
+
+func TestDeploymentWatcherStageTimeout(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		building := DeploymentUpdate{Seq: 1, DeploymentID: "dep-1", Status: "building"}
+		switch r.URL.Path {
+		case "/ws":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			// Alive, but stuck building
+			conn.WriteJSON(building)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		case "/api/deployments/dep-1/updates":
+			updates := []DeploymentUpdate{}
+			if r.URL.Query().Get("since") == "0" {
+				updates = append(updates, building)
+			}
+			json.NewEncoder(w).Encode(DeploymentUpdateList{Updates: updates})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	// The stage is timed the same way whatever the transport
+	for _, transport := range []string{TransportWebSocket, TransportLongPoll} {
+		t.Run(transport, func(t *testing.T) {
+			c := NewClient()
+			c.SetAuthToken("test-token")
+			watcher := &autoWatcher{api: c, transports: []string{transport}, stageTimeout: 100 * time.Millisecond}
+
+			err := watcher.Watch(context.Background(), "dep-1", 0, func(*DeploymentUpdate) error { return nil })
+			if err == nil || !strings.Contains(err.Error(), "no progress in building") {
+				t.Errorf("watch: err = %v, want a stage timeout", err)
+			}
+		})
+	}
+}
+
+func TestLongPollWatcherStopsWhenFinished(t *testing.T) {
+	var polls atomic.Int32
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/deployments/dep-1/updates" {
+			http.NotFound(w, r)
+			return
+		}
+		polls.Add(1)
+		// The deployment ended with update 3, which the client already has
+		json.NewEncoder(w).Encode(DeploymentUpdateList{Updates: []DeploymentUpdate{}, Finished: true})
+	}))
+	watcher := &longPollWatcher{api: c}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := watcher.Watch(ctx, "dep-1", 3, func(update *DeploymentUpdate) error {
+		t.Errorf("delivered update %d after the final one", update.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("watch: %v", err)
+	}
+	if n := polls.Load(); n != 1 {
+		t.Errorf("polled %d times, want 1", n)
+	}
+}
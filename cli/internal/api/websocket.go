@@ -1,27 +1,121 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type DeploymentUpdate struct {
-	DeploymentID string            `json:"deploymentId"`
-	ProjectID    string            `json:"projectId"`
-	CommitHash   string            `json:"commitHash"`
-	Status       string            `json:"status"`
-	Namespace    string            `json:"namespace,omitempty"`
-	PVC          string            `json:"pvc,omitempty"`
-	URL          string            `json:"url,omitempty"`
-	Logs         []string          `json:"logs"`
+	// Seq numbers the deployment's updates from 1, so a stream can resume
+	// after the last one received
+	Seq          int64    `json:"seq,omitempty"`
+	DeploymentID string   `json:"deploymentId"`
+	ProjectID    string   `json:"projectId"`
+	CommitHash   string   `json:"commitHash"`
+	Status       string   `json:"status"`
+	Namespace    string   `json:"namespace,omitempty"`
+	PVC          string   `json:"pvc,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Logs         []string `json:"logs"`
 }
 
 type WebSocketClient struct {
-	baseURL string
-	conn    *websocket.Conn
+	baseURL      string
+	serverURL    string
+	api          *Client
+	conn         wsConn
+	deploymentID string
+	reconnect    RetryPolicy
+	heartbeat    HeartbeatPolicy
+
+	// writeMu serializes control frames from the heartbeat and ping handler
+	writeMu sync.Mutex
+
+	// lastSeq is the sequence number of the last update delivered;
+	// unsequenced is set if the server sent updates without one, in which
+	// case the stream can't be resumed without repeating them
+	lastSeq     int64
+	unsequenced bool
+}
+
+// DefaultReconnectPolicy reconnects a dropped update stream up to 5 times
+// with roughly 1s, 2s, 4s, 8s, 15s delays
+var DefaultReconnectPolicy = RetryPolicy{
+	MaxRetries: 5,
+	BaseDelay:  time.Second,
+	MaxDelay:   15 * time.Second,
+}
+
+// HeartbeatPolicy controls how a stream tells a quiet deployment from a
+// dead connection
+type HeartbeatPolicy struct {
+	// PingInterval is how often the client pings the server (0 disables
+	// pings, and with them the liveness check)
+	PingInterval time.Duration
+
+	// PongTimeout is how long after a ping is due the server has to answer,
+	// before the connection is given up and resumed
+	PongTimeout time.Duration
+}
+
+// DefaultHeartbeatPolicy pings every 20s and reconnects after 30s without
+// any frame from the server
+var DefaultHeartbeatPolicy = HeartbeatPolicy{
+	PingInterval: 20 * time.Second,
+	PongTimeout:  10 * time.Second,
+}
+
+// Environment variables overriding DefaultHeartbeatPolicy, in seconds
+const (
+	PingIntervalEnv = "BACKEND_IM_WS_PING_INTERVAL"
+	PongTimeoutEnv  = "BACKEND_IM_WS_PONG_TIMEOUT"
+)
+
+// wsWriteWait bounds how long sending a control frame may block
+const wsWriteWait = 5 * time.Second
+
+func heartbeatPolicyFromEnv() HeartbeatPolicy {
+	policy := DefaultHeartbeatPolicy
+	for env, field := range map[string]*time.Duration{
+		PingIntervalEnv: &policy.PingInterval,
+		PongTimeoutEnv:  &policy.PongTimeout,
+	} {
+		*field = durationFromEnv(env, *field)
+	}
+	return policy
+}
+
+// durationFromEnv reads a number of seconds from env, or returns def if it
+// isn't set to one
+func durationFromEnv(env string, def time.Duration) time.Duration {
+	if n, err := strconv.Atoi(os.Getenv(env)); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	return def
+}
+
+// wsConn is the part of *websocket.Conn the client uses, so a Fixture can
+// stand in for the connection
+type wsConn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	SetPingHandler(h func(appData string) error)
+	Close() error
 }
 
 func NewWebSocketClient(baseURL string) *WebSocketClient {
@@ -29,10 +123,62 @@ func NewWebSocketClient(baseURL string) *WebSocketClient {
 		baseURL = DefaultAPIURL
 	}
 	// Store base URL as-is - we'll convert to WebSocket in Connect()
-	return &WebSocketClient{baseURL: baseURL}
+	return &WebSocketClient{baseURL: baseURL, reconnect: DefaultReconnectPolicy, heartbeat: heartbeatPolicyFromEnv()}
+}
+
+// SetHeartbeatPolicy replaces the client's ping and timeout settings
+func (c *WebSocketClient) SetHeartbeatPolicy(policy HeartbeatPolicy) {
+	c.heartbeat = policy
+}
+
+// SetReconnectPolicy replaces the policy used when the stream drops
+// (MaxRetries 0 disables reconnecting)
+func (c *WebSocketClient) SetReconnectPolicy(policy RetryPolicy) {
+	c.reconnect = policy
+}
+
+// Authenticate makes Connect authenticate its handshakes with apiClient's
+// credentials: a short-lived ticket for the deployment when the server
+// issues them, otherwise the bearer token
+func (c *WebSocketClient) Authenticate(apiClient *Client) {
+	c.api = apiClient
+}
+
+// UseServerURL makes Connect dial the websocketUrl the API returned for the
+// deployment, after the host rewrite rules, and fall back to the base URL
+// when that host can't be reached
+func (c *WebSocketClient) UseServerURL(websocketURL string) {
+	c.serverURL = websocketURL
+}
+
+// Connect opens the deployment's update stream. After updates were
+// received, it asks the server to resume after the last one.
+func (c *WebSocketClient) Connect(ctx context.Context, deploymentID string) error {
+	urls, err := c.streamURLs(deploymentID)
+	if err != nil {
+		return err
+	}
+
+	// Fall back to the next URL only while the hosts can't be reached: a
+	// server that answered and refused would refuse on any URL
+	for i := 0; ; i++ {
+		conn, reached, err := c.dial(ctx, urls[i], deploymentID)
+		if err == nil {
+			c.conn = conn
+			c.deploymentID = deploymentID
+			return nil
+		}
+		if reached || ctx.Err() != nil || i == len(urls)-1 {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  %v - falling back to %s\n", err, redactURL(urls[i+1]))
+	}
 }
 
-func (c *WebSocketClient) Connect(deploymentID string) error {
+// streamURLs returns the URLs to try for the deployment's stream: the
+// server-provided one (rewritten) if any, then the one derived from the
+// base URL. The query resumes after lastSeq.
+func (c *WebSocketClient) streamURLs(deploymentID string) ([]*neturl.URL, error) {
 	// Convert HTTP URL to WebSocket URL
 	wsBase := c.baseURL
 	if strings.HasPrefix(wsBase, "http://") {
@@ -43,77 +189,389 @@ func (c *WebSocketClient) Connect(deploymentID string) error {
 		// No protocol specified, assume ws://
 		wsBase = "ws://" + wsBase
 	}
-	
-	// Build full WebSocket URL
-	url := fmt.Sprintf("%s/ws?deploymentId=%s", wsBase, deploymentID)
-	
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 10 * time.Second,
+	raw := []string{wsBase + "/ws"}
+
+	if c.serverURL != "" {
+		raw = append([]string{c.serverURL}, raw...)
 	}
 
-	conn, _, err := dialer.Dial(url, nil)
+	rewrites, err := resolveHostRewrites()
 	if err != nil {
-		return fmt.Errorf("failed to connect to WebSocket at %s: %w", url, err)
+		return nil, err
 	}
 
-	c.conn = conn
-	return nil
+	var urls []*neturl.URL
+	for _, r := range raw {
+		u, err := neturl.Parse(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WebSocket URL %q: %w", r, err)
+		}
+		if r == c.serverURL {
+			for _, rewrite := range rewrites {
+				if rewrite.apply(u) {
+					break
+				}
+			}
+		}
+
+		query := u.Query()
+		query.Set("deploymentId", deploymentID)
+		if c.lastSeq > 0 {
+			query.Set("since", strconv.FormatInt(c.lastSeq, 10))
+		}
+		u.RawQuery = query.Encode()
+
+		if len(urls) == 0 || u.String() != urls[0].String() {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+// dial opens the WebSocket at u, authenticated if Authenticate was called.
+// reached reports whether the server answered the handshake, in which case
+// another URL won't fare better.
+func (c *WebSocketClient) dial(ctx context.Context, u *neturl.URL, deploymentID string) (conn wsConn, reached bool, err error) {
+	header := http.Header{}
+	if c.api != nil {
+		if server := c.api.ServerInfo(ctx); server != nil && server.Has(CapabilityWebSocketTickets) {
+			ticket, err := c.api.CreateWebSocketTicket(ctx, deploymentID)
+			if err != nil {
+				return nil, true, fmt.Errorf("failed to authenticate the WebSocket: %w", err)
+			}
+			ticketURL := *u
+			query := ticketURL.Query()
+			query.Set("ticket", ticket.Ticket)
+			ticketURL.RawQuery = query.Encode()
+			u = &ticketURL
+		} else {
+			token, err := c.api.accessToken(ctx)
+			if err != nil {
+				return nil, true, err
+			}
+			if token != "" {
+				header.Set("Authorization", "Bearer "+token)
+			}
+		}
+	}
+
+	dial := func() (wsConn, error) {
+		settings, err := loadNetwork()
+		if err != nil {
+			reached = true
+			return nil, err
+		}
+		dialer := websocket.Dialer{
+			Proxy:            settings.proxy,
+			TLSClientConfig:  settings.tls.Clone(), // net/http adds HTTP/2 to the config it is given
+			HandshakeTimeout: 10 * time.Second,
+		}
+
+		tracef("WS → dial %s", redactURL(u))
+		conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+		if err != nil {
+			tracef("WS ✗ dial failed: %v", err)
+			if resp != nil {
+				// The server turned the handshake down, e.g. 401 or 404
+				reached = true
+				body, _ := io.ReadAll(resp.Body)
+				return nil, fmt.Errorf("WebSocket handshake at %s rejected: %w", redactURL(u), parseError(resp, body))
+			}
+			return nil, fmt.Errorf("failed to connect to WebSocket at %s: %w", redactURL(u), err)
+		}
+		tracef("WS ← %s", resp.Status)
+		return conn, nil
+	}
+
+	if fixture, ok := transportOverride.(*Fixture); ok {
+		conn, err = fixture.dialWebSocket(u.String(), dial)
+		return conn, true, err
+	}
+	conn, err = dial()
+	return conn, reached, err
 }
 
-func (c *WebSocketClient) StreamUpdates(callback func(*DeploymentUpdate) error) error {
+// WebSocketTicket is a short-lived, single-use credential for one
+// deployment's stream, so the long-lived token never goes in a URL
+type WebSocketTicket struct {
+	Ticket       string    `json:"ticket"`
+	DeploymentID string    `json:"deploymentId"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+func (c *Client) CreateWebSocketTicket(ctx context.Context, deploymentID string) (*WebSocketTicket, error) {
+	var response WebSocketTicket
+	err := c.post(ctx, "/api/ws/tickets", map[string]string{"deploymentId": deploymentID}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// HostRewrite maps the host of a server-provided WebSocket URL to one
+// reachable from here, e.g. localhost:8080=mock-api:8080 inside Docker.
+// A host without a port matches any port and keeps it.
+type HostRewrite struct {
+	From string
+	To   string
+}
+
+// ParseHostRewrite parses a rule written as from=to
+func ParseHostRewrite(rule string) (HostRewrite, error) {
+	from, to, found := strings.Cut(rule, "=")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !found || from == "" || to == "" || strings.ContainsAny(from+to, "/?#") {
+		return HostRewrite{}, fmt.Errorf("invalid WebSocket host rewrite %q - use from=to, e.g. localhost:8080=mock-api:8080", rule)
+	}
+	return HostRewrite{From: from, To: to}, nil
+}
+
+func (r HostRewrite) apply(u *neturl.URL) bool {
+	switch {
+	case strings.EqualFold(u.Host, r.From):
+		u.Host = r.To
+	case strings.EqualFold(u.Hostname(), r.From):
+		if _, _, err := net.SplitHostPort(r.To); err != nil && u.Port() != "" {
+			u.Host = net.JoinHostPort(r.To, u.Port())
+		} else {
+			u.Host = r.To
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (r HostRewrite) String() string {
+	return r.From + "=" + r.To
+}
+
+// WSRewriteEnv sets host rewrite rules, comma-separated, when --ws-rewrite
+// isn't given
+const WSRewriteEnv = "BACKEND_IM_WS_REWRITE"
+
+// resolveHostRewrites parses the rewrite rules of the flags and the active
+// context, the flags' first
+func resolveHostRewrites() ([]HostRewrite, error) {
+	var rewrites []HostRewrite
+	for _, rule := range resolveNetworkConfig().WebSocketRewrites {
+		rewrite, err := ParseHostRewrite(rule)
+		if err != nil {
+			return nil, err
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	return rewrites, nil
+}
+
+// connectionLostError reports a stream that dropped before the deployment
+// finished and can be resumed
+type connectionLostError struct {
+	// stream is what was lost, the WebSocket connection if empty
+	stream string
+	cause  string
+}
+
+func (e *connectionLostError) Error() string {
+	if e.stream != "" {
+		return e.stream + " lost: " + e.cause
+	}
+	return "WebSocket connection lost: " + e.cause
+}
+
+// StreamUpdates reads updates until the deployment finishes, the server
+// closes the stream or ctx is cancelled. If the connection drops it
+// reconnects with backoff and resumes after the last update received, so
+// updates are neither lost nor repeated.
+func (c *WebSocketClient) StreamUpdates(ctx context.Context, callback func(*DeploymentUpdate) error) error {
 	if c.conn == nil {
 		return fmt.Errorf("not connected - call Connect() first")
 	}
 
-	defer c.conn.Close()
+	defer c.Close()
+	return followStream(ctx, c, c.reconnect, callback)
+}
+
+// connect reopens the stream for followStream; Connect asks the server to
+// replay the updates after lastSeq
+func (c *WebSocketClient) connect(ctx context.Context) error {
+	return c.Connect(ctx, c.deploymentID)
+}
+
+func (c *WebSocketClient) resumePoint() (int64, bool) {
+	return c.lastSeq, c.unsequenced
+}
+
+// read reads updates from the current connection while a heartbeat
+// goroutine pings the server. A dropped or unresponsive connection is
+// reported as a *connectionLostError.
+func (c *WebSocketClient) read(ctx context.Context, callback func(*DeploymentUpdate) error) error {
+	conn := c.conn
+	defer conn.Close()
 
-	// Set read deadline to detect connection issues
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.heartbeatLoop(ctx, conn, stop)
+
+	// Any frame from the server, pongs included, proves the connection alive
+	c.extendReadDeadline(conn)
+	conn.SetPongHandler(func(string) error {
+		tracef("WS ← pong frame")
+		c.extendReadDeadline(conn)
 		return nil
 	})
+	conn.SetPingHandler(func(data string) error {
+		tracef("WS ← ping frame")
+		c.extendReadDeadline(conn)
+		err := c.writeControl(conn, websocket.PongMessage, []byte(data))
+		if err == nil {
+			tracef("WS → pong frame")
+		}
+		return err
+	})
 
 	for {
 		var update DeploymentUpdate
-		err := c.conn.ReadJSON(&update)
+		err := c.readFrame(&update)
 		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			// Check if it's a close error
 			if closeErr, ok := err.(*websocket.CloseError); ok {
-				// Normal closure or going away - server closed connection normally
-				if closeErr.Code == websocket.CloseNormalClosure || closeErr.Code == websocket.CloseGoingAway {
-					return nil // Return nil to indicate normal completion
+				switch closeErr.Code {
+				case websocket.CloseNormalClosure:
+					// Server closed connection normally
+					return nil
+				case websocket.CloseAbnormalClosure:
+					// Dropped without a close frame
+					return &connectionLostError{cause: "connection dropped"}
+				case websocket.CloseGoingAway, websocket.CloseInternalServerErr,
+					websocket.CloseServiceRestart, websocket.CloseTryAgainLater:
+					// Server restarting or connection dropped - worth resuming
+					return &connectionLostError{cause: fmt.Sprintf("closed with code %d", closeErr.Code)}
 				}
 				// Unexpected close error
 				return fmt.Errorf("WebSocket closed with code %d: %w", closeErr.Code, err)
 			}
-			// Handle read deadline exceeded (timeout)
+			// Handle read deadline exceeded: no pong or update in time
 			if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
-				return fmt.Errorf("WebSocket read timeout - connection may be stale")
+				return &connectionLostError{cause: fmt.Sprintf("no response for %s - connection may be stale", c.liveness())}
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return &connectionLostError{cause: err.Error()}
 			}
-			// Other errors (network errors, JSON decode errors, etc.)
+			// Other errors (JSON decode errors, etc.)
 			return fmt.Errorf("failed to read WebSocket message: %w", err)
 		}
+		c.extendReadDeadline(conn)
+
+		// Skip updates replayed after a reconnect that were already delivered
+		if update.Seq == 0 {
+			c.unsequenced = true
+		} else if update.Seq <= c.lastSeq {
+			tracef("WS skipping update %d, already delivered", update.Seq)
+			continue
+		}
 
 		// Call callback with update
 		if err := callback(&update); err != nil {
 			return err
 		}
+		if update.Seq > c.lastSeq {
+			c.lastSeq = update.Seq
+		}
 
 		// Exit if deployment is complete or failed
 		if update.Status == "complete" || update.Status == "failed" {
 			return nil
 		}
+	}
+}
+
+// heartbeatLoop pings the server every PingInterval until stop is closed.
+// It closes the connection to unblock the pending read when ctx is
+// cancelled or a ping can't be sent.
+func (c *WebSocketClient) heartbeatLoop(ctx context.Context, conn wsConn, stop <-chan struct{}) {
+	var ticks <-chan time.Time
+	if c.heartbeat.PingInterval > 0 {
+		ticker := time.NewTicker(c.heartbeat.PingInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
 
-		// Reset read deadline
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	for {
+		select {
+		case <-ctx.Done():
+			tracef("WS → close frame (%d)", websocket.CloseNormalClosure)
+			c.writeControl(conn, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			conn.Close()
+			return
+		case <-ticks:
+			if err := c.writeControl(conn, websocket.PingMessage, nil); err != nil {
+				tracef("WS ✗ ping failed: %v", err)
+				conn.Close()
+				return
+			}
+			tracef("WS → ping frame")
+		case <-stop:
+			return
+		}
 	}
 }
 
+// writeControl sends a control frame, one writer at a time, giving up
+// after wsWriteWait
+func (c *WebSocketClient) writeControl(conn wsConn, messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return conn.WriteControl(messageType, data, time.Now().Add(wsWriteWait))
+}
+
+// liveness is how long the connection may stay silent: a ping interval
+// plus the time the server has to answer it. Without pings there is no limit.
+func (c *WebSocketClient) liveness() time.Duration {
+	if c.heartbeat.PingInterval <= 0 {
+		return 0
+	}
+	return c.heartbeat.PingInterval + c.heartbeat.PongTimeout
+}
+
+func (c *WebSocketClient) extendReadDeadline(conn wsConn) {
+	var deadline time.Time
+	if liveness := c.liveness(); liveness > 0 {
+		deadline = time.Now().Add(liveness)
+	}
+	conn.SetReadDeadline(deadline)
+}
+
+// readFrame reads the next data frame and decodes it as JSON into v
+func (c *WebSocketClient) readFrame(v interface{}) error {
+	messageType, data, err := c.conn.ReadMessage()
+	if err != nil {
+		if closeErr, ok := err.(*websocket.CloseError); ok {
+			tracef("WS ← close frame (%d %s)", closeErr.Code, closeErr.Text)
+		} else {
+			tracef("WS ✗ read failed: %v", err)
+		}
+		return err
+	}
+
+	if trace != nil {
+		kind := "text"
+		if messageType == websocket.BinaryMessage {
+			kind = "binary"
+		}
+		tracef("WS ← %s frame (%d bytes): %s", kind, len(data), traceBody("", "", data))
+	}
+
+	return json.Unmarshal(data, v)
+}
+
 func (c *WebSocketClient) Close() error {
 	if c.conn != nil {
 		return c.conn.Close()
 	}
 	return nil
 }
-
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/testenv"
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamUpdatesResumesAfterDisconnect(t *testing.T) {
+	statuses := []string{"committing", "creating_namespace", "creating_pvc", "deploying", "complete"}
+
+	var mu sync.Mutex
+	var sinces []string
+	upgrader := websocket.Upgrader{}
+	server := testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sinces = append(sinces, r.URL.Query().Get("since"))
+		first := len(sinces) == 1
+		mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if first {
+			// Two updates, then drop the connection without a close frame
+			for i, status := range statuses[:2] {
+				conn.WriteJSON(DeploymentUpdate{Seq: int64(i + 1), DeploymentID: "dep-1", Status: status})
+			}
+			return
+		}
+		// Replay one update too many: the client must not deliver it twice
+		for i := 1; i < len(statuses); i++ {
+			conn.WriteJSON(DeploymentUpdate{Seq: int64(i + 1), DeploymentID: "dep-1", Status: statuses[i]})
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+
+	ws := NewWebSocketClient(server.URL)
+	ws.SetReconnectPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	if err := ws.Connect(context.Background(), "dep-1"); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	var got []string
+	err := ws.StreamUpdates(context.Background(), func(update *DeploymentUpdate) error {
+		got = append(got, update.Status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	if strings.Join(got, ",") != strings.Join(statuses, ",") {
+		t.Errorf("delivered %v, want each of %v once", got, statuses)
+	}
+	if strings.Join(sinces, ",") != ",2" {
+		t.Errorf("connected with since=%q, want none then 2", sinces)
+	}
+}
+
+func TestStreamUpdatesGivesUpReconnecting(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var server *httptest.Server
+	server = testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.WriteJSON(DeploymentUpdate{Seq: 1, DeploymentID: "dep-1", Status: "committing"})
+		conn.Close()
+		// Nothing to reconnect to
+		go server.CloseClientConnections()
+		server.Listener.Close()
+	}))
+
+	ws := NewWebSocketClient(server.URL)
+	ws.SetReconnectPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	if err := ws.Connect(context.Background(), "dep-1"); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	err := ws.StreamUpdates(context.Background(), func(*DeploymentUpdate) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "gave up after 2 reconnect attempts") {
+		t.Errorf("stream: err = %v, want giving up after 2 attempts", err)
+	}
+}
+
+func TestStreamUpdatesReconnectsWhenPingsGoUnanswered(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	upgrader := websocket.Upgrader{}
+	server := testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connections++
+		first := connections == 1
+		mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if first {
+			// One update, then silence: not reading means pings go unanswered
+			conn.WriteJSON(DeploymentUpdate{Seq: 1, DeploymentID: "dep-1", Status: "building"})
+			time.Sleep(300 * time.Millisecond)
+			return
+		}
+		conn.WriteJSON(DeploymentUpdate{Seq: 2, DeploymentID: "dep-1", Status: "complete"})
+	}))
+
+	ws := NewWebSocketClient(server.URL)
+	ws.SetReconnectPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	ws.SetHeartbeatPolicy(HeartbeatPolicy{PingInterval: 20 * time.Millisecond, PongTimeout: 20 * time.Millisecond})
+	if err := ws.Connect(context.Background(), "dep-1"); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	start := time.Now()
+	var got []string
+	err := ws.StreamUpdates(context.Background(), func(update *DeploymentUpdate) error {
+		got = append(got, update.Status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if strings.Join(got, ",") != "building,complete" {
+		t.Errorf("delivered %v, want building,complete", got)
+	}
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("took %s to notice the stalled connection", elapsed)
+	}
+}
+
+func TestHostRewrite(t *testing.T) {
+	tests := []struct {
+		rule, url, want string
+	}{
+		{"localhost:8080=mock-api:8080", "ws://localhost:8080/ws", "ws://mock-api:8080/ws"},
+		{"localhost:8080=mock-api:8080", "ws://localhost:9090/ws", "ws://localhost:9090/ws"},
+		{"localhost=mock-api", "ws://LOCALHOST:8080/ws", "ws://mock-api:8080/ws"},
+		{"localhost=mock-api:80", "ws://localhost:8080/ws", "ws://mock-api:80/ws"},
+		{"internal.example=api.example.com", "wss://internal.example/ws", "wss://api.example.com/ws"},
+	}
+	for _, tt := range tests {
+		rewrite, err := ParseHostRewrite(tt.rule)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.rule, err)
+		}
+		u, _ := neturl.Parse(tt.url)
+		rewrite.apply(u)
+		if u.String() != tt.want {
+			t.Errorf("%s applied to %s = %s, want %s", tt.rule, tt.url, u, tt.want)
+		}
+	}
+
+	for _, rule := range []string{"localhost", "=mock-api", "localhost=", "localhost=http://mock-api"} {
+		if _, err := ParseHostRewrite(rule); err == nil {
+			t.Errorf("ParseHostRewrite(%q) accepted an invalid rule", rule)
+		}
+	}
+}
+
+func TestConnectUsesServerURL(t *testing.T) {
+	t.Cleanup(func() { SetNetworkOverride(NetworkConfig{}) })
+
+	var mu sync.Mutex
+	var authorization []string
+	upgrader := websocket.Upgrader{}
+	// No version handshake, so no tickets: the bearer token is sent
+	server := testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		mu.Unlock()
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	}))
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	apiClient := NewClient()
+	apiClient.SetAuthToken("secret-bearer")
+
+	// The advertised host only resolves inside the cluster: a rule maps it
+	SetNetworkOverride(NetworkConfig{WebSocketRewrites: []string{"backend.internal=127.0.0.1"}})
+	ws := NewWebSocketClient("http://unused.invalid")
+	ws.Authenticate(apiClient)
+	ws.UseServerURL("ws://backend.internal:" + port + "/ws?deploymentId=dep-1")
+	if err := ws.Connect(context.Background(), "dep-1"); err != nil {
+		t.Fatalf("connect with a rewrite rule: %v", err)
+	}
+	ws.Close()
+
+	// Without the rule it can't be reached, and the base URL is used instead
+	SetNetworkOverride(NetworkConfig{})
+	ws = NewWebSocketClient(server.URL)
+	ws.Authenticate(apiClient)
+	ws.UseServerURL("ws://127.0.0.1:1/ws?deploymentId=dep-1")
+	if err := ws.Connect(context.Background(), "dep-1"); err != nil {
+		t.Fatalf("connect with fallback: %v", err)
+	}
+	ws.Close()
+
+	if strings.Join(authorization, ",") != "Bearer secret-bearer,Bearer secret-bearer" {
+		t.Errorf("handshakes sent Authorization %q, want the bearer token", authorization)
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+const configFile = "config.json"
+
+// DefaultContext is the profile used when none has been selected. Its
+// credentials live directly in ~/.backend-im for compatibility with older
+// CLI versions; other contexts use ~/.backend-im/contexts/<name>/.
+const DefaultContext = "default"
+
+// ContextEnv selects the active context, overriding config.json
+const ContextEnv = "BACKEND_IM_CONTEXT"
+
+const contextsDir = "contexts"
+
+var contextNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// contextOverride is set from the global --context flag
+var contextOverride string
+
+// Config holds CLI settings kept in ~/.backend-im/config.json
+type Config struct {
+	CredentialStore string              `json:"credential_store,omitempty"`
+	CurrentContext  string              `json:"current_context,omitempty"`
+	Contexts        map[string]*Context `json:"contexts,omitempty"`
+}
+
+// Context is a named profile pointing at one Backend.im API, with the
+// network settings needed to reach it
+type Context struct {
+	APIURL             string `json:"api_url,omitempty"`
+	Proxy              string `json:"proxy,omitempty"`
+	CACert             string `json:"ca_cert,omitempty"`
+	ClientCert         string `json:"client_cert,omitempty"`
+	ClientKey          string `json:"client_key,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	// WSRewrites are host rewrite rules (from=to) for the WebSocket URLs
+	// the server hands out
+	WSRewrites []string `json:"ws_rewrites,omitempty"`
+}
+
+// LoadConfig reads config.json, returning defaults if it doesn't exist yet
+func LoadConfig() (*Config, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(configPath, configFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return &config, nil
+}
+
+// UpdateConfig loads config.json, applies update and writes it back, holding
+// the config lock so parallel invocations don't lose each other's changes
+func UpdateConfig(update func(config *Config) error) error {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(configPath, 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	unlock, err := lockFile(filepath.Join(configPath, configFile+".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := update(config); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	return WriteFileAtomic(filepath.Join(configPath, configFile), data)
+}
+
+// Context returns the named context. The default context always exists.
+func (c *Config) Context(name string) (*Context, bool) {
+	if ctx, ok := c.Contexts[name]; ok {
+		return ctx, true
+	}
+	if name == DefaultContext {
+		return &Context{}, true
+	}
+	return nil, false
+}
+
+// ContextNames lists all contexts, including the implicit default, sorted
+func (c *Config) ContextNames() []string {
+	names := []string{DefaultContext}
+	for name := range c.Contexts {
+		if name != DefaultContext {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// SetContextOverride selects the active context for this process (--context)
+func SetContextOverride(name string) {
+	contextOverride = name
+}
+
+// CurrentContext resolves the active context from --context, then
+// BACKEND_IM_CONTEXT, then config.json
+func CurrentContext() (string, *Context, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := contextOverride
+	if name == "" {
+		name = os.Getenv(ContextEnv)
+	}
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if name == "" {
+		name = DefaultContext
+	}
+
+	ctx, ok := config.Context(name)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown context %q - run 'backend-im context list' to see available contexts", name)
+	}
+	return name, ctx, nil
+}
+
+// ValidateContextName checks that name is usable as a context (and directory) name
+func ValidateContextName(name string) error {
+	if !contextNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid context name %q - use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// ContextDir is the directory holding the named context's credentials
+func ContextDir(name string) (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	if name == DefaultContext {
+		return configPath, nil
+	}
+	return filepath.Join(configPath, contextsDir, name), nil
+}
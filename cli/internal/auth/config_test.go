@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+func TestCurrentContext(t *testing.T) {
+	contexts := map[string]string{
+		"local":   "http://localhost:8080",
+		"staging": "https://staging.example",
+		"prod":    "https://api.example",
+	}
+	tests := []struct {
+		name     string
+		override string // --context
+		env      string // BACKEND_IM_CONTEXT
+		current  string // current_context in config.json
+		want     string
+		err      string
+	}{
+		{name: "nothing selected", want: DefaultContext},
+		{name: "config", current: "staging", want: "staging"},
+		{name: "env over config", env: "prod", current: "staging", want: "prod"},
+		{name: "flag over env and config", override: "local", env: "prod", current: "staging", want: "local"},
+		{name: "flag over config", override: "local", current: "staging", want: "local"},
+		{name: "default by name", env: DefaultContext, current: "staging", want: DefaultContext},
+		{name: "unknown context", env: "nowhere", current: "staging", err: `unknown context "nowhere"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testenv.Isolate(t)
+			err := UpdateConfig(func(config *Config) error {
+				config.CurrentContext = tt.current
+				config.Contexts = make(map[string]*Context)
+				for name, apiURL := range contexts {
+					config.Contexts[name] = &Context{APIURL: apiURL}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(ContextEnv, tt.env)
+			SetContextOverride(tt.override)
+			defer SetContextOverride("")
+
+			name, ctx, err := CurrentContext()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("CurrentContext: err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CurrentContext: %v", err)
+			}
+			if name != tt.want || ctx.APIURL != contexts[tt.want] {
+				t.Errorf("CurrentContext = %q with API URL %q, want %q", name, ctx.APIURL, tt.want)
+			}
+		})
+	}
+}
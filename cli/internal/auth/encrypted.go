@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const encryptedTokenFile = "token.enc"
+
+// scrypt parameters for deriving the file key from the passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+
+	// Limits on the parameters read from token.enc, so a damaged or tampered
+	// file can't make key derivation use unbounded memory or time. scrypt
+	// needs 128*N*r bytes.
+	scryptMaxN      = 1 << 20
+	scryptMaxMemory = 1 << 30
+	scryptMaxP      = 16
+)
+
+// PassphraseEnv supplies the encrypted store passphrase non-interactively
+const PassphraseEnv = "BACKEND_IM_PASSPHRASE"
+
+// passphrases caches passphrases that decrypted a file (or were confirmed for a
+// new one) by path, so a refresh - load then save - only prompts once
+var passphrases = make(map[string]string)
+
+// encryptedFile is the on-disk format of token.enc
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedFileStore keeps the token in ~/.backend-im/token.enc, encrypted with
+// AES-256-GCM under a key derived from a passphrase with scrypt
+type EncryptedFileStore struct {
+	path string
+}
+
+func (s *EncryptedFileStore) Name() string { return StoreEncrypted }
+
+func (s *EncryptedFileStore) Path() string { return s.path }
+
+// checkScryptParams rejects key derivation parameters outside what this CLI
+// would ever write
+func checkScryptParams(n, r, p int) error {
+	if n < 2 || n > scryptMaxN || n&(n-1) != 0 || r < 1 || p < 1 || p > scryptMaxP || 128*int64(n)*int64(r) > scryptMaxMemory {
+		return fmt.Errorf("invalid key derivation parameters in token file (n=%d, r=%d, p=%d) - the file may be corrupted", n, r, p)
+	}
+	return nil
+}
+
+func (s *EncryptedFileStore) Load() (*Token, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotAuthenticated
+		}
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse token file: %w", err)
+	}
+	if file.Version != 1 || file.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported encrypted token format (version %d, kdf %q)", file.Version, file.KDF)
+	}
+	if err := checkScryptParams(file.N, file.R, file.P); err != nil {
+		return nil, err
+	}
+	if len(file.Salt) == 0 {
+		return nil, fmt.Errorf("token file has no salt - the file may be corrupted")
+	}
+
+	passphrase, err := s.getPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), file.Salt, file.N, file.R, file.P, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	// Open panics on a nonce of the wrong size
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("token file has a %d-byte nonce, want %d - the file may be corrupted", len(file.Nonce), gcm.NonceSize())
+	}
+
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token - wrong passphrase or corrupted file")
+	}
+
+	passphrases[s.path] = passphrase
+
+	var token Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (s *EncryptedFileStore) Save(token *Token) error {
+	_, statErr := os.Stat(s.path)
+	passphrase, err := s.getPassphrase(os.IsNotExist(statErr))
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return fmt.Errorf("failed to derive key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	data, err := json.Marshal(encryptedFile{
+		Version:    1,
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal token file: %w", err)
+	}
+
+	if err := WriteFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	passphrases[s.path] = passphrase
+	return nil
+}
+
+func (s *EncryptedFileStore) Delete() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+	return nil
+}
+
+// getPassphrase reads the passphrase from BACKEND_IM_PASSPHRASE or prompts on
+// the terminal, asking twice when a new file is being created
+func (s *EncryptedFileStore) getPassphrase(confirm bool) (string, error) {
+	if cached, ok := passphrases[s.path]; ok {
+		return cached, nil
+	}
+	if env := os.Getenv(PassphraseEnv); env != "" {
+		return env, nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("encrypted credential store needs a passphrase - set %s", PassphraseEnv)
+	}
+
+	passphrase, err := readPassphrase("🔒 Credential store passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("passphrase must not be empty")
+	}
+
+	if confirm {
+		again, err := readPassphrase("🔒 Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	input, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return strings.TrimSpace(string(input)), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+func TestEncryptedFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), encryptedTokenFile)
+	t.Setenv(PassphraseEnv, "correct horse")
+	defer delete(passphrases, path)
+
+	store := &EncryptedFileStore{path: path}
+	saved := NewToken("access-secret", "Bearer", "refresh-secret", 3600)
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if bytes.Contains(data, []byte("access-secret")) || bytes.Contains(data, []byte("refresh-secret")) {
+		t.Errorf("token.enc holds the token in plaintext: %s", data)
+	}
+
+	// A new process has no cached passphrase and must derive the key again
+	delete(passphrases, path)
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.AccessToken != saved.AccessToken || loaded.RefreshToken != saved.RefreshToken || !loaded.ExpiresAt.Equal(saved.ExpiresAt) {
+		t.Errorf("loaded %+v, saved %+v", loaded, saved)
+	}
+}
+
+func TestEncryptedFileStoreWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), encryptedTokenFile)
+	defer delete(passphrases, path)
+
+	store := &EncryptedFileStore{path: path}
+	t.Setenv(PassphraseEnv, "correct horse")
+	if err := store.Save(NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	delete(passphrases, path)
+	t.Setenv(PassphraseEnv, "battery staple")
+	_, err := store.Load()
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("load with the wrong passphrase: err = %v", err)
+	}
+	if _, cached := passphrases[path]; cached {
+		t.Errorf("the wrong passphrase was cached")
+	}
+}
+
+func TestEncryptedFileStoreRejectsScryptParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), encryptedTokenFile)
+	defer delete(passphrases, path)
+
+	store := &EncryptedFileStore{path: path}
+	t.Setenv(PassphraseEnv, "correct horse")
+	if err := store.Save(NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved encryptedFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range [][3]int{{1 << 40, 8, 1}, {1 << 15, 1 << 20, 1}, {1 << 15, 8, 1 << 20}, {1000, 8, 1}, {0, 8, 1}} {
+		file := saved
+		file.N, file.R, file.P = params[0], params[1], params[2]
+		data, _ := json.Marshal(file)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		delete(passphrases, path)
+		if _, err := store.Load(); err == nil || !strings.Contains(err.Error(), "invalid key derivation parameters") {
+			t.Errorf("n=%d r=%d p=%d: err = %v", params[0], params[1], params[2], err)
+		}
+	}
+}
+
+func TestEncryptedFileStoreRejectsBadSaltAndNonce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), encryptedTokenFile)
+	defer delete(passphrases, path)
+
+	store := &EncryptedFileStore{path: path}
+	t.Setenv(PassphraseEnv, "correct horse")
+	if err := store.Save(NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved encryptedFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(*encryptedFile){
+		"no salt":     func(f *encryptedFile) { f.Salt = nil },
+		"no nonce":    func(f *encryptedFile) { f.Nonce = nil },
+		"short nonce": func(f *encryptedFile) { f.Nonce = f.Nonce[:4] },
+		"long nonce":  func(f *encryptedFile) { f.Nonce = append(f.Nonce, 0) },
+	}
+	for name, corrupt := range tests {
+		file := saved
+		file.Nonce = append([]byte(nil), saved.Nonce...)
+		corrupt(&file)
+		data, _ := json.Marshal(file)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		delete(passphrases, path)
+		if _, err := store.Load(); err == nil || !strings.Contains(err.Error(), "may be corrupted") {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
+
+func TestUseStoreMigratesToken(t *testing.T) {
+	testenv.Isolate(t)
+	t.Setenv(PassphraseEnv, "correct horse")
+
+	if err := SaveToken(NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	plain, err := ActiveStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := UseStore(StoreEncrypted)
+	if err != nil {
+		t.Fatalf("use encrypted store: %v", err)
+	}
+	defer delete(passphrases, store.Path())
+
+	if _, err := os.Stat(plain.Path()); !os.IsNotExist(err) {
+		t.Errorf("plaintext %s still exists after the switch", filepath.Base(plain.Path()))
+	}
+	token, err := LoadToken()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if token.AccessToken != "access" {
+		t.Errorf("access token = %q after the switch", token.AccessToken)
+	}
+}
+
+func TestUseStoreWaitsForTokenLock(t *testing.T) {
+	testenv.Isolate(t)
+	t.Setenv(PassphraseEnv, "correct horse")
+
+	if err := SaveToken(NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	plain, err := ActiveStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the lock as a refresh in another process would
+	unlock, err := lockToken()
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	switched := make(chan error, 1)
+	go func() {
+		store, err := UseStore(StoreEncrypted)
+		if err == nil {
+			t.Cleanup(func() { delete(passphrases, store.Path()) })
+		}
+		switched <- err
+	}()
+
+	select {
+	case err := <-switched:
+		t.Fatalf("UseStore returned %v while the token was locked", err)
+	case <-time.After(3 * lockRetryInterval):
+	}
+
+	// The refresh rotates the token in the store it started with
+	if err := plain.Save(NewToken("access-2", "Bearer", "refresh-2", 3600)); err != nil {
+		t.Fatalf("save rotated token: %v", err)
+	}
+	unlock()
+
+	if err := <-switched; err != nil {
+		t.Fatalf("use encrypted store: %v", err)
+	}
+	token, err := LoadToken()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if token.RefreshToken != "refresh-2" {
+		t.Errorf("refresh token = %q after the switch, want the rotated one", token.RefreshToken)
+	}
+}
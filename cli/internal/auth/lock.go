@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockRetryInterval = 50 * time.Millisecond
+	lockTimeout       = 30 * time.Second
+	// A lock file older than this is assumed to belong to a crashed process
+	lockStaleAfter = 2 * time.Minute
+)
+
+// lockFile acquires an exclusive lock by creating path with O_EXCL. It waits
+// for other holders and returns a function that releases the lock.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > lockStaleAfter {
+			removeStaleLock(path, info)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s - remove it if no other backend-im process is running", path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// removeStaleLock removes the lock file at path if it is still the stale one
+// described by stale. Another waiter may have taken the stale lock over and
+// created a fresh one since it was checked, so the file is first renamed
+// aside, which only one waiter can do, and put back if it turns out to be
+// the fresh lock.
+func removeStaleLock(path string, stale os.FileInfo) {
+	aside, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.stale")
+	if err != nil {
+		return
+	}
+	asidePath := aside.Name()
+	aside.Close()
+	defer os.Remove(asidePath)
+
+	if err := os.Rename(path, asidePath); err != nil {
+		return
+	}
+	// A fresh lock may reuse the stale one's inode, but not its age
+	if info, err := os.Stat(asidePath); err == nil && (!os.SameFile(info, stale) || !info.ModTime().Equal(stale.ModTime())) {
+		// Link doesn't replace a lock created in the meantime
+		os.Link(asidePath, path)
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFileTakesOverStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token.json.lock")
+	if err := os.WriteFile(path, []byte("1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStaleAfter)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	unlock()
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("left %d files behind", len(entries))
+	}
+}
+
+// TestRemoveStaleLockKeepsFreshLock covers a waiter that found the lock stale
+// while another waiter took it over and created a fresh one
+func TestRemoveStaleLockKeepsFreshLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token.json.lock")
+	if err := os.WriteFile(path, []byte("1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStaleAfter)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove(path)
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	defer unlock()
+
+	removeStaleLock(path, stale)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("fresh lock removed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("left %d files, want only the lock", len(entries))
+	}
+}
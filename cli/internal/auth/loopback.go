@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrLoginCancelled = errors.New("login was cancelled in the browser")
+	ErrLoginTimeout   = errors.New("timed out waiting for the browser login to complete")
+)
+
+const callbackPath = "/callback"
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// LoopbackServer is a short-lived HTTP listener on 127.0.0.1 that receives
+// the OAuth authorization redirect from the browser
+type LoopbackServer struct {
+	listener net.Listener
+	server   *http.Server
+	state    string
+	result   chan callbackResult
+}
+
+// StartLoopbackServer listens on a random local port and waits for a redirect
+// carrying the given state
+func StartLoopbackServer(state string) (*LoopbackServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start local callback listener: %w", err)
+	}
+
+	s := &LoopbackServer{
+		listener: listener,
+		state:    state,
+		result:   make(chan callbackResult, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, s.handleCallback)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go s.server.Serve(listener)
+
+	return s, nil
+}
+
+// RedirectURI is the redirect_uri to register with the authorization request
+func (s *LoopbackServer) RedirectURI() string {
+	return fmt.Sprintf("http://%s%s", s.listener.Addr().String(), callbackPath)
+}
+
+// WaitForCode blocks until the browser redirect arrives, the timeout expires
+// or ctx is cancelled
+func (s *LoopbackServer) WaitForCode(ctx context.Context, timeout time.Duration) (string, error) {
+	select {
+	case res := <-s.result:
+		return res.code, res.err
+	case <-time.After(timeout):
+		return "", ErrLoginTimeout
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (s *LoopbackServer) Close() error {
+	return s.server.Close()
+}
+
+func (s *LoopbackServer) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Anything may send a request to the port; a redirect without our state
+	// isn't the login in progress, so it is turned away and we keep waiting
+	if query.Get("state") != s.state {
+		http.Error(w, "Backend.im login failed: state mismatch in OAuth redirect", http.StatusBadRequest)
+		return
+	}
+
+	var res callbackResult
+	switch {
+	case query.Get("error") == "access_denied":
+		res.err = ErrLoginCancelled
+	case query.Get("error") != "":
+		res.err = fmt.Errorf("authorization failed: %s %s", query.Get("error"), query.Get("error_description"))
+	case query.Get("code") == "":
+		res.err = fmt.Errorf("authorization redirect did not include a code")
+	default:
+		res.code = query.Get("code")
+	}
+
+	if res.err != nil {
+		http.Error(w, "Backend.im login failed: "+res.err.Error(), http.StatusBadRequest)
+	} else {
+		fmt.Fprintln(w, "✅ Backend.im login complete - you can close this window and return to the CLI.")
+	}
+
+	// Only the first redirect counts
+	select {
+	case s.result <- res:
+	default:
+	}
+}
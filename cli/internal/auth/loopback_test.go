@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewPKCE(t *testing.T) {
+	pkce, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 7636 §4.1: 43 to 128 characters
+	if n := len(pkce.Verifier); n < 43 || n > 128 {
+		t.Errorf("verifier is %d characters", n)
+	}
+	sum := sha256.Sum256([]byte(pkce.Verifier))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:]); pkce.Challenge != want || pkce.Method != "S256" {
+		t.Errorf("challenge %s %q, want S256 %q", pkce.Method, pkce.Challenge, want)
+	}
+
+	other, _ := NewPKCE()
+	if other.Verifier == pkce.Verifier {
+		t.Errorf("two verifiers are the same")
+	}
+}
+
+func TestLoopbackServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  url.Values
+		code   string
+		err    error
+		status int
+	}{
+		{name: "code", query: url.Values{"state": {"s1"}, "code": {"abc"}}, code: "abc", status: http.StatusOK},
+		{name: "state mismatch", query: url.Values{"state": {"forged"}, "code": {"abc"}}, err: ErrLoginTimeout, status: http.StatusBadRequest},
+		{name: "no state", query: url.Values{"code": {"abc"}}, err: ErrLoginTimeout, status: http.StatusBadRequest},
+		{name: "denied", query: url.Values{"state": {"s1"}, "error": {"access_denied"}}, err: ErrLoginCancelled, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := StartLoopbackServer("s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			resp, err := http.Get(server.RedirectURI() + "?" + tt.query.Encode())
+			if err != nil {
+				t.Fatalf("redirect: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("redirect answered %d, want %d", resp.StatusCode, tt.status)
+			}
+
+			code, err := server.WaitForCode(context.Background(), 100*time.Millisecond)
+			if code != tt.code || !errors.Is(err, tt.err) {
+				t.Errorf("WaitForCode = %q, %v; want %q, %v", code, err, tt.code, tt.err)
+			}
+		})
+	}
+}
+
+// TestLoopbackServerIgnoresForgedRedirect keeps waiting after a redirect with
+// the wrong state, which anything could send to the port, and completes with
+// the real one
+func TestLoopbackServerIgnoresForgedRedirect(t *testing.T) {
+	server, err := StartLoopbackServer("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	for _, query := range []string{"state=forged&code=evil", "state=s1&code=abc", "state=s1&code=late"} {
+		resp, err := http.Get(server.RedirectURI() + "?" + query)
+		if err != nil {
+			t.Fatalf("redirect: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if code, err := server.WaitForCode(context.Background(), time.Second); code != "abc" || err != nil {
+		t.Errorf("WaitForCode = %q, %v; want the code of the first redirect with our state", code, err)
+	}
+}
+
+func TestLoopbackServerTimeout(t *testing.T) {
+	server, err := StartLoopbackServer("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if _, err := server.WaitForCode(context.Background(), 10*time.Millisecond); !errors.Is(err, ErrLoginTimeout) {
+		t.Errorf("WaitForCode: err = %v, want ErrLoginTimeout", err)
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// PKCE holds a proof key for code exchange (RFC 7636)
+type PKCE struct {
+	Verifier  string
+	Challenge string
+	Method    string
+}
+
+// NewPKCE generates a random code verifier and its S256 challenge
+func NewPKCE() (*PKCE, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return &PKCE{
+		Verifier:  verifier,
+		Challenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		Method:    "S256",
+	}, nil
+}
+
+// NewState generates a random OAuth state parameter
+func NewState() (string, error) {
+	return randomString(16)
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
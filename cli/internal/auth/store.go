@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Credential store names accepted by 'login --store'
+const (
+	StoreFile      = "file"
+	StoreEncrypted = "encrypted"
+)
+
+// ErrNotAuthenticated is returned when no token has been saved
+var ErrNotAuthenticated = errors.New("not authenticated - run 'backend-im auth' first")
+
+// CredentialStore persists the authentication token
+type CredentialStore interface {
+	// Name is the store name used in config.json and 'login --store'
+	Name() string
+	// Path is the file the token is kept in
+	Path() string
+	Load() (*Token, error)
+	Save(token *Token) error
+	Delete() error
+}
+
+// NewStore returns the credential store with the given name for the active context
+func NewStore(name string) (CredentialStore, error) {
+	contextName, _, err := CurrentContext()
+	if err != nil {
+		return nil, err
+	}
+	return newContextStore(name, contextName)
+}
+
+func newContextStore(name, contextName string) (CredentialStore, error) {
+	dir, err := ContextDir(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "", StoreFile:
+		return &FileStore{path: filepath.Join(dir, tokenFile)}, nil
+	case StoreEncrypted:
+		return &EncryptedFileStore{path: filepath.Join(dir, encryptedTokenFile)}, nil
+	default:
+		return nil, fmt.Errorf("unknown credential store %q (use %q or %q)", name, StoreFile, StoreEncrypted)
+	}
+}
+
+// ActiveStore returns the credential store selected in config.json for the active context
+func ActiveStore() (CredentialStore, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(config.CredentialStore)
+}
+
+// UseStore makes name the credential store for all contexts. Tokens saved in
+// the previously active store are moved to the new one, so existing logins
+// survive the switch. Each context's token lock is held from before its token
+// is read until the new store is saved in config.json, so a concurrent
+// refresh can neither write to the old store after the move nor read the
+// config before it names the new store.
+func UseStore(name string) (CredentialStore, error) {
+	if _, err := newContextStore(name, DefaultContext); err != nil {
+		return nil, err
+	}
+
+	var unlocks []func()
+	defer func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}()
+
+	err := UpdateConfig(func(config *Config) error {
+		if config.CredentialStore == name || (config.CredentialStore == "" && name == StoreFile) {
+			return nil
+		}
+
+		for _, contextName := range config.ContextNames() {
+			current, err := newContextStore(config.CredentialStore, contextName)
+			if err != nil {
+				return err
+			}
+			unlock, err := lockContextToken(contextName)
+			if err != nil {
+				return fmt.Errorf("context %s: %w", contextName, err)
+			}
+			unlocks = append(unlocks, unlock)
+			if _, err := os.Stat(current.Path()); err != nil {
+				continue
+			}
+			next, err := newContextStore(name, contextName)
+			if err != nil {
+				return err
+			}
+			if err := migrateToken(current, next); err != nil {
+				return fmt.Errorf("context %s: %w", contextName, err)
+			}
+		}
+
+		config.CredentialStore = name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStore(name)
+}
+
+func migrateToken(from, to CredentialStore) error {
+	token, err := from.Load()
+	if err != nil {
+		return fmt.Errorf("failed to read token from %s store: %w", from.Name(), err)
+	}
+	if err := to.Save(token); err != nil {
+		return fmt.Errorf("failed to migrate token to %s store: %w", to.Name(), err)
+	}
+	if err := from.Delete(); err != nil {
+		return fmt.Errorf("token migrated but old %s could not be removed: %w", from.Path(), err)
+	}
+	return nil
+}
+
+// FileStore keeps the token as plaintext JSON in ~/.backend-im/token.json
+type FileStore struct {
+	path string
+}
+
+func (s *FileStore) Name() string { return StoreFile }
+
+func (s *FileStore) Path() string { return s.path }
+
+func (s *FileStore) Load() (*Token, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotAuthenticated
+		}
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token file: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (s *FileStore) Save(token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+	return WriteFileAtomic(s.path, data)
+}
+
+func (s *FileStore) Delete() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+	return nil
+}
+
+// WriteFileAtomic writes a file readable only by the user. It writes to a
+// temp file and renames it into place so readers never see a partial file;
+// each writer gets its own temp file, so concurrent writers can't clobber
+// each other's half-written data.
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	// CreateTemp makes the file 0600, which the rename keeps
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
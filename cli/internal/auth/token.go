@@ -1,19 +1,65 @@
 package auth
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 const configDir = ".backend-im"
 const tokenFile = "token.json"
 
+// TokenEnv supplies an access token directly, overriding the saved token (for CI)
+const TokenEnv = "BACKEND_IM_TOKEN"
+
 type Token struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int    `json:"expires_in"`
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	ExpiresIn    int       `json:"expires_in"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IssuedAt     time.Time `json:"issued_at,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+
+	// FromEnv is set when the token came from BACKEND_IM_TOKEN rather than a store
+	FromEnv bool `json:"-"`
+}
+
+// NewToken builds a token issued now that expires after expiresIn seconds
+func NewToken(accessToken, tokenType, refreshToken string, expiresIn int) *Token {
+	now := time.Now()
+	token := &Token{
+		AccessToken:  accessToken,
+		TokenType:    tokenType,
+		ExpiresIn:    expiresIn,
+		RefreshToken: refreshToken,
+		IssuedAt:     now,
+	}
+	if expiresIn > 0 {
+		token.ExpiresAt = now.Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token
+}
+
+// ExpiresWithin reports whether the token expires within d. Tokens saved by
+// older CLI versions have no expiry time and are never considered stale.
+func (t *Token) ExpiresWithin(d time.Duration) bool {
+	if t.ExpiresAt.IsZero() {
+		return false
+	}
+	return time.Now().Add(d).After(t.ExpiresAt)
+}
+
+// Expired reports whether the token has already expired
+func (t *Token) Expired() bool {
+	return t.ExpiresWithin(0)
+}
+
+// CanRefresh reports whether the token can be renewed without logging in again
+func (t *Token) CanRefresh() bool {
+	return t.RefreshToken != ""
 }
 
 func GetConfigPath() (string, error) {
@@ -24,64 +70,109 @@ func GetConfigPath() (string, error) {
 	return filepath.Join(homeDir, configDir), nil
 }
 
+// SaveToken saves the token in the active credential store. It replaces
+// whatever is saved without reading it, so a corrupted token file doesn't
+// stand in the way of logging in again.
 func SaveToken(token *Token) error {
-	configPath, err := GetConfigPath()
+	unlock, err := lockToken()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	// Create config directory if it doesn't exist
-	if err := os.MkdirAll(configPath, 0700); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+	store, err := ActiveStore()
+	if err != nil {
+		return err
 	}
+	return store.Save(token)
+}
 
-	tokenPath := filepath.Join(configPath, tokenFile)
-	data, err := json.Marshal(token)
+// UpdateToken replaces the saved token while holding the token lock, so that
+// concurrent CLI processes don't overwrite each other. update receives the
+// currently saved token (nil if there is none) and returns the token to save;
+// returning the current token unchanged skips the write.
+func UpdateToken(update func(current *Token) (*Token, error)) (*Token, error) {
+	unlock, err := lockToken()
 	if err != nil {
-		return fmt.Errorf("failed to marshal token: %w", err)
+		return nil, err
 	}
+	defer unlock()
 
-	if err := os.WriteFile(tokenPath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write token file: %w", err)
+	store, err := ActiveStore()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
+	current, err := store.Load()
+	if errors.Is(err, ErrNotAuthenticated) {
+		current = nil
+	} else if err != nil {
+		return nil, err
+	}
 
-func LoadToken() (*Token, error) {
-	configPath, err := GetConfigPath()
+	next, err := update(current)
 	if err != nil {
 		return nil, err
 	}
+	if next == current {
+		return current, nil
+	}
 
-	tokenPath := filepath.Join(configPath, tokenFile)
-	data, err := os.ReadFile(tokenPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("not authenticated - run 'backend-im auth' first")
-		}
-		return nil, fmt.Errorf("failed to read token file: %w", err)
+	if err := store.Save(next); err != nil {
+		return nil, err
 	}
+	return next, nil
+}
 
-	var token Token
-	if err := json.Unmarshal(data, &token); err != nil {
-		return nil, fmt.Errorf("failed to parse token file: %w", err)
+// LoadToken returns the token from BACKEND_IM_TOKEN if set, otherwise reads
+// it from the active credential store
+func LoadToken() (*Token, error) {
+	if accessToken := strings.TrimSpace(os.Getenv(TokenEnv)); accessToken != "" {
+		return &Token{AccessToken: accessToken, TokenType: "Bearer", FromEnv: true}, nil
 	}
 
-	return &token, nil
+	store, err := ActiveStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Load()
 }
 
+// DeleteToken removes the token from the active credential store. It takes
+// the token lock so it can't race a refresh that would save the token again.
 func DeleteToken() error {
-	configPath, err := GetConfigPath()
+	unlock, err := lockToken()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	tokenPath := filepath.Join(configPath, tokenFile)
-	if err := os.Remove(tokenPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete token: %w", err)
+	store, err := ActiveStore()
+	if err != nil {
+		return err
 	}
+	return store.Delete()
+}
 
-	return nil
+// lockToken takes the current context's token lock, held while the token is
+// read and replaced or deleted
+func lockToken() (func(), error) {
+	contextName, _, err := CurrentContext()
+	if err != nil {
+		return nil, err
+	}
+	return lockContextToken(contextName)
 }
 
+// lockContextToken takes the token lock of the named context
+func lockContextToken(contextName string) (func(), error) {
+	dir, err := ContextDir(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+	return lockFile(filepath.Join(dir, tokenFile+".lock"))
+}
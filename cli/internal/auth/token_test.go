@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+func TestWriteFileAtomicConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token.json")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- WriteFileAtomic(path, []byte(fmt.Sprintf(`{"writer": %d}`, i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("write: %v", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("left %d files behind, want only token.json", len(entries))
+	}
+}
+
+func TestDeleteTokenWaitsForLock(t *testing.T) {
+	home := testenv.Isolate(t)
+
+	if err := SaveToken(NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// Hold the lock as a refresh in another process would
+	unlock, err := lockToken()
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	deleted := make(chan error, 1)
+	go func() { deleted <- DeleteToken() }()
+
+	select {
+	case err := <-deleted:
+		t.Fatalf("DeleteToken returned %v while the token was locked", err)
+	case <-time.After(3 * lockRetryInterval):
+	}
+	unlock()
+
+	if err := <-deleted; err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, configDir, tokenFile)); !os.IsNotExist(err) {
+		t.Errorf("token.json still exists after DeleteToken: %v", err)
+	}
+}
+
+func TestLoadTokenFromEnv(t *testing.T) {
+	testenv.Isolate(t)
+	if err := SaveToken(NewToken("saved", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// The environment wins over the saved token
+	t.Setenv(TokenEnv, "  bim_sa_ci\n")
+	token, err := LoadToken()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if token.AccessToken != "bim_sa_ci" || !token.FromEnv || token.CanRefresh() {
+		t.Errorf("token from %s = %+v", TokenEnv, token)
+	}
+
+	t.Setenv(TokenEnv, "")
+	if token, err := LoadToken(); err != nil || token.AccessToken != "saved" || token.FromEnv {
+		t.Errorf("without %s: token %+v, err %v", TokenEnv, token, err)
+	}
+}
+
+func TestUpdateTokenUnreadableToken(t *testing.T) {
+	home := testenv.Isolate(t)
+	path := filepath.Join(home, configDir, tokenFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token that can't be read is not the same as no token
+	_, err := UpdateToken(func(current *Token) (*Token, error) {
+		t.Errorf("update called with %+v", current)
+		return current, nil
+	})
+	if err == nil || errors.Is(err, ErrNotAuthenticated) {
+		t.Errorf("update: err = %v, want the parse error", err)
+	}
+
+	// Logging in again replaces it
+	if err := SaveToken(NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if token, err := LoadToken(); err != nil || token.AccessToken != "access" {
+		t.Errorf("after save: token %+v, err %v", token, err)
+	}
+}
@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 
@@ -13,67 +15,56 @@ func NewAuthCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "auth",
 		Short: "Authenticate with Backend.im",
-		Long:  "Authenticate with Backend.im using Google OAuth",
+		Long:  "Authenticate with Backend.im using Google OAuth (same as login)",
+		RunE:  runLogin,
+	}
+
+	addLoginFlags(cmd)
+
+	// Add logout subcommand
+	logoutCmd := &cobra.Command{
+		Use:   "logout",
+		Short: "Log out from Backend.im",
+		Long:  "Revoke the saved token on the server and delete it locally. Use --all to revoke every session for your account.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Check if already authenticated
-			existingToken, err := auth.LoadToken()
-			if err == nil && existingToken != nil {
-				fmt.Println("✅ Already authenticated!")
-				
-				// Verify token is still valid
-				apiClient := api.NewClient()
-				apiClient.SetAuthToken(existingToken.AccessToken)
-				verifyResp, err := apiClient.VerifyAuth()
-				if err == nil && verifyResp.Valid {
-					fmt.Printf("👤 User: %s (%s)\n", verifyResp.UserID, verifyResp.Email)
+			ctx := cmd.Context()
+			all, _ := cmd.Flags().GetBool("all")
+
+			token, err := auth.LoadToken()
+			if err != nil {
+				if errors.Is(err, auth.ErrNotAuthenticated) {
+					fmt.Println("✅ Already logged out")
 					return nil
 				}
-				fmt.Println("⚠️  Token expired, re-authenticating...")
-			}
-
-			fmt.Println("🔐 Authenticating with Backend.im...")
-			fmt.Println("")
-			fmt.Println("For now, using mock authentication.")
-			fmt.Println("In production, this will open a browser for Google OAuth.")
-			fmt.Println("")
-
-			// Mock authentication flow (for testing with mock API)
-			// In production, this would:
-			// 1. Open browser with OAuth URL
-			// 2. Handle callback
-			// 3. Exchange code for token
-			
-			// For mock API, we'll use a simple mock token
-			mockToken := &auth.Token{
-				AccessToken: "mock_token_" + fmt.Sprintf("%d", os.Getpid()),
-				TokenType:   "Bearer",
-				ExpiresIn:   3600,
+				return fmt.Errorf("failed to logout: %w", err)
 			}
-
-			if err := auth.SaveToken(mockToken); err != nil {
-				return fmt.Errorf("failed to save token: %w", err)
+			if token.FromEnv {
+				return fmt.Errorf("the token comes from %s - unset it to log out", auth.TokenEnv)
 			}
 
-			fmt.Println("✅ Authentication successful!")
-			fmt.Printf("💾 Token saved to: ~/.backend-im/token.json\n")
-
-			// Verify the token works
 			apiClient := api.NewClient()
-			apiClient.SetAuthToken(mockToken.AccessToken)
-			verifyResp, err := apiClient.VerifyAuth()
-			if err == nil && verifyResp.Valid {
-				fmt.Printf("👤 User: %s (%s)\n", verifyResp.UserID, verifyResp.Email)
+			if all {
+				apiClient.SetToken(token)
+				revokeResp, err := apiClient.RevokeAllSessions(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						reportCancelled(ctx, "Logout cancelled - your local token was kept, but sessions may already be revoked on the server")
+						return err
+					}
+					return fmt.Errorf("failed to revoke sessions: %w\nYour local token was kept so you can retry", err)
+				}
+				fmt.Printf("🔒 Revoked %d tokens across all sessions\n", revokeResp.Revoked)
+			} else if err := revokeToken(ctx, apiClient, token); err != nil {
+				if ctx.Err() != nil {
+					reportCancelled(ctx, "Logout cancelled - your local token was kept, but it may already be revoked on the server")
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "⚠️  Warning: Could not revoke token on the server: %v\n", err)
+				fmt.Fprintln(os.Stderr, "   The local token will be deleted, but it stays valid until it expires.")
+			} else {
+				fmt.Println("🔒 Token revoked on the server")
 			}
 
-			return nil
-		},
-	}
-
-	// Add logout subcommand
-	logoutCmd := &cobra.Command{
-		Use:   "logout",
-		Short: "Log out from Backend.im",
-		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := auth.DeleteToken(); err != nil {
 				return fmt.Errorf("failed to logout: %w", err)
 			}
@@ -81,8 +72,20 @@ func NewAuthCommand() *cobra.Command {
 			return nil
 		},
 	}
+	logoutCmd.Flags().Bool("all", false, "Revoke every session for your account, not just this one")
 	cmd.AddCommand(logoutCmd)
+	cmd.AddCommand(newAuthStatusCommand())
 
 	return cmd
 }
 
+// revokeToken revokes both halves of a login: the refresh token first, so it
+// can't be used to mint a new access token, then the access token itself
+func revokeToken(ctx context.Context, apiClient *api.Client, token *auth.Token) error {
+	if token.CanRefresh() {
+		if err := apiClient.RevokeToken(ctx, token.RefreshToken, "refresh_token"); err != nil {
+			return err
+		}
+	}
+	return apiClient.RevokeToken(ctx, token.AccessToken, "access_token")
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+// revokeServer records revocations, failing them all when down is set and
+// answering none until the client gives up when hang is set
+type revokeServer struct {
+	mu       sync.Mutex
+	down     bool
+	hang     bool
+	revoked  []string
+	sessions int
+}
+
+func (s *revokeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.hang {
+		<-r.Context().Done()
+		return
+	}
+	if s.down {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": {"code": "internal", "message": "Down"}}`))
+		return
+	}
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/auth/revoke":
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		s.revoked = append(s.revoked, req["token_type_hint"]+":"+req["token"])
+		w.Write([]byte(`{}`))
+	case r.Method == "DELETE" && r.URL.Path == "/api/auth/sessions":
+		s.sessions++
+		w.Write([]byte(`{"revoked": 3}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// runLogout logs in against server, then runs 'auth logout args...'
+func runLogout(ctx context.Context, t *testing.T, server *revokeServer, args ...string) error {
+	t.Helper()
+	testenv.NewAPI(t, server)
+
+	if err := auth.SaveToken(auth.NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	cmd := NewAuthCommand()
+	cmd.SetArgs(append([]string{"logout"}, args...))
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	return cmd.ExecuteContext(ctx)
+}
+
+func TestLogoutRevokesToken(t *testing.T) {
+	server := &revokeServer{}
+	if err := runLogout(context.Background(), t, server); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+
+	// The refresh token goes first, so it can't mint another access token
+	want := []string{"refresh_token:refresh", "access_token:access"}
+	if len(server.revoked) != 2 || server.revoked[0] != want[0] || server.revoked[1] != want[1] {
+		t.Errorf("revoked %v, want %v", server.revoked, want)
+	}
+	if _, err := auth.LoadToken(); !errors.Is(err, auth.ErrNotAuthenticated) {
+		t.Errorf("token still saved after logout: %v", err)
+	}
+}
+
+// TestLogoutServerDown still deletes the local token when the server can't
+// revoke it
+func TestLogoutServerDown(t *testing.T) {
+	if err := runLogout(context.Background(), t, &revokeServer{down: true}); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	if _, err := auth.LoadToken(); !errors.Is(err, auth.ErrNotAuthenticated) {
+		t.Errorf("token still saved after logout: %v", err)
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	server := &revokeServer{}
+	if err := runLogout(context.Background(), t, server, "--all"); err != nil {
+		t.Fatalf("logout --all: %v", err)
+	}
+	if server.sessions != 1 || len(server.revoked) != 0 {
+		t.Errorf("revoked sessions %d times and tokens %v", server.sessions, server.revoked)
+	}
+	if _, err := auth.LoadToken(); !errors.Is(err, auth.ErrNotAuthenticated) {
+		t.Errorf("token still saved after logout: %v", err)
+	}
+}
+
+// TestLogoutAllServerDown keeps the token when not every session could be
+// revoked, so the user can retry
+func TestLogoutAllServerDown(t *testing.T) {
+	if err := runLogout(context.Background(), t, &revokeServer{down: true}, "--all"); err == nil {
+		t.Fatalf("logout --all succeeded with the server down")
+	}
+	if _, err := auth.LoadToken(); err != nil {
+		t.Errorf("token was deleted although its sessions weren't revoked: %v", err)
+	}
+}
+
+// TestLogoutAllCancelled keeps the token and reports the cancellation, not a
+// failure to revoke, when Ctrl-C interrupts revoking the sessions
+func TestLogoutAllCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := runLogout(ctx, t, &revokeServer{hang: true}, "--all")
+	if !errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "failed to revoke") {
+		t.Fatalf("logout --all: err = %v, want the cancellation", err)
+	}
+	if _, err := auth.LoadToken(); err != nil {
+		t.Errorf("token was deleted although its sessions weren't revoked: %v", err)
+	}
+}
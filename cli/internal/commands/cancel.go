@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// reportCancelled tells the user what state an interrupted command left
+// behind. It prints nothing unless ctx was cancelled (e.g. by Ctrl-C).
+func reportCancelled(ctx context.Context, format string, args ...interface{}) {
+	if ctx.Err() == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  "+format+"\n", args...)
+}
+
+// sleepContext waits for d, returning early with ctx's error if it is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -13,8 +13,9 @@ func NewCommitCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "commit [project-id]",
 		Short: "Commit local changes to Backend.im",
-		Long:  "Commit local file changes to Backend.im. Changes are saved to Gitea and can be deployed.",
+		Long:  "Commit local file changes to Backend.im. Changes are saved to Gitea and can be deployed. Only delta uploads resume: with a server that supports them, an interrupted upload of 4 MB or more continues on the next commit; otherwise the whole project is sent again.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
 			projectDir, _ := cmd.Flags().GetString("dir")
 			projectID, _ := cmd.Flags().GetString("project")
 			message, _ := cmd.Flags().GetString("message")
@@ -44,30 +45,32 @@ func NewCommitCommand() *cobra.Command {
 
 			// Read local project files
 			fmt.Printf("📂 Reading files from: %s\n", projectDir)
-			fileMap, err := files.ReadProjectFiles(projectDir)
+			project, err := files.ScanProject(projectDir)
 			if err != nil {
 				return fmt.Errorf("failed to read project files: %w", err)
 			}
 
-			if len(fileMap) == 0 {
+			if len(project.Files) == 0 {
 				return fmt.Errorf("no files found in %s", projectDir)
 			}
 
-			fmt.Printf("📦 Found %d files\n", len(fileMap))
+			fmt.Printf("📦 Found %d files (%s)\n", len(project.Files), formatBytes(project.TotalSize()))
 			fmt.Printf("📁 Project ID: %s\n", projectID)
 			fmt.Printf("💬 Commit message: %s\n", message)
 
 			// Create API client
 			apiClient := api.NewClient()
-			apiClient.SetAuthToken(token.AccessToken)
+			apiClient.SetToken(token)
 
 			// Commit changes
 			fmt.Println("💾 Committing changes to Backend.im...")
-			commitResp, err := apiClient.CommitChanges(fileMap, projectID, message)
+			commitResp, stats, err := apiClient.CommitProject(ctx, project, projectID, message)
 			if err != nil {
+				reportCancelled(ctx, "Commit cancelled - the server may still have saved it. Re-run the commit to be sure your changes are saved")
 				return fmt.Errorf("failed to commit changes: %w", err)
 			}
 
+			printUploadStats(stats)
 			fmt.Printf("✅ Changes committed successfully!\n")
 			fmt.Printf("🔑 Commit Hash: %s\n", commitResp.CommitHash)
 			fmt.Printf("📊 Status: %s\n", commitResp.Status)
@@ -84,4 +87,3 @@ func NewCommitCommand() *cobra.Command {
 
 	return cmd
 }
-
@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/backend-im/cli/internal/api"
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+// NewContextCommand manages named profiles, each with its own API URL and credentials
+func NewContextCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "context",
+		Short: "Manage Backend.im contexts (API endpoints and accounts)",
+		Long:  "Manage named contexts. Each context has its own API URL and its own login, so you can switch between a local mock API, staging and production.",
+	}
+
+	cmd.AddCommand(newContextListCommand())
+	cmd.AddCommand(newContextUseCommand())
+	cmd.AddCommand(newContextAddCommand())
+	cmd.AddCommand(newContextRemoveCommand())
+
+	return cmd
+}
+
+func newContextListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List contexts",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := auth.LoadConfig()
+			if err != nil {
+				return err
+			}
+			current, _, err := auth.CurrentContext()
+			if err != nil {
+				return err
+			}
+
+			for _, name := range config.ContextNames() {
+				ctx, _ := config.Context(name)
+				apiURL := ctx.APIURL
+				if apiURL == "" {
+					apiURL = api.DefaultAPIURL
+				}
+
+				marker := " "
+				if name == current {
+					marker = "*"
+				}
+				fmt.Printf("%s %-15s %s%s\n", marker, name, apiURL, networkSummary(ctx))
+			}
+
+			if os.Getenv("BACKEND_IM_API_URL") != "" {
+				fmt.Println("")
+				fmt.Printf("⚠️  BACKEND_IM_API_URL is set and overrides the context URL: %s\n", os.Getenv("BACKEND_IM_API_URL"))
+			}
+			return nil
+		},
+	}
+}
+
+func newContextUseCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "use <name>",
+		Short: "Switch the active context",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			err := auth.UpdateConfig(func(config *auth.Config) error {
+				if _, ok := config.Context(name); !ok {
+					return fmt.Errorf("unknown context %q - add it with 'backend-im context add %s --api-url <url>'", name, name)
+				}
+				config.CurrentContext = name
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("✅ Switched to context: %s\n", name)
+			return nil
+		},
+	}
+}
+
+func newContextAddCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add a context",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			apiURL, _ := cmd.Flags().GetString("api-url")
+			use, _ := cmd.Flags().GetBool("use")
+
+			if err := auth.ValidateContextName(name); err != nil {
+				return err
+			}
+			if apiURL == "" {
+				return fmt.Errorf("--api-url is required")
+			}
+
+			ctx := &auth.Context{APIURL: apiURL}
+			if err := readNetworkFlags(cmd, ctx); err != nil {
+				return err
+			}
+
+			err := auth.UpdateConfig(func(config *auth.Config) error {
+				if _, exists := config.Contexts[name]; exists {
+					return fmt.Errorf("context %q already exists - remove it first to change it", name)
+				}
+				if config.Contexts == nil {
+					config.Contexts = make(map[string]*auth.Context)
+				}
+				config.Contexts[name] = ctx
+				if use {
+					config.CurrentContext = name
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("✅ Added context %s (%s)\n", name, apiURL)
+			if use {
+				fmt.Printf("✅ Switched to context: %s\n", name)
+			}
+			fmt.Printf("💡 Run 'backend-im login --context %s' to log in\n", name)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("api-url", "", "API URL for this context (e.g. https://api.backend.im)")
+	cmd.Flags().Bool("use", false, "Switch to the new context")
+	cmd.Flags().String("proxy", "", "Proxy URL for this context (default: HTTPS_PROXY)")
+	cmd.Flags().String("ca-cert", "", "PEM file with extra CA certificates to trust")
+	cmd.Flags().String("client-cert", "", "PEM client certificate for mutual TLS")
+	cmd.Flags().String("client-key", "", "PEM private key of the client certificate")
+	cmd.Flags().Bool("insecure-skip-verify", false, "INSECURE: don't verify the server's TLS certificate (local testing only)")
+	cmd.Flags().StringArray("ws-rewrite", nil, "Rewrite the host of server-provided WebSocket URLs, as from=to (repeatable)")
+
+	return cmd
+}
+
+// readNetworkFlags copies the network flags of 'context add' into ctx.
+// Certificate paths are stored absolute so the context works from any directory.
+func readNetworkFlags(cmd *cobra.Command, ctx *auth.Context) error {
+	ctx.Proxy, _ = cmd.Flags().GetString("proxy")
+	ctx.InsecureSkipVerify, _ = cmd.Flags().GetBool("insecure-skip-verify")
+
+	paths := map[string]*string{
+		"ca-cert":     &ctx.CACert,
+		"client-cert": &ctx.ClientCert,
+		"client-key":  &ctx.ClientKey,
+	}
+	for flag, field := range paths {
+		path, _ := cmd.Flags().GetString(flag)
+		if path == "" {
+			continue
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("invalid --%s path: %w", flag, err)
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return fmt.Errorf("--%s: %w", flag, err)
+		}
+		*field = absPath
+	}
+
+	if (ctx.ClientCert == "") != (ctx.ClientKey == "") {
+		return fmt.Errorf("--client-cert and --client-key must be used together")
+	}
+
+	ctx.WSRewrites, _ = cmd.Flags().GetStringArray("ws-rewrite")
+	for _, rule := range ctx.WSRewrites {
+		if _, err := api.ParseHostRewrite(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// networkSummary describes a context's non-default network settings
+func networkSummary(ctx *auth.Context) string {
+	var settings []string
+	if ctx.Proxy != "" {
+		settings = append(settings, "proxy "+ctx.Proxy)
+	}
+	if ctx.CACert != "" {
+		settings = append(settings, "custom CA")
+	}
+	if ctx.ClientCert != "" {
+		settings = append(settings, "mTLS")
+	}
+	if len(ctx.WSRewrites) > 0 {
+		settings = append(settings, "WebSocket rewrites "+strings.Join(ctx.WSRewrites, ", "))
+	}
+	if ctx.InsecureSkipVerify {
+		settings = append(settings, "INSECURE")
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(settings, ", ") + ")"
+}
+
+func newContextRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "remove <name>",
+		Aliases: []string{"rm"},
+		Short:   "Remove a context and its saved credentials",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			if name == auth.DefaultContext {
+				return fmt.Errorf("the default context cannot be removed")
+			}
+
+			err := auth.UpdateConfig(func(config *auth.Config) error {
+				if _, exists := config.Contexts[name]; !exists {
+					return fmt.Errorf("unknown context %q", name)
+				}
+				delete(config.Contexts, name)
+				if config.CurrentContext == name {
+					config.CurrentContext = ""
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			dir, err := auth.ContextDir(name)
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				return fmt.Errorf("context removed but its credentials could not be deleted: %w", err)
+			}
+
+			fmt.Printf("✅ Removed context: %s\n", name)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+// captureStdout runs run and returns what it printed to stdout
+func captureStdout(t *testing.T, run func() error) (string, error) {
+	t.Helper()
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	err = run()
+	w.Close()
+	return <-output, err
+}
+
+// runContext runs 'context args...' and returns what it printed
+func runContext(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	return captureStdout(t, func() error {
+		cmd := NewContextCommand()
+		cmd.SetArgs(args)
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(io.Discard)
+		return cmd.Execute()
+	})
+}
+
+func TestContextCommands(t *testing.T) {
+	testenv.Isolate(t)
+
+	// Each step runs on the config the previous ones left behind
+	steps := []struct {
+		args    []string
+		err     string
+		current string
+	}{
+		{[]string{"add", "staging", "--api-url", "https://staging.example"}, "", auth.DefaultContext},
+		{[]string{"add", "staging", "--api-url", "https://other.example"}, "already exists", auth.DefaultContext},
+		{[]string{"add", "../escape", "--api-url", "https://staging.example"}, "invalid context name", auth.DefaultContext},
+		{[]string{"add", "prod"}, "--api-url is required", auth.DefaultContext},
+		{[]string{"add", "prod", "--api-url", "https://api.example", "--use"}, "", "prod"},
+		{[]string{"use", "staging"}, "", "staging"},
+		{[]string{"use", "nowhere"}, "unknown context", "staging"},
+		{[]string{"remove", auth.DefaultContext}, "cannot be removed", "staging"},
+		{[]string{"remove", "nowhere"}, "unknown context", "staging"},
+		{[]string{"remove", "staging"}, "", auth.DefaultContext},
+		{[]string{"use", "prod"}, "", "prod"},
+	}
+	for _, step := range steps {
+		_, err := runContext(t, step.args...)
+		if step.err == "" && err != nil {
+			t.Fatalf("context %s: %v", strings.Join(step.args, " "), err)
+		}
+		if step.err != "" && (err == nil || !strings.Contains(err.Error(), step.err)) {
+			t.Fatalf("context %s: err = %v, want %q", strings.Join(step.args, " "), err, step.err)
+		}
+		if current, _, _ := auth.CurrentContext(); current != step.current {
+			t.Fatalf("after context %s: current context %q, want %q", strings.Join(step.args, " "), current, step.current)
+		}
+	}
+
+	output, err := runContext(t, "list")
+	if err != nil {
+		t.Fatalf("context list: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	want := []string{"  default         http://localhost:8080", "* prod            https://api.example"}
+	if len(lines) != len(want) || lines[0] != want[0] || lines[1] != want[1] {
+		t.Errorf("context list printed:\n%s\nwant:\n%s", output, strings.Join(want, "\n"))
+	}
+}
+
+func TestContextRemoveDeletesCredentials(t *testing.T) {
+	testenv.Isolate(t)
+	if _, err := runContext(t, "add", "staging", "--api-url", "https://staging.example", "--use"); err != nil {
+		t.Fatalf("context add: %v", err)
+	}
+	if err := auth.SaveToken(auth.NewToken("access", "Bearer", "refresh", 3600)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	dir, err := auth.ContextDir("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "token.json")); err != nil {
+		t.Fatalf("token not saved in the staging context: %v", err)
+	}
+
+	if _, err := runContext(t, "remove", "staging"); err != nil {
+		t.Fatalf("context remove: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("staging's credentials still exist after removing it: %v", err)
+	}
+}
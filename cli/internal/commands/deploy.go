@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -15,11 +16,13 @@ func NewDeployCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "deploy [project-id]",
 		Short: "Deploy local code to Backend.im",
-		Long:  "Deploy local code files to Backend.im. Backend.im will commit to Gitea automatically.",
+		Long:  "Deploy local code files to Backend.im. Backend.im will commit to Gitea automatically. Only delta uploads resume: with a server that supports them, an interrupted upload of 4 MB or more continues on the next deploy; otherwise the whole project is sent again.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
 			watch, _ := cmd.Flags().GetBool("watch")
 			projectDir, _ := cmd.Flags().GetString("dir")
 			projectID, _ := cmd.Flags().GetString("project")
+			transport, _ := cmd.Flags().GetString("transport")
 
 			// Get project ID from positional argument or flag
 			if projectID == "" && len(args) > 0 {
@@ -34,6 +37,10 @@ func NewDeployCommand() *cobra.Command {
 				return fmt.Errorf("project ID is required (use: deploy <project-id> or --project flag)")
 			}
 
+			if err := api.ValidateTransport(transport); err != nil {
+				return err
+			}
+
 			// Load auth token
 			token, err := auth.LoadToken()
 			if err != nil {
@@ -42,43 +49,50 @@ func NewDeployCommand() *cobra.Command {
 
 			// Read local project files
 			fmt.Printf("📂 Reading files from: %s\n", projectDir)
-			fileMap, err := files.ReadProjectFiles(projectDir)
+			project, err := files.ScanProject(projectDir)
 			if err != nil {
 				return fmt.Errorf("failed to read project files: %w", err)
 			}
 
-			if len(fileMap) == 0 {
+			if len(project.Files) == 0 {
 				return fmt.Errorf("no files found in %s", projectDir)
 			}
 
-			fmt.Printf("📦 Found %d files\n", len(fileMap))
+			fmt.Printf("📦 Found %d files (%s)\n", len(project.Files), formatBytes(project.TotalSize()))
 			fmt.Printf("📁 Project ID: %s\n", projectID)
 
 			// Create API client
 			apiClient := api.NewClient()
-			apiClient.SetAuthToken(token.AccessToken)
+			apiClient.SetToken(token)
 
 			// Deploy
 			fmt.Println("🚀 Deploying to Backend.im...")
-			deployResp, err := apiClient.Deploy(fileMap, projectID)
+			deployResp, stats, err := apiClient.DeployProject(ctx, project, projectID)
 			if err != nil {
+				reportCancelled(ctx, "Deploy cancelled before the server confirmed it - a deployment of %s may still have started", projectID)
 				return fmt.Errorf("deployment failed: %w", err)
 			}
 
+			printUploadStats(stats)
 			fmt.Printf("✅ Deployment started!\n")
 			fmt.Printf("📋 Deployment ID: %s\n", deployResp.DeploymentID)
 			fmt.Printf("🔑 Commit Hash: %s\n", deployResp.CommitHash)
 			fmt.Printf("📊 Status: %s\n", deployResp.Status)
 
 			if watch {
-				// Use WebSocket for real-time updates
-				if err := streamDeploymentUpdates(apiClient, deployResp.DeploymentID, deployResp.WebSocketURL); err != nil {
+				// Stream real-time updates over the best transport available
+				if err := streamDeploymentUpdates(ctx, apiClient, deployResp, transport); err != nil {
+					reportCancelled(ctx, "Stopped watching - deployment %s keeps running on Backend.im", deployResp.DeploymentID)
 					return fmt.Errorf("failed to stream updates: %w", err)
 				}
 			} else {
 				// Poll for deployment status until we get the URL
 				fmt.Println("⏳ Waiting for deployment to complete...")
-				url, err := pollForDeploymentURL(apiClient, deployResp.DeploymentID)
+				url, err := pollForDeploymentURL(ctx, apiClient, deployResp.DeploymentID)
+				if ctx.Err() != nil {
+					reportCancelled(ctx, "Stopped waiting - deployment %s keeps running on Backend.im", deployResp.DeploymentID)
+					return ctx.Err()
+				}
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "⚠️  Warning: Could not get deployment URL: %v\n", err)
 					fmt.Println("💡 Use --watch flag to see real-time progress")
@@ -94,18 +108,19 @@ func NewDeployCommand() *cobra.Command {
 	cmd.Flags().BoolP("watch", "w", true, "Watch deployment progress in real-time (default: true)")
 	cmd.Flags().StringP("dir", "d", "", "Project directory (default: current directory)")
 	cmd.Flags().StringP("project", "p", "", "Project ID (can also be provided as positional argument)")
+	cmd.Flags().String("transport", api.TransportAuto, "How to watch the deployment: auto, websocket, sse or longpoll (auto falls back from WebSocket to Server-Sent Events to long polling)")
 
 	return cmd
 }
 
 // pollForDeploymentURL polls the status endpoint until deployment completes and returns URL
-func pollForDeploymentURL(apiClient *api.Client, deploymentID string) (string, error) {
+func pollForDeploymentURL(ctx context.Context, apiClient *api.Client, deploymentID string) (string, error) {
 	maxAttempts := 30 // 30 attempts = ~30 seconds
 	attempt := 0
 	lastStatus := ""
 
 	for attempt < maxAttempts {
-		status, err := apiClient.GetStatus(deploymentID)
+		status, err := apiClient.GetStatus(ctx, deploymentID)
 		if err != nil {
 			return "", err
 		}
@@ -133,26 +148,21 @@ func pollForDeploymentURL(apiClient *api.Client, deploymentID string) (string, e
 		}
 
 		// Wait before next poll
-		time.Sleep(1 * time.Second)
+		if err := sleepContext(ctx, 1*time.Second); err != nil {
+			return "", err
+		}
 		attempt++
 	}
 
 	return "", fmt.Errorf("timeout waiting for deployment URL (after %d seconds)", maxAttempts)
 }
 
-// streamDeploymentUpdates connects to WebSocket and streams real-time deployment updates
-func streamDeploymentUpdates(apiClient *api.Client, deploymentID, websocketURL string) error {
-	// Always use base URL from API client for consistency (especially important in Docker)
-	// The API client's base URL is correctly configured for the environment (e.g., http://mock-api:8080)
-	// The websocketURL from the API response may contain localhost URLs that don't work in containerized environments
-	baseURL := apiClient.BaseURL()
-
-	wsClient := api.NewWebSocketClient(baseURL)
-	defer wsClient.Close()
-
-	fmt.Println("🔌 Connecting to WebSocket...")
-	if err := wsClient.Connect(deploymentID); err != nil {
-		return fmt.Errorf("failed to connect to WebSocket: %w", err)
+// streamDeploymentUpdates streams real-time deployment updates over
+// transport, or with "auto" the best one that gets through
+func streamDeploymentUpdates(ctx context.Context, apiClient *api.Client, deployResp *api.DeployResponse, transport string) error {
+	watcher, err := api.NewDeploymentWatcher(apiClient, transport, deployResp.WebSocketURL)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("👀 Streaming deployment updates...")
@@ -161,7 +171,7 @@ func streamDeploymentUpdates(apiClient *api.Client, deploymentID, websocketURL s
 	lastStatus := ""
 	var finalURL string
 
-	err := wsClient.StreamUpdates(func(update *api.DeploymentUpdate) error {
+	err = watcher.Watch(ctx, deployResp.DeploymentID, 0, func(update *api.DeploymentUpdate) error {
 		// Show status when it changes
 		if update.Status != lastStatus {
 			fmt.Printf("📊 Status: %s", update.Status)
@@ -204,9 +214,8 @@ func streamDeploymentUpdates(apiClient *api.Client, deploymentID, websocketURL s
 	})
 
 	if err != nil {
-		return fmt.Errorf("WebSocket streaming error: %w", err)
+		return fmt.Errorf("streaming error (%s): %w", watcher.Transport(), err)
 	}
 
 	return nil
 }
-
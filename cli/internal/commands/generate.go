@@ -19,6 +19,7 @@ func NewGenerateCommand() *cobra.Command {
 		Long:  "Generate FastAPI code from a prompt. Backend.im commits to Gitea automatically.",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
 			prompt := args[0]
 			projectID, _ := cmd.Flags().GetString("project")
 			outputDir, _ := cmd.Flags().GetString("output")
@@ -32,7 +33,7 @@ func NewGenerateCommand() *cobra.Command {
 
 			// Create API client
 			apiClient := api.NewClient()
-			apiClient.SetAuthToken(token.AccessToken)
+			apiClient.SetToken(token)
 
 			fmt.Printf("🚀 Generating code from prompt: %s\n", prompt)
 			if projectID != "" {
@@ -40,8 +41,9 @@ func NewGenerateCommand() *cobra.Command {
 			}
 
 			// Call Backend.im API
-			generatedFiles, err := apiClient.GenerateCode(prompt)
+			generatedFiles, err := apiClient.GenerateCode(ctx, prompt)
 			if err != nil {
+				reportCancelled(ctx, "Generation cancelled - no files were written")
 				return fmt.Errorf("failed to generate code: %w", err)
 			}
 
@@ -56,6 +58,11 @@ func NewGenerateCommand() *cobra.Command {
 				return fmt.Errorf("failed to download files: %w", err)
 			}
 
+			if ctx.Err() != nil {
+				reportCancelled(ctx, "Cancelled - generated files were written to ./%s, skipping the editor", outputDir)
+				return ctx.Err()
+			}
+
 			fmt.Printf("✅ Code generated successfully in ./%s\n", outputDir)
 			fmt.Printf("📝 Files: %d files downloaded\n", len(generatedFiles))
 			fmt.Println("")
@@ -88,4 +95,3 @@ func NewGenerateCommand() *cobra.Command {
 
 	return cmd
 }
-
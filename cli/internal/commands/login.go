@@ -1,75 +1,282 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/backend-im/cli/internal/api"
 	"github.com/backend-im/cli/internal/auth"
+	"github.com/backend-im/cli/internal/browser"
 	"github.com/spf13/cobra"
 )
 
+// browserLoginTimeout bounds how long the loopback listener waits for the redirect
+const browserLoginTimeout = 5 * time.Minute
+
+// Device codes last deviceCodeLifetime and are polled every devicePollInterval
+// when the server doesn't say (RFC 8628 sections 3.2 and 3.5)
+const (
+	deviceCodeLifetime = 5 * time.Minute
+	devicePollInterval = 5 * time.Second
+)
+
 // NewLoginCommand creates a login command (alias for auth)
 func NewLoginCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "login",
 		Short: "Login to Backend.im",
 		Long:  "Login to Backend.im using Google OAuth. Required for first-time setup.",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// Check if already authenticated
-			existingToken, err := auth.LoadToken()
-			if err == nil && existingToken != nil {
-				fmt.Println("✅ Already logged in!")
-				
-				// Verify token is still valid
-				apiClient := api.NewClient()
-				apiClient.SetAuthToken(existingToken.AccessToken)
-				verifyResp, err := apiClient.VerifyAuth()
-				if err == nil && verifyResp.Valid {
-					fmt.Printf("👤 User: %s (%s)\n", verifyResp.UserID, verifyResp.Email)
-					return nil
-				}
-				fmt.Println("⚠️  Token expired, please login again...")
-			}
-
-			fmt.Println("🔐 Logging in to Backend.im...")
-			fmt.Println("")
-			fmt.Println("For now, using mock authentication.")
-			fmt.Println("In production, this will open a browser for Google OAuth.")
-			fmt.Println("")
-
-			// Mock authentication flow (for testing with mock API)
-			// In production, this would:
-			// 1. Open browser with OAuth URL
-			// 2. Handle callback
-			// 3. Exchange code for token
-			
-			// For mock API, we'll use a simple mock token
-			mockToken := &auth.Token{
-				AccessToken: "mock_token_" + fmt.Sprintf("%d", os.Getpid()),
-				TokenType:   "Bearer",
-				ExpiresIn:   3600,
-			}
-
-			if err := auth.SaveToken(mockToken); err != nil {
-				return fmt.Errorf("failed to save token: %w", err)
-			}
-
-			fmt.Println("✅ Login successful!")
-			fmt.Printf("💾 Token saved to: ~/.backend-im/token.json\n")
-
-			// Verify the token works
-			apiClient := api.NewClient()
-			apiClient.SetAuthToken(mockToken.AccessToken)
-			verifyResp, err := apiClient.VerifyAuth()
-			if err == nil && verifyResp.Valid {
-				fmt.Printf("👤 User: %s (%s)\n", verifyResp.UserID, verifyResp.Email)
-			}
+		RunE:  runLogin,
+	}
+
+	addLoginFlags(cmd)
+
+	return cmd
+}
+
+// addLoginFlags registers the flags shared by the login and auth commands
+func addLoginFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("browser", false, "Log in through the browser instead of the device code flow")
+	cmd.Flags().String("store", "", "Credential store: 'file' (plaintext) or 'encrypted' (passphrase-protected). Switching moves an existing token")
+	cmd.Flags().Bool("with-token", false, "Read an access token (e.g. a service account token) from stdin instead of logging in interactively")
+}
 
+// runLogin is shared by the login and auth commands
+func runLogin(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	storeName, _ := cmd.Flags().GetString("store")
+	if storeName != "" {
+		store, err := auth.UseStore(storeName)
+		if err != nil {
+			return fmt.Errorf("failed to switch credential store: %w", err)
+		}
+		fmt.Printf("🔒 Using %s credential store: %s\n", store.Name(), store.Path())
+	}
+
+	if withToken, _ := cmd.Flags().GetBool("with-token"); withToken {
+		return loginWithToken(ctx, cmd.InOrStdin())
+	}
+
+	// Check if already authenticated
+	existingToken, err := auth.LoadToken()
+	if err != nil && !errors.Is(err, auth.ErrNotAuthenticated) {
+		// Don't overwrite a token we failed to read (e.g. wrong passphrase)
+		return err
+	}
+	if err == nil && existingToken != nil {
+		if existingToken.FromEnv {
+			fmt.Printf("✅ Using token from %s\n", auth.TokenEnv)
+		} else {
+			fmt.Println("✅ Already logged in!")
+		}
+
+		// Verify token is still valid
+		apiClient := api.NewClient()
+		apiClient.SetToken(existingToken)
+		verifyResp, err := apiClient.VerifyAuth(ctx)
+		if err == nil && verifyResp.Valid {
+			printUser(verifyResp)
 			return nil
-		},
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if existingToken.FromEnv {
+			return fmt.Errorf("token from %s is not valid - unset it to log in interactively", auth.TokenEnv)
+		}
+		fmt.Println("⚠️  Token expired, please login again...")
 	}
 
-	return cmd
+	contextName, _, err := auth.CurrentContext()
+	if err != nil {
+		return err
+	}
+
+	useBrowser, _ := cmd.Flags().GetBool("browser")
+
+	apiClient := api.NewClient()
+	fmt.Printf("🔐 Logging in to Backend.im (context: %s, %s)...\n", contextName, apiClient.BaseURL())
+	fmt.Println("")
+
+	var token *auth.Token
+	if useBrowser {
+		token, err = browserLogin(ctx, apiClient)
+	} else {
+		token, err = deviceLogin(ctx, apiClient)
+	}
+	if err != nil {
+		reportCancelled(ctx, "Login cancelled - no token was saved")
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	if err := saveLoginToken(token); err != nil {
+		return err
+	}
+
+	// Verify the token works
+	apiClient.SetToken(token)
+	verifyResp, err := apiClient.VerifyAuth(ctx)
+	if err == nil && verifyResp.Valid {
+		printUser(verifyResp)
+	}
+
+	return nil
+}
+
+// loginWithToken validates a token read from stdin and saves it, so CI jobs
+// can authenticate without a browser
+func loginWithToken(ctx context.Context, stdin io.Reader) error {
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read token from stdin: %w", err)
+	}
+	accessToken := strings.TrimSpace(string(data))
+	if accessToken == "" {
+		return fmt.Errorf("no token provided on stdin (e.g. echo \"$TOKEN\" | backend-im login --with-token)")
+	}
+
+	apiClient := api.NewClient()
+	apiClient.SetAuthToken(accessToken)
+	verifyResp, err := apiClient.VerifyAuth(ctx)
+	if err != nil {
+		return fmt.Errorf("token was rejected: %w", err)
+	}
+	if !verifyResp.Valid {
+		return fmt.Errorf("token was rejected: not valid")
+	}
+
+	if err := saveLoginToken(auth.NewToken(accessToken, "Bearer", "", 0)); err != nil {
+		return err
+	}
+	printUser(verifyResp)
+
+	return nil
+}
+
+func saveLoginToken(token *auth.Token) error {
+	if err := auth.SaveToken(token); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+
+	store, err := auth.ActiveStore()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("✅ Login successful!")
+	fmt.Printf("💾 Token saved to: %s\n", store.Path())
+	return nil
+}
+
+func printUser(verifyResp *api.AuthVerifyResponse) {
+	if verifyResp.IsServiceAccount() {
+		fmt.Printf("🤖 Service account: %s (%s)\n", verifyResp.UserID, verifyResp.Email)
+		return
+	}
+	fmt.Printf("👤 User: %s (%s)\n", verifyResp.UserID, verifyResp.Email)
 }
 
+// deviceLogin runs the OAuth device authorization flow: it asks the API for a
+// device code, shows the user where to approve it and polls until a token is granted
+func deviceLogin(ctx context.Context, apiClient *api.Client) (*auth.Token, error) {
+	deviceCode, err := apiClient.RequestDeviceCode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request device code: %w", err)
+	}
+
+	fmt.Printf("🌐 Open this URL in your browser: %s\n", deviceCode.VerificationURI)
+	fmt.Printf("🔑 Enter the code: %s\n", deviceCode.UserCode)
+	if deviceCode.VerificationURIComplete != "" {
+		fmt.Printf("   Or open directly: %s\n", deviceCode.VerificationURIComplete)
+	}
+	fmt.Println("")
+	fmt.Println("⏳ Waiting for approval...")
+
+	interval := time.Duration(deviceCode.Interval) * time.Second
+	if interval <= 0 {
+		interval = devicePollInterval
+	}
+	lifetime := time.Duration(deviceCode.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = deviceCodeLifetime
+	}
+	deadline := time.Now().Add(lifetime)
+
+	for time.Now().Before(deadline) {
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+
+		tokenResp, err := apiClient.PollDeviceToken(ctx, deviceCode.DeviceCode)
+		if err == nil {
+			return tokenResp.Token(), nil
+		}
+
+		var oauthErr *api.OAuthError
+		if !errors.As(err, &oauthErr) {
+			return nil, err
+		}
+
+		switch oauthErr.Code {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			// Server asked us to back off (RFC 8628 section 3.5)
+			interval += 5 * time.Second
+			continue
+		case "access_denied":
+			return nil, fmt.Errorf("authorization was denied")
+		case "expired_token":
+			return nil, fmt.Errorf("device code expired - run 'backend-im login' again")
+		default:
+			return nil, oauthErr
+		}
+	}
+
+	return nil, fmt.Errorf("timed out waiting for approval - run 'backend-im login' again")
+}
+
+// browserLogin runs the OAuth authorization code flow with PKCE: it starts a
+// loopback listener, opens the authorization URL and exchanges the returned code
+func browserLogin(ctx context.Context, apiClient *api.Client) (*auth.Token, error) {
+	pkce, err := auth.NewPKCE()
+	if err != nil {
+		return nil, err
+	}
+	state, err := auth.NewState()
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := auth.StartLoopbackServer(state)
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	authURL := apiClient.AuthorizeURL(server.RedirectURI(), state, pkce.Challenge, pkce.Method)
+
+	fmt.Println("🌐 Opening browser to complete login...")
+	if err := browser.Open(authURL); err != nil {
+		fmt.Println("⚠️  Could not open a browser automatically.")
+	}
+	fmt.Printf("   If the browser did not open, visit: %s\n", authURL)
+	fmt.Println("")
+	fmt.Println("⏳ Waiting for browser login...")
+
+	code, err := server.WaitForCode(ctx, browserLoginTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenResp, err := apiClient.ExchangeAuthCode(ctx, code, pkce.Verifier, server.RedirectURI())
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	return tokenResp.Token(), nil
+}
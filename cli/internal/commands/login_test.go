@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/api"
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+// deviceServer answers device token polls with the given OAuth error codes
+// in turn, then grants a token
+type deviceServer struct {
+	mu       sync.Mutex
+	answers  []string
+	polls    int
+	noExpiry bool // send the device code without a lifetime
+}
+
+func (s *deviceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/api/auth/device/code":
+		deviceCode := api.DeviceCodeResponse{
+			DeviceCode:      "device-1",
+			UserCode:        "ABCD-EFGH",
+			VerificationURI: "http://example.com/device",
+			ExpiresIn:       30,
+			Interval:        1,
+		}
+		if s.noExpiry {
+			deviceCode.ExpiresIn = 0
+		}
+		json.NewEncoder(w).Encode(deviceCode)
+	case "/api/auth/token":
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["device_code"] != "device-1" || req["grant_type"] != api.DeviceCodeGrantType {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(api.OAuthError{Code: "invalid_grant"})
+			return
+		}
+		s.polls++
+		if s.polls <= len(s.answers) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(api.OAuthError{Code: s.answers[s.polls-1]})
+			return
+		}
+		json.NewEncoder(w).Encode(api.TokenResponse{AccessToken: "access", TokenType: "Bearer", ExpiresIn: 3600})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDeviceLogin(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers []string
+		err     string
+	}{
+		{"approved", []string{"authorization_pending"}, ""},
+		{"denied", []string{"access_denied"}, "denied"},
+		{"expired", []string{"expired_token"}, "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &deviceServer{answers: tt.answers}
+			testenv.NewAPI(t, server)
+
+			token, err := deviceLogin(context.Background(), api.NewClient())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("deviceLogin: err = %v, want it to mention %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("deviceLogin: %v", err)
+			}
+			if token.AccessToken != "access" || token.ExpiresIn != 3600 {
+				t.Errorf("token %+v", token)
+			}
+			if server.polls != 2 {
+				t.Errorf("polled %d times, want 2", server.polls)
+			}
+		})
+	}
+}
+
+// TestDeviceLoginWithoutExpiry keeps polling for a device code sent without a
+// lifetime, rather than timing out before the first poll
+func TestDeviceLoginWithoutExpiry(t *testing.T) {
+	testenv.NewAPI(t, &deviceServer{noExpiry: true})
+
+	token, err := deviceLogin(context.Background(), api.NewClient())
+	if err != nil {
+		t.Fatalf("deviceLogin: %v", err)
+	}
+	if token.AccessToken != "access" {
+		t.Errorf("token %+v", token)
+	}
+}
+
+func TestDeviceLoginCancelled(t *testing.T) {
+	testenv.NewAPI(t, &deviceServer{})
+
+	// Ctrl-C while waiting for approval stops polling right away
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := deviceLogin(ctx, api.NewClient()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("deviceLogin: err = %v, want the context's", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("returned %s after the context ended", elapsed)
+	}
+}
+
+// verifyServer serves an API that accepts only the token valid
+func verifyServer(t *testing.T, valid string) {
+	t.Helper()
+	testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/auth/verify" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": "unauthorized", "message": "Invalid token"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(api.AuthVerifyResponse{Valid: true, UserID: "sa-1", TokenType: api.TokenTypeServiceAccount})
+	}))
+}
+
+func TestLoginWithToken(t *testing.T) {
+	verifyServer(t, "bim_sa_ci")
+
+	if err := loginWithToken(context.Background(), strings.NewReader("bim_sa_wrong\n")); err == nil {
+		t.Fatalf("a rejected token was accepted")
+	}
+	if _, err := auth.LoadToken(); !errors.Is(err, auth.ErrNotAuthenticated) {
+		t.Fatalf("a rejected token was saved: %v", err)
+	}
+	if err := loginWithToken(context.Background(), strings.NewReader("")); err == nil {
+		t.Fatalf("an empty token was accepted")
+	}
+
+	if err := loginWithToken(context.Background(), strings.NewReader("bim_sa_ci\n")); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	token, err := auth.LoadToken()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if token.AccessToken != "bim_sa_ci" || token.CanRefresh() {
+		t.Errorf("saved %+v", token)
+	}
+}
@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/backend-im/cli/internal/api"
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+// NewTokenCommand manages personal access tokens for automation
+func NewTokenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage personal access tokens",
+		Long:  "Create, list and revoke long-lived, scoped personal access tokens for automation. Use them with BACKEND_IM_TOKEN or 'login --with-token'.",
+	}
+
+	cmd.AddCommand(newTokenCreateCommand())
+	cmd.AddCommand(newTokenListCommand())
+	cmd.AddCommand(newTokenRevokeCommand())
+
+	return cmd
+}
+
+func newTokenCreateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a personal access token",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			scopes, _ := cmd.Flags().GetStringSlice("scope")
+			expires, _ := cmd.Flags().GetString("expires")
+
+			if len(scopes) == 0 {
+				return fmt.Errorf("at least one --scope is required (generate, commit, deploy)")
+			}
+			expiresIn, err := parseExpiry(expires)
+			if err != nil {
+				return err
+			}
+
+			apiClient, err := newAuthenticatedClient()
+			if err != nil {
+				return err
+			}
+
+			pat, err := apiClient.CreatePersonalAccessToken(cmd.Context(), name, scopes, expiresIn)
+			if err != nil {
+				reportCancelled(cmd.Context(), "Token creation cancelled - check 'backend-im token list' in case it was created")
+				return fmt.Errorf("failed to create token: %w", err)
+			}
+
+			fmt.Printf("✅ Created token %s (scopes: %s, expires: %s)\n", pat.ID, strings.Join(pat.Scopes, ","), formatExpiry(pat.ExpiresAt))
+			fmt.Println("")
+			fmt.Println(pat.Token)
+			fmt.Println("")
+			fmt.Println("⚠️  Copy this token now - it will not be shown again.")
+			fmt.Printf("💡 Use it with: export %s=<token>\n", auth.TokenEnv)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("name", "", "Description of what the token is for")
+	cmd.Flags().StringSlice("scope", nil, "Scope to grant: generate, commit or deploy (repeatable)")
+	cmd.Flags().String("expires", "30d", "Lifetime, e.g. 30d, 12h or 'never'")
+
+	return cmd
+}
+
+func newTokenListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List personal access tokens",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient, err := newAuthenticatedClient()
+			if err != nil {
+				return err
+			}
+
+			tokens, err := apiClient.ListPersonalAccessTokens(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to list tokens: %w", err)
+			}
+
+			if len(tokens) == 0 {
+				fmt.Println("No personal access tokens")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES")
+			for _, pat := range tokens {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					pat.ID, pat.Name, strings.Join(pat.Scopes, ","),
+					pat.CreatedAt.Local().Format("2006-01-02"), formatExpiry(pat.ExpiresAt))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newTokenRevokeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revoke a personal access token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient, err := newAuthenticatedClient()
+			if err != nil {
+				return err
+			}
+
+			if err := apiClient.RevokePersonalAccessToken(cmd.Context(), args[0]); err != nil {
+				reportCancelled(cmd.Context(), "Revoke cancelled - token %s may or may not have been revoked", args[0])
+				return fmt.Errorf("failed to revoke token: %w", err)
+			}
+
+			fmt.Printf("✅ Revoked token %s\n", args[0])
+			return nil
+		},
+	}
+}
+
+// newAuthenticatedClient returns an API client using the saved token
+func newAuthenticatedClient() (*api.Client, error) {
+	token, err := auth.LoadToken()
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %w\nRun 'backend-im login' first", err)
+	}
+
+	apiClient := api.NewClient()
+	apiClient.SetToken(token)
+	return apiClient, nil
+}
+
+// parseExpiry parses token lifetimes such as "30d", "12h" or "never". The API
+// takes whole seconds, where 0 means never, so shorter lifetimes are refused.
+func parseExpiry(value string) (time.Duration, error) {
+	if value == "" || value == "never" || value == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid --expires %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid --expires %q (use e.g. 30d, 12h or never)", value)
+	}
+	return d, nil
+}
+
+func formatExpiry(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return "never"
+	}
+	return expiresAt.Local().Format("2006-01-02")
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/backend-im/cli/internal/testenv"
+)
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"never", 0, true},
+		{"", 0, true},
+		{"30d", 30 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"500ms", 0, false},
+		{"1s", time.Second, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.value)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseExpiry(%q) = %s, %v; want %s, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTokenCommands(t *testing.T) {
+	var created map[string]interface{}
+	var revoked string
+	testenv.NewAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/tokens":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "tok_1", "token": "bim_pat_secret", "name": "ci", "scopes": ["deploy"], "createdAt": "2024-01-01T00:00:00Z"}`))
+		case r.Method == "DELETE":
+			revoked = r.URL.EscapedPath()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Setenv(auth.TokenEnv, "user-token")
+
+	run := func(args ...string) error {
+		cmd := NewTokenCommand()
+		cmd.SetArgs(args)
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(io.Discard)
+		return cmd.ExecuteContext(context.Background())
+	}
+
+	if err := run("create", "--name", "ci"); err == nil {
+		t.Errorf("created a token without a scope")
+	}
+	if err := run("create", "--name", "ci", "--scope", "deploy", "--expires", "7d"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created["name"] != "ci" || created["expiresIn"] != float64(7*24*60*60) {
+		t.Errorf("create sent %v", created)
+	}
+	if scopes, _ := created["scopes"].([]interface{}); len(scopes) != 1 || scopes[0] != "deploy" {
+		t.Errorf("create sent scopes %v", created["scopes"])
+	}
+
+	if err := run("revoke", "tok/1"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if revoked != "/api/tokens/tok%2F1" {
+		t.Errorf("revoke sent DELETE %s, want the ID escaped", revoked)
+	}
+}
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/backend-im/cli/internal/api"
+)
+
+// printUploadStats reports how much of the project was sent
+func printUploadStats(stats *api.UploadStats) {
+	switch {
+	case stats.Method != api.UploadDelta:
+		fmt.Printf("📤 Uploaded %s (%s)\n", formatBytes(stats.SentBytes), stats.Method)
+	case stats.ChangedFiles == 0:
+		fmt.Printf("📤 No file contents to upload - the server already has them all (%s saved)\n", formatBytes(stats.SavedBytes()))
+	default:
+		fmt.Printf("📤 Uploaded %s of %s: %d of %d files changed (%s saved)\n",
+			formatBytes(stats.SentBytes), formatBytes(stats.TotalBytes),
+			stats.ChangedFiles, stats.Files, formatBytes(stats.SavedBytes()))
+	}
+}
+
+// formatBytes renders a byte count for humans, e.g. "1.5 MB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/backend-im/cli/internal/api"
+	"github.com/backend-im/cli/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+// NewWhoamiCommand shows the current identity (same as 'auth status')
+func NewWhoamiCommand() *cobra.Command {
+	cmd := newAuthStatusCommand()
+	cmd.Use = "whoami"
+	cmd.Short = "Show the current identity (same as 'auth status')"
+	return cmd
+}
+
+func newAuthStatusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the current identity and token status",
+		Long:  "Verify the saved token with Backend.im and show who you are logged in as. Exits non-zero if the token is missing or invalid.",
+		Args:  cobra.NoArgs,
+		RunE:  runAuthStatus,
+		// An invalid token is an expected outcome here, not a usage error
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+}
+
+func runAuthStatus(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	contextName, _, err := auth.CurrentContext()
+	if err != nil {
+		return err
+	}
+
+	apiClient := api.NewClient()
+	fmt.Printf("📁 Context: %s\n", contextName)
+	fmt.Printf("🌐 API URL: %s\n", apiClient.BaseURL())
+
+	token, err := auth.LoadToken()
+	if err != nil {
+		fmt.Println("❌ Not logged in")
+		return err
+	}
+
+	if token.FromEnv {
+		fmt.Printf("💾 Token source: %s\n", auth.TokenEnv)
+	} else if store, err := auth.ActiveStore(); err == nil {
+		fmt.Printf("💾 Token source: %s\n", store.Path())
+	}
+
+	apiClient.SetToken(token)
+	verifyResp, err := apiClient.VerifyAuth(ctx)
+	if err != nil {
+		fmt.Println("❌ Token is not valid")
+		return fmt.Errorf("token verification failed: %w\nRun 'backend-im login' again", err)
+	}
+	if !verifyResp.Valid {
+		fmt.Println("❌ Token is not valid")
+		return fmt.Errorf("token is not valid - run 'backend-im login' again")
+	}
+
+	// VerifyAuth may have refreshed the token
+	token = apiClient.Token()
+
+	printUser(verifyResp)
+
+	tokenType := verifyResp.TokenType
+	if tokenType == "" {
+		tokenType = api.TokenTypeUser
+	}
+	fmt.Printf("🔑 Token type: %s\n", tokenType)
+	if len(verifyResp.Scopes) > 0 {
+		fmt.Printf("🎯 Scopes: %s\n", strings.Join(verifyResp.Scopes, ", "))
+	}
+
+	expiresAt := token.ExpiresAt
+	if !verifyResp.ExpiresAt.IsZero() {
+		expiresAt = verifyResp.ExpiresAt
+	}
+	if expiresAt.IsZero() {
+		fmt.Println("⏰ Expires: unknown")
+	} else {
+		remaining := time.Until(expiresAt).Round(time.Second)
+		fmt.Printf("⏰ Expires: %s (in %s)\n", expiresAt.Local().Format(time.RFC1123), remaining)
+	}
+	if token.CanRefresh() {
+		fmt.Println("🔄 Refreshes automatically")
+	}
+	if server := apiClient.ServerInfo(ctx); server != nil {
+		fmt.Printf("🖥️  Server: version %s (API version %d)\n", server.Version, server.APIVersion)
+	}
+	if rateLimit := apiClient.RateLimit(); rateLimit != nil {
+		fmt.Printf("📊 API quota: %d of %d requests left", rateLimit.Remaining, rateLimit.Limit)
+		if !rateLimit.Reset.IsZero() {
+			fmt.Printf(" (resets in %s)", time.Until(rateLimit.Reset).Round(time.Second))
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
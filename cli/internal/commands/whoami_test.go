@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/backend-im/cli/internal/auth"
+)
+
+// runWhoami runs 'whoami' and returns what it printed
+func runWhoami(t *testing.T) (string, error) {
+	t.Helper()
+	return captureStdout(t, func() error {
+		cmd := NewWhoamiCommand()
+		cmd.SetArgs(nil)
+		return cmd.ExecuteContext(context.Background())
+	})
+}
+
+func TestWhoami(t *testing.T) {
+	verifyServer(t, "bim_sa_ci")
+
+	if _, err := runWhoami(t); !errors.Is(err, auth.ErrNotAuthenticated) {
+		t.Errorf("whoami without a token: err = %v, want ErrNotAuthenticated", err)
+	}
+
+	t.Setenv(auth.TokenEnv, "bim_sa_wrong")
+	if output, err := runWhoami(t); err == nil || !strings.Contains(output, "not valid") {
+		t.Errorf("whoami with a rejected token: err = %v, output:\n%s", err, output)
+	}
+
+	t.Setenv(auth.TokenEnv, "bim_sa_ci")
+	output, err := runWhoami(t)
+	if err != nil {
+		t.Fatalf("whoami: %v", err)
+	}
+	for _, want := range []string{"Service account: sa-1", "Token type: service_account", "Token source: " + auth.TokenEnv} {
+		if !strings.Contains(output, want) {
+			t.Errorf("whoami output lacks %q:\n%s", want, output)
+		}
+	}
+}
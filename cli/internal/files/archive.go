@@ -0,0 +1,169 @@
+package files
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Project is the set of files in a project directory that get uploaded,
+// found without reading their contents
+type Project struct {
+	Dir   string
+	Files []ProjectFile
+}
+
+// ProjectFile is one file of a Project
+type ProjectFile struct {
+	Path string // relative to the project directory, with forward slashes
+	Size int64
+	Mode os.FileMode
+	Hash string // hex SHA-256 of the contents, set by HashFiles
+}
+
+// ScanProject lists the files ReadProjectFiles would read, applying the same
+// ignore rules
+func ScanProject(projectDir string) (*Project, error) {
+	project := &Project{Dir: projectDir}
+
+	err := walkProject(projectDir, func(relPath string, info os.FileInfo) error {
+		project.Files = append(project.Files, ProjectFile{
+			Path: filepath.ToSlash(relPath),
+			Size: info.Size(),
+			Mode: info.Mode().Perm(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// StateDir is where the CLI keeps per-project state, such as interrupted
+// uploads. It is never uploaded itself.
+func (p *Project) StateDir() string {
+	return filepath.Join(p.Dir, ".backend-im")
+}
+
+// TotalSize is the combined size of the project's files in bytes
+func (p *Project) TotalSize() int64 {
+	var total int64
+	for _, f := range p.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// ReadFiles loads every file into memory, keyed by path
+func (p *Project) ReadFiles() (map[string]string, error) {
+	files := make(map[string]string, len(p.Files))
+	for _, f := range p.Files {
+		content, err := os.ReadFile(p.path(f))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", f.Path, err)
+		}
+		files[f.Path] = string(content)
+	}
+	return files, nil
+}
+
+// HashFiles computes the SHA-256 of every file, for content-addressed uploads
+func (p *Project) HashFiles() error {
+	for i := range p.Files {
+		f := &p.Files[i]
+		file, err := os.Open(p.path(*f))
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", f.Path, err)
+		}
+
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", f.Path, err)
+		}
+		f.Hash = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return nil
+}
+
+// WriteArchive streams the project to w as a gzip-compressed tar, reading one
+// file at a time so the project is never held in memory
+func (p *Project) WriteArchive(w io.Writer) error {
+	return p.writeArchive(w, p.Files, func(f ProjectFile) string { return f.Path })
+}
+
+// WriteBlobArchive is like WriteArchive, but only includes files whose hash
+// is in hashes, once per distinct content, with each entry named by its hash.
+// HashFiles must have been called first.
+func (p *Project) WriteBlobArchive(w io.Writer, hashes map[string]bool) error {
+	var blobs []ProjectFile
+	seen := make(map[string]bool)
+	for _, f := range p.Files {
+		if hashes[f.Hash] && !seen[f.Hash] {
+			seen[f.Hash] = true
+			blobs = append(blobs, f)
+		}
+	}
+
+	return p.writeArchive(w, blobs, func(f ProjectFile) string { return f.Hash })
+}
+
+func (p *Project) writeArchive(w io.Writer, files []ProjectFile, name func(ProjectFile) string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range files {
+		if err := p.writeArchiveEntry(tw, f, name(f)); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return gz.Close()
+}
+
+func (p *Project) writeArchiveEntry(tw *tar.Writer, f ProjectFile, name string) error {
+	file, err := os.Open(p.path(f))
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", f.Path, err)
+	}
+	defer file.Close()
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     f.Size,
+		Mode:     int64(f.Mode),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", f.Path, err)
+	}
+
+	// The header promised Size bytes, so a file that shrank or grew since the
+	// scan can't be archived consistently
+	if _, err := io.CopyN(tw, file, f.Size); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("file %s changed while uploading", f.Path)
+		}
+		return fmt.Errorf("failed to archive %s: %w", f.Path, err)
+	}
+	if n, _ := file.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("file %s changed while uploading", f.Path)
+	}
+
+	return nil
+}
+
+func (p *Project) path(f ProjectFile) string {
+	return filepath.Join(p.Dir, filepath.FromSlash(f.Path))
+}
@@ -29,9 +29,17 @@ func DownloadFiles(files map[string]string, projectDir string) error {
 }
 
 func ReadProjectFiles(projectDir string) (map[string]string, error) {
-	files := make(map[string]string)
+	project, err := ScanProject(projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return project.ReadFiles()
+}
 
-	err := filepath.Walk(projectDir, func(path string, info os.FileInfo, err error) error {
+// walkProject calls fn for every file in projectDir that should be uploaded
+func walkProject(projectDir string, fn func(relPath string, info os.FileInfo) error) error {
+	return filepath.Walk(projectDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -52,16 +60,22 @@ func ReadProjectFiles(projectDir string) (map[string]string, error) {
 			return nil
 		}
 
-		content, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read file %s: %w", path, err)
+		// Symlinks are uploaded as the file they point to, so the size and
+		// mode must be the target's. Walk doesn't descend into linked
+		// directories, so those are skipped.
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to read file %s: %w", relPath, err)
+			}
+			if target.IsDir() {
+				return nil
+			}
+			info = target
 		}
 
-		files[relPath] = string(content)
-		return nil
+		return fn(relPath, info)
 	})
-
-	return files, err
 }
 
 func shouldIgnore(path string) bool {
@@ -89,4 +103,3 @@ func shouldIgnore(path string) bool {
 
 	return false
 }
-
@@ -0,0 +1,50 @@
+// Package testenv sets up the environment the CLI's tests run in: a home
+// directory of their own and a fake Backend.im API.
+package testenv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Isolate gives the test a fresh home directory, so it neither reads nor
+// changes the user's saved config and credentials, and returns it. It also
+// clears the variables that would pick another API, context or token; they
+// are spelled out as the auth package's own tests can't import
+// auth.ContextEnv and auth.TokenEnv from here.
+func Isolate(t testing.TB) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("BACKEND_IM_API_URL", "")
+	t.Setenv("BACKEND_IM_CONTEXT", "")
+	t.Setenv("BACKEND_IM_TOKEN", "")
+	return home
+}
+
+// NewAPI starts handler as the Backend.im API for the rest of the test and
+// points the CLI at it, in an isolated home directory. The API predates the
+// version handshake: GET /api/version is answered with 404, so the client
+// tries every feature instead of relying on advertised capabilities.
+func NewAPI(t testing.TB, handler http.Handler) *httptest.Server {
+	t.Helper()
+	return NewVersionedAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/version" {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// NewVersionedAPI is NewAPI for a handler that answers the version handshake
+// itself
+func NewVersionedAPI(t testing.TB, handler http.Handler) *httptest.Server {
+	t.Helper()
+	Isolate(t)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	t.Setenv("BACKEND_IM_API_URL", server.URL)
+	return server
+}
@@ -5,11 +5,15 @@ set -e
 
 echo "🔨 Building Backend.im CLI binary..."
 
-# Build binary using Docker (no local Go installation needed)
-cd "$(dirname "$0")/cli"
-docker run --rm -v "$(pwd)":/app -w /app golang:1.21-alpine sh -c \
+# Build binary using Docker (no local Go installation needed). The whole
+# repository is mounted because cli/go.mod replaces the API contract module
+# with ../api
+cd "$(dirname "$0")"
+docker run --rm -v "$(pwd)":/app -w /app/cli golang:1.21-alpine sh -c \
   "go mod download && CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o backend-im ./cmd/backend-im"
 
+cd cli
+
 # Fix permissions
 sudo chown $USER:$USER backend-im 2>/dev/null || chown $USER:$USER backend-im
 chmod +x backend-im
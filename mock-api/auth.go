@@ -0,0 +1,448 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Authorization codes issued by /api/auth/authorize, keyed by code
+type authorizationCode struct {
+	RedirectURI   string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+var (
+	authCodesMu sync.Mutex
+	authCodes   = make(map[string]*authorizationCode)
+)
+
+// GET /api/auth/authorize - Browser authorization endpoint (PKCE required).
+// The mock skips the consent screen and redirects straight back to the CLI;
+// pass deny=1 to simulate the user cancelling.
+func mockAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	state := query.Get("state")
+
+	redirect, err := url.Parse(redirectURI)
+	if err != nil || redirect.Scheme != "http" || (redirect.Hostname() != "127.0.0.1" && redirect.Hostname() != "localhost") {
+		writeError(w, http.StatusBadRequest, "redirect_uri must be a loopback address")
+		return
+	}
+
+	params := url.Values{}
+	params.Set("state", state)
+
+	switch {
+	case query.Get("deny") != "":
+		params.Set("error", "access_denied")
+	case query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256":
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "PKCE with S256 is required")
+	default:
+		code := uuid.New().String()
+		authCodesMu.Lock()
+		authCodes[code] = &authorizationCode{
+			RedirectURI:   redirectURI,
+			CodeChallenge: query.Get("code_challenge"),
+			ExpiresAt:     time.Now().Add(time.Minute),
+		}
+		authCodesMu.Unlock()
+		params.Set("code", code)
+	}
+
+	http.Redirect(w, r, redirectURI+"?"+params.Encode(), http.StatusFound)
+}
+
+// GET /api/auth/callback?code=...&code_verifier=... - Exchanges an authorization code for a token
+func mockAuthCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	code := query.Get("code")
+	if code == "" {
+		writeOAuthError(w, "invalid_request", "Missing authorization code")
+		return
+	}
+
+	// Codes are single use
+	authCodesMu.Lock()
+	issued, ok := authCodes[code]
+	delete(authCodes, code)
+	authCodesMu.Unlock()
+
+	if !ok || time.Now().After(issued.ExpiresAt) {
+		writeOAuthError(w, "invalid_grant", "Unknown or expired authorization code")
+		return
+	}
+	if query.Get("redirect_uri") != issued.RedirectURI {
+		writeOAuthError(w, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(query.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != issued.CodeChallenge {
+		writeOAuthError(w, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	// Return mock JWT token
+	writeTokenResponse(w)
+}
+
+func mockVerifyAuth(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing authorization header")
+		return
+	}
+	if rejectInvalidToken(w, r) {
+		return
+	}
+
+	// Simple token verification (in real app, would validate JWT)
+	var response map[string]interface{}
+	switch accessToken := bearerToken(r); {
+	case strings.HasPrefix(accessToken, serviceAccountPrefix):
+		response = map[string]interface{}{
+			"valid":     true,
+			"userId":    "sa-ci",
+			"email":     "ci@service.backend.im",
+			"tokenType": "service_account",
+		}
+	case strings.HasPrefix(accessToken, patPrefix):
+		pat, ok := lookupPAT(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		response = map[string]interface{}{
+			"valid":     true,
+			"userId":    pat.UserID,
+			"email":     "user@example.com",
+			"tokenType": "personal_access_token",
+			"scopes":    pat.Scopes,
+		}
+		if pat.ExpiresAt != nil {
+			response["expiresAt"] = pat.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+	case strings.HasPrefix(accessToken, "mock_token_"):
+		response = map[string]interface{}{
+			"valid":     true,
+			"userId":    "user123",
+			"email":     "user@example.com",
+			"tokenType": "user",
+		}
+	default:
+		writeError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	tokensMu.Lock()
+	if issued, known := accessTokens[bearerToken(r)]; known {
+		response["expiresAt"] = issued.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	tokensMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Device authorization state (RFC 8628), keyed by device code
+type deviceAuthorization struct {
+	UserCode  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Approved  bool
+}
+
+var (
+	deviceMu     sync.Mutex
+	deviceCodes  = make(map[string]*deviceAuthorization)
+	deviceByUser = make(map[string]string) // user code -> device code
+)
+
+const (
+	deviceCodeTTL      = 10 * time.Minute
+	deviceAutoApprove  = 6 * time.Second // Simulate the user approving in a browser
+	devicePollInterval = 2
+)
+
+// POST /api/auth/device/code - Starts a device authorization flow
+func mockDeviceCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	deviceCode := uuid.New().String()
+	raw := strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", ""))
+	userCode := raw[:4] + "-" + raw[4:8]
+
+	deviceMu.Lock()
+	deviceCodes[deviceCode] = &deviceAuthorization{
+		UserCode:  userCode,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(deviceCodeTTL),
+	}
+	deviceByUser[userCode] = deviceCode
+	deviceMu.Unlock()
+
+	verificationURI := fmt.Sprintf("http://%s/device", r.Host)
+	response := map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + userCode,
+		"expires_in":                int(deviceCodeTTL.Seconds()),
+		"interval":                  devicePollInterval,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GET /device?user_code={code} - Verification page; visiting it approves the device
+func mockDeviceVerify(w http.ResponseWriter, r *http.Request) {
+	userCode := strings.ToUpper(r.URL.Query().Get("user_code"))
+	if userCode == "" {
+		fmt.Fprintln(w, "Mock Backend.im device login: open /device?user_code=XXXX-XXXX to approve")
+		return
+	}
+
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+
+	deviceCode, ok := deviceByUser[userCode]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown user code")
+		return
+	}
+	deviceCodes[deviceCode].Approved = true
+	fmt.Fprintln(w, "✅ Device approved - you can return to the CLI")
+}
+
+// POST /api/auth/token - OAuth token endpoint
+func mockToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		GrantType    string `json:"grant_type"`
+		DeviceCode   string `json:"device_code"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOAuthError(w, "invalid_request", "Invalid request body")
+		return
+	}
+
+	switch req.GrantType {
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		deviceMu.Lock()
+		auth, ok := deviceCodes[req.DeviceCode]
+		if !ok {
+			deviceMu.Unlock()
+			writeOAuthError(w, "invalid_grant", "Unknown device code")
+			return
+		}
+		if time.Now().After(auth.ExpiresAt) {
+			delete(deviceCodes, req.DeviceCode)
+			delete(deviceByUser, auth.UserCode)
+			deviceMu.Unlock()
+			writeOAuthError(w, "expired_token", "Device code expired")
+			return
+		}
+		if !auth.Approved && time.Since(auth.CreatedAt) < deviceAutoApprove {
+			deviceMu.Unlock()
+			writeOAuthError(w, "authorization_pending", "")
+			return
+		}
+		delete(deviceCodes, req.DeviceCode)
+		delete(deviceByUser, auth.UserCode)
+		deviceMu.Unlock()
+	case "refresh_token":
+		// Refresh tokens are single use - each refresh rotates it
+		tokensMu.Lock()
+		_, valid := refreshTokens[req.RefreshToken]
+		delete(refreshTokens, req.RefreshToken)
+		tokensMu.Unlock()
+		if !valid {
+			writeOAuthError(w, "invalid_grant", "Unknown or already used refresh token")
+			return
+		}
+	default:
+		writeOAuthError(w, "unsupported_grant_type", req.GrantType)
+		return
+	}
+
+	writeTokenResponse(w)
+}
+
+// Issued tokens, so expiry and revocation can be enforced and refresh tokens rotated
+type issuedToken struct {
+	UserID    string
+	ExpiresAt time.Time
+}
+
+// mockUserID owns every interactive login on the mock server
+const mockUserID = "user123"
+
+var (
+	tokensMu      sync.Mutex
+	accessTokens  = make(map[string]*issuedToken)
+	refreshTokens = make(map[string]string) // refresh token -> user ID
+	revokedTokens = make(map[string]bool)
+)
+
+// accessTokenTTL can be shortened with MOCK_TOKEN_TTL (seconds) to exercise refresh
+var accessTokenTTL = func() time.Duration {
+	if ttl, err := strconv.Atoi(os.Getenv("MOCK_TOKEN_TTL")); err == nil && ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return time.Hour
+}()
+
+func writeTokenResponse(w http.ResponseWriter) {
+	accessToken := "mock_token_" + uuid.New().String()
+	refreshToken := "mock_refresh_" + uuid.New().String()
+
+	tokensMu.Lock()
+	accessTokens[accessToken] = &issuedToken{UserID: mockUserID, ExpiresAt: time.Now().Add(accessTokenTTL)}
+	refreshTokens[refreshToken] = mockUserID
+	tokensMu.Unlock()
+
+	response := map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Any token with this prefix is accepted as a CI service account token
+const serviceAccountPrefix = "bim_sa_"
+
+// bearerToken extracts the token from the Authorization header
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// rejectInvalidToken writes a 401 and returns true if the request carries a
+// revoked token, or an access token this server issued that has since expired.
+// Tokens it does not know about (e.g. from before a restart) are accepted.
+func rejectInvalidToken(w http.ResponseWriter, r *http.Request) bool {
+	accessToken := bearerToken(r)
+
+	tokensMu.Lock()
+	issued, known := accessTokens[accessToken]
+	revoked := revokedTokens[accessToken]
+	tokensMu.Unlock()
+
+	if revoked {
+		writeError(w, http.StatusUnauthorized, "Token revoked")
+		return true
+	}
+	if known && time.Now().After(issued.ExpiresAt) {
+		writeError(w, http.StatusUnauthorized, "Token expired")
+		return true
+	}
+	if pat, isPAT := lookupPAT(r); isPAT && pat.ExpiresAt != nil && time.Now().After(*pat.ExpiresAt) {
+		writeError(w, http.StatusUnauthorized, "Token expired")
+		return true
+	}
+	return false
+}
+
+// revokeToken marks an access or refresh token as revoked. Caller holds tokensMu.
+func revokeToken(token string) {
+	delete(accessTokens, token)
+	delete(refreshTokens, token)
+	revokedTokens[token] = true
+}
+
+// POST /api/auth/revoke - Revokes a single access or refresh token (RFC 7009)
+func mockRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	tokensMu.Lock()
+	revokeToken(req.Token)
+	tokensMu.Unlock()
+
+	// RFC 7009: respond 200 even if the token was unknown or already revoked
+	w.WriteHeader(http.StatusOK)
+}
+
+// DELETE /api/auth/sessions - Revokes every token belonging to the caller
+func mockRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !requireInteractiveToken(w, r, "revoke sessions") {
+		return
+	}
+
+	userID := mockUserID
+	if strings.HasPrefix(bearerToken(r), serviceAccountPrefix) {
+		userID = "sa-ci"
+	}
+
+	tokensMu.Lock()
+	revoked := 0
+	for token, issued := range accessTokens {
+		if issued.UserID == userID {
+			revokeToken(token)
+			revoked++
+		}
+	}
+	for token, owner := range refreshTokens {
+		if owner == userID {
+			revokeToken(token)
+			revoked++
+		}
+	}
+	// The caller's own token may predate a restart, so revoke it explicitly
+	revokeToken(bearerToken(r))
+	tokensMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revoked": revoked})
+}
+
+func writeOAuthError(w http.ResponseWriter, code, description string) {
+	response := map[string]string{"error": code}
+	if description != "" {
+		response["error_description"] = description
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+}
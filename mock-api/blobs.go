@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// deltaDisabled makes the mock behave like a server without a blob store
+// (MOCK_DISABLE_DELTA=1), to exercise the CLI's fallback to full uploads
+var deltaDisabled = os.Getenv("MOCK_DISABLE_DELTA") == "1"
+
+// Content-addressed blob store: file contents keyed by hex SHA-256. Real
+// servers would scope blobs per account; the mock only has one user.
+var (
+	blobsMu sync.Mutex
+	blobs   = make(map[string][]byte)
+)
+
+// POST /api/blobs/missing - Returns which of the given hashes aren't stored
+func mockMissingBlobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if rejectInvalidToken(w, r) {
+		return
+	}
+	if rejectMissingScope(w, r, "deploy", "commit") {
+		return
+	}
+
+	var req struct {
+		Hashes []string `json:"hashes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	missing := []string{}
+	seen := make(map[string]bool)
+	blobsMu.Lock()
+	for _, hash := range req.Hashes {
+		if _, ok := blobs[hash]; !ok && !seen[hash] {
+			seen[hash] = true
+			missing = append(missing, hash)
+		}
+	}
+	blobsMu.Unlock()
+
+	log.Printf("Blob negotiation: %d hashes, %d missing", len(req.Hashes), len(missing))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"missing": missing})
+}
+
+// PUT /api/blobs - Stores a tar.gz of blobs, each entry named by its hash
+func mockUploadBlobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if rejectInvalidToken(w, r) {
+		return
+	}
+	if rejectMissingScope(w, r, "deploy", "commit") {
+		return
+	}
+
+	received, err := readBlobArchive(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid blob archive: %v", err))
+		return
+	}
+
+	var bytes int
+	blobsMu.Lock()
+	for hash, content := range received {
+		blobs[hash] = content
+		bytes += len(content)
+	}
+	blobsMu.Unlock()
+
+	log.Printf("Stored %d blobs, %d bytes", len(received), bytes)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readBlobArchive unpacks a blob archive, checking every entry's contents
+// against the hash it is named by
+func readBlobArchive(body io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("archive is not gzip: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	received := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return received, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %w", err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %w", err)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != header.Name {
+			return nil, fmt.Errorf("contents of %s do not match its hash", header.Name)
+		}
+		received[header.Name] = content
+	}
+}
+
+// resolveManifest turns a path -> hash manifest into file contents, returning
+// the hashes that aren't stored
+func resolveManifest(manifest map[string]string) (map[string]string, []string) {
+	blobsMu.Lock()
+	defer blobsMu.Unlock()
+
+	files := make(map[string]string, len(manifest))
+	var missing []string
+	for path, hash := range manifest {
+		content, ok := blobs[hash]
+		if !ok {
+			missing = append(missing, hash)
+			continue
+		}
+		files[path] = string(content)
+	}
+	return files, missing
+}
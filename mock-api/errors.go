@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorCodes maps statuses to the machine-readable code sent when a handler
+// doesn't pick a more specific one
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "gateway_timeout",
+}
+
+// writeError sends the API's JSON error envelope with a code derived from the status
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorDetails(w, status, errorCodes[status], message, nil)
+}
+
+// writeErrorDetails sends the API's JSON error envelope:
+//
+//	{"error": {"code": "...", "message": "...", "details": {...}, "requestId": "..."}}
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	if code == "" {
+		code = "error"
+	}
+	body := map[string]interface{}{
+		"code":      code,
+		"message":   message,
+		"requestId": w.Header().Get("X-Request-ID"),
+	}
+	if details != nil {
+		body["details"] = details
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
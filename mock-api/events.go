@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+)
+
+// wsDropAfter simulates flaky connections: with MOCK_WS_DROP_AFTER=n the
+// /ws handler drops every connection without a close frame after sending
+// n updates, so clients have to reconnect and resume with ?since=
+var wsDropAfter = envInt("MOCK_WS_DROP_AFTER", 0)
+
+// wsStallAfter simulates half-open connections: with MOCK_WS_STALL_AFTER=n
+// the /ws handler goes silent after sending n updates on a connection - no
+// more updates, pings unanswered - until the client gives up on it
+var wsStallAfter = envInt("MOCK_WS_STALL_AFTER", 0)
+
+// buildStall (MOCK_STAGE_STALL, seconds) makes the building stage run that
+// much longer without output, to exercise heartbeats and stage timeouts
+var buildStall = time.Duration(envInt("MOCK_STAGE_STALL", 0)) * time.Second
+
+// deploymentEvent is one update in a deployment's history, as sent on /ws
+type deploymentEvent struct {
+	Seq          int      `json:"seq"`
+	DeploymentID string   `json:"deploymentId"`
+	ProjectID    string   `json:"projectId"`  // Required: identifies the project
+	CommitHash   string   `json:"commitHash"` // Required: orchestrator uses this to pull from Gitea
+	Status       string   `json:"status"`
+	URL          string   `json:"url,omitempty"`
+	Logs         []string `json:"logs"`
+}
+
+func (e deploymentEvent) final() bool {
+	return e.Status == "complete" || e.Status == "failed"
+}
+
+// runDeployment plays the deployment's stages into its event buffer, one
+// every stageDelay, whether or not anyone is watching
+func runDeployment(deploymentID string, d *deployment) {
+	// Orchestrator combines projectId + commitHash for the namespace and
+	// PVC: {projectId}-{commitHash}. Same project ID, different commit =
+	// update deployment.
+	stages := []deploymentEvent{
+		{Status: "committing", Logs: []string{"📦 Committing files to repository..."}},
+		{Status: "creating_namespace", Logs: []string{"🏗️ Creating Kubernetes namespace..."}},
+		{Status: "creating_pvc", Logs: []string{"💾 Creating Persistent Volume Claim..."}},
+		{Status: "building", Logs: []string{"🔨 Building container image..."}},
+		{Status: "deploying", Logs: []string{"🚀 Deploying application..."}},
+		{Status: "complete", URL: deploymentURL(deploymentID), Logs: []string{"✅ Deployment complete!"}},
+	}
+
+	for i, event := range stages {
+		if i > 0 {
+			time.Sleep(stageDelay)
+		}
+		if event.Status == "deploying" {
+			time.Sleep(buildStall)
+		}
+		event.DeploymentID = deploymentID
+		event.ProjectID = d.ProjectID
+		event.CommitHash = d.CommitHash
+		d.publish(event)
+	}
+}
+
+// publish numbers event and appends it to the buffer, waking up streams
+// waiting for it
+func (d *deployment) publish(event deploymentEvent) {
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	event.Seq = len(d.events) + 1
+	d.events = append(d.events, event)
+	close(d.updated)
+	d.updated = make(chan struct{})
+}
+
+// eventsSince returns the buffered events after sequence number since, and
+// a channel closed when the next one is published
+func (d *deployment) eventsSince(since int) ([]deploymentEvent, <-chan struct{}) {
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	if since >= len(d.events) {
+		return nil, d.updated
+	}
+	return append([]deploymentEvent(nil), d.events[since:]...), d.updated
+}
+
+// latest returns the last published event, if there is one yet
+func (d *deployment) latest() (deploymentEvent, bool) {
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	if len(d.events) == 0 {
+		return deploymentEvent{}, false
+	}
+	return d.events[len(d.events)-1], true
+}
+
+// finished reports whether the deployment's final update was published
+func (d *deployment) finished() bool {
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	return len(d.events) > 0 && d.events[len(d.events)-1].final()
+}
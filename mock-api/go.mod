@@ -3,7 +3,11 @@ module mock-api
 go 1.21
 
 require (
+	github.com/backend-im/openapi v0.0.0
 	github.com/google/uuid v1.5.0
 	github.com/gorilla/websocket v1.5.1
 )
 
+require golang.org/x/net v0.17.0 // indirect
+
+replace github.com/backend-im/openapi => ../api
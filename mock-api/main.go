@@ -7,6 +7,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,23 +20,69 @@ import (
 var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 
 func main() {
-	http.HandleFunc("/api/generate", mockGenerate)
-	http.HandleFunc("/api/deploy", mockDeploy)
-	http.HandleFunc("/api/commit", mockCommit)
-	http.HandleFunc("/api/auth/callback", mockAuthCallback)
-	http.HandleFunc("/api/auth/verify", mockVerifyAuth)
-	http.HandleFunc("/api/status/", mockStatus)
-	http.HandleFunc("/ws", mockWebSocket)
-
-	fmt.Println("🚀 Mock Backend.im API running on :8080")
-	fmt.Println("📡 WebSocket endpoint: ws://localhost:8080/ws")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	scheme := "http"
+	if tlsEnabled {
+		scheme = "https"
+	}
+	fmt.Printf("🚀 Mock Backend.im API running on %s://localhost:8080\n", scheme)
+	fmt.Printf("📡 WebSocket endpoint: %s://localhost:8080/ws\n", wsScheme())
+	log.Fatal(listenAndServe(":8080", newHandler()))
 }
 
+// newHandler registers the mock's routes. Every route is described in
+// api/openapi.json, which openapi_test.go checks the responses against.
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+	if !versionDisabled {
+		mux.HandleFunc("/api/version", mockVersion)
+	}
+	mux.HandleFunc("/api/generate", flaky(mockGenerate))
+	mux.HandleFunc("/api/deploy", flaky(idempotent(mockDeploy)))
+	mux.HandleFunc("/api/commit", flaky(idempotent(mockCommit)))
+	mux.HandleFunc("/api/auth/authorize", mockAuthorize)
+	mux.HandleFunc("/api/auth/callback", mockAuthCallback)
+	mux.HandleFunc("/api/auth/verify", mockVerifyAuth)
+	mux.HandleFunc("/api/auth/device/code", mockDeviceCode)
+	mux.HandleFunc("/api/auth/token", mockToken)
+	mux.HandleFunc("/api/auth/revoke", mockRevoke)
+	mux.HandleFunc("/api/auth/sessions", mockRevokeSessions)
+	mux.HandleFunc("/api/tokens", mockTokens)
+	mux.HandleFunc("/api/tokens/", mockTokenByID)
+	mux.HandleFunc("/device", mockDeviceVerify)
+	mux.HandleFunc("/api/status/", flaky(mockStatus))
+	if !deltaDisabled {
+		mux.HandleFunc("/api/blobs/missing", flaky(mockMissingBlobs))
+		mux.HandleFunc("/api/blobs", flaky(mockUploadBlobs))
+		if !sessionsDisabled {
+			mux.HandleFunc("/api/uploads", flaky(idempotent(mockCreateUpload)))
+			mux.HandleFunc("/api/uploads/", flaky(mockUploadSession))
+		}
+	}
+	if !wsTicketsDisabled {
+		mux.HandleFunc("/api/ws/tickets", mockCreateWSTicket)
+	}
+	mux.HandleFunc("/api/deployments/", mockDeploymentUpdates)
+	mux.HandleFunc("/ws", mockWebSocket)
+
+	return withRequestID(withRateLimit(mux))
+}
+
+// Simulated processing times, shortened by the tests
+var (
+	generateDelay = 2 * time.Second
+	stageDelay    = 2 * time.Second
+)
+
 // POST /api/generate - Returns mock FastAPI code
 func mockGenerate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if rejectInvalidToken(w, r) {
+		return
+	}
+	if rejectMissingScope(w, r, "generate") {
 		return
 	}
 
@@ -40,12 +90,12 @@ func mockGenerate(w http.ResponseWriter, r *http.Request) {
 		Prompt string `json:"prompt"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request")
 		return
 	}
 
 	// Simulate API delay (Backend.im calling OpenAI/Claude)
-	time.Sleep(2 * time.Second)
+	time.Sleep(generateDelay)
 
 	// Return mock FastAPI code (simulating what Backend.im would return)
 	response := map[string]interface{}{
@@ -69,7 +119,7 @@ class User(Base):
     id = Column(Integer, primary_key=True)
     name = Column(String(50))`,
 			"requirements.txt": "fastapi==0.104.1\nuvicorn==0.24.0\nsqlalchemy==2.0.23",
-			"schema.sql": "CREATE TABLE users (id SERIAL PRIMARY KEY, name VARCHAR(50));",
+			"schema.sql":       "CREATE TABLE users (id SERIAL PRIMARY KEY, name VARCHAR(50));",
 		},
 	}
 
@@ -77,19 +127,22 @@ class User(Base):
 	json.NewEncoder(w).Encode(response)
 }
 
-// POST /api/deploy - Returns deployment ID, project ID, and commit hash
+// POST /api/deploy - Returns deployment ID, project ID, and commit hash.
+// Files arrive as JSON or as a streamed tar.gz archive (see upload.go)
 func mockDeploy(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-
-	var req struct {
-		Files     map[string]string `json:"files"`
-		ProjectID string           `json:"projectId"` // Unique project ID (includes user ID)
+	if rejectInvalidToken(w, r) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if rejectMissingScope(w, r, "deploy") {
+		return
+	}
+
+	req, ok := readUpload(w, r)
+	if !ok {
 		return
 	}
 
@@ -102,35 +155,41 @@ func mockDeploy(w http.ResponseWriter, r *http.Request) {
 		projectID = "proj-" + uuid.New().String()[:8] // Mock fallback
 	}
 
-	// Track deployment start time for status polling
-	deploymentStartTimes[deploymentID] = time.Now()
+	// Track the deployment for status polling and the WebSocket
+	d := &deployment{ProjectID: projectID, CommitHash: commitHash, StartedAt: time.Now(), updated: make(chan struct{})}
+	deploymentsMu.Lock()
+	deployments[deploymentID] = d
+	deploymentsMu.Unlock()
+	go runDeployment(deploymentID, d)
 
 	response := map[string]interface{}{
 		"deploymentId": deploymentID,
-		"projectId":   projectID,   // Used with commit hash for namespace: {projectId}-{commitHash}
-		"commitHash":   commitHash,  // Combined with project ID for unique namespace/PVC
+		"projectId":    projectID,  // Used with commit hash for namespace: {projectId}-{commitHash}
+		"commitHash":   commitHash, // Combined with project ID for unique namespace/PVC
 		"status":       "queued",
-		"websocketUrl": fmt.Sprintf("ws://localhost:8080/ws?deploymentId=%s", deploymentID),
+		"websocketUrl": fmt.Sprintf("%s://%s/ws?deploymentId=%s", wsScheme(), wsHost(r), deploymentID),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// POST /api/commit - Commits local changes to Backend.im/Gitea
+// POST /api/commit - Commits local changes to Backend.im/Gitea.
+// Files arrive as JSON or as a streamed tar.gz archive (see upload.go)
 func mockCommit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-
-	var req struct {
-		Files     map[string]string `json:"files"`
-		ProjectID string            `json:"projectId"`
-		Message   string            `json:"message"`
+	if rejectInvalidToken(w, r) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if rejectMissingScope(w, r, "commit") {
+		return
+	}
+
+	req, ok := readUpload(w, r)
+	if !ok {
 		return
 	}
 
@@ -147,98 +206,129 @@ func mockCommit(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// Simple in-memory deployment tracking
-var deploymentStatuses = make(map[string]map[string]interface{})
-var deploymentStartTimes = make(map[string]time.Time)
+// deployment is what the mock remembers about a deployment
+type deployment struct {
+	ProjectID  string
+	CommitHash string
+	StartedAt  time.Time
+
+	// events is the buffer /ws streams from (see events.go); updated is
+	// closed and replaced whenever an event is added. Both are guarded by
+	// deploymentsMu.
+	events  []deploymentEvent
+	updated chan struct{}
+}
+
+var (
+	deploymentsMu sync.Mutex
+	deployments   = make(map[string]*deployment)
+)
+
+func findDeployment(id string) (*deployment, bool) {
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	d, ok := deployments[id]
+	return d, ok
+}
+
+// deploymentURL is where a completed deployment is served (mock URL - for testing only)
+func deploymentURL(deploymentID string) string {
+	return fmt.Sprintf("https://%s.backend.im", deploymentID[:12])
+}
+
+// wsHost is the host the mock advertises in websocketUrl: the request's,
+// unless MOCK_WS_HOST names another (e.g. an internal hostname, to exercise
+// the CLI's --ws-rewrite rules and fallback)
+func wsHost(r *http.Request) string {
+	if host := os.Getenv("MOCK_WS_HOST"); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// wsScheme is the scheme of the mock's WebSocket endpoint
+func wsScheme() string {
+	if tlsEnabled {
+		return "wss"
+	}
+	return "ws"
+}
 
 // GET /api/status/{deploymentId} - Returns current deployment status
 func mockStatus(w http.ResponseWriter, r *http.Request) {
-	deploymentID := r.URL.Path[len("/api/status/"):]
-	if deploymentID == "" {
-		http.Error(w, "Deployment ID required", http.StatusBadRequest)
+	if rejectInvalidToken(w, r) {
+		return
+	}
+	if rejectMissingScope(w, r, "deploy") {
 		return
 	}
 
-	// Check if we have tracked status for this deployment
-	if status, exists := deploymentStatuses[deploymentID]; exists {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(status)
+	deploymentID := r.URL.Path[len("/api/status/"):]
+	if deploymentID == "" {
+		writeError(w, http.StatusBadRequest, "Deployment ID required")
 		return
 	}
 
-	// Check if deployment just started (for polling scenarios)
-	startTime, exists := deploymentStartTimes[deploymentID]
-	if !exists {
-		// New deployment - mark start time
-		deploymentStartTimes[deploymentID] = time.Now()
-		startTime = time.Now()
-	}
-
-	elapsed := time.Since(startTime)
-	commitHash := "a1b2c3d4e5f6" // Mock commit hash
-
-	// Simulate deployment stages based on elapsed time
-	var status string
-	var logs []string
-	var url string
-
-	if elapsed < 3*time.Second {
-		status = "queued"
-		logs = []string{"📦 Deployment queued..."}
-	} else if elapsed < 6*time.Second {
-		status = "committing"
-		logs = []string{"📦 Committing files to repository..."}
-	} else if elapsed < 9*time.Second {
-		status = "creating_namespace"
-		logs = []string{"🏗️ Creating Kubernetes namespace..."}
-	} else if elapsed < 12*time.Second {
-		status = "creating_pvc"
-		logs = []string{"💾 Creating Persistent Volume Claim..."}
-	} else if elapsed < 15*time.Second {
-		status = "building"
-		logs = []string{"🔨 Building container image..."}
-	} else if elapsed < 18*time.Second {
-		status = "deploying"
-		logs = []string{"🚀 Deploying to Kubernetes cluster..."}
-	} else {
-		// Complete after ~18 seconds
-		status = "complete"
-		url = fmt.Sprintf("https://%s.backend.im", deploymentID[:12])
-		logs = []string{"✅ Deployment complete!"}
-		
-		// Store completed status
-		deploymentStatuses[deploymentID] = map[string]interface{}{
-			"id":         deploymentID,
-			"projectId":  "user123-myproject",
-			"commitHash": commitHash,
-			"status":     status,
-			"url":        url,
-			"logs":       logs,
-		}
+	d, ok := findDeployment(deploymentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown deployment")
+		return
 	}
 
+	// Report the last stage published to the deployment's buffer, so status
+	// agrees with what /ws and the fallback transports have sent
 	response := map[string]interface{}{
 		"id":         deploymentID,
-		"projectId":  "user123-myproject",
-		"commitHash": commitHash,
-		"status":     status,
-		"logs":       logs,
+		"projectId":  d.ProjectID,
+		"commitHash": d.CommitHash,
+		"status":     "queued",
+		"logs":       []string{"📦 Deployment queued..."},
 	}
-
-	if url != "" {
-		response["url"] = url
+	if event, ok := d.latest(); ok {
+		response["status"] = event.Status
+		response["logs"] = event.Logs
+		if event.URL != "" {
+			response["url"] = event.URL
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// WebSocket /ws?deploymentId={id} - Streams deployment updates
+// WebSocket /ws?deploymentId={id}&since={seq} - Streams deployment updates
+// from the deployment's buffer, starting after sequence number since.
+// Requires a bearer token or a ticket (see tickets.go).
 // Orchestrator needs project ID + commit hash to create unique namespace/PVC
 func mockWebSocket(w http.ResponseWriter, r *http.Request) {
+	if wsBlocked {
+		writeError(w, http.StatusBadGateway, "WebSockets are not supported by this proxy")
+		return
+	}
+
 	deploymentID := r.URL.Query().Get("deploymentId")
 	if deploymentID == "" {
-		http.Error(w, "deploymentId required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "deploymentId required")
+		return
+	}
+
+	since := 0
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "since must be a sequence number")
+			return
+		}
+		since = n
+	}
+
+	if rejectUnauthenticatedStream(w, r, deploymentID) {
+		return
+	}
+
+	d, ok := findDeployment(deploymentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown deployment")
 		return
 	}
 
@@ -249,56 +339,74 @@ func mockWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	// In real implementation, these would come from deployment record
-	projectID := "user123-myproject" // Unique project ID (includes user ID)
-	commitHash := "a1b2c3d4e5f6"     // Commit hash for this deployment
-
-	// Stream deployment stages - project ID + commit hash required for orchestrator
-	stages := []map[string]interface{}{
-		{
-			"deploymentId": deploymentID,
-			"projectId":   projectID,   // Required: identifies the project
-			"commitHash":   commitHash,  // Required: orchestrator uses this to pull from Gitea
-			"status":       "committing",
-			"logs":         []string{"📦 Committing files to repository..."},
-		},
-		{
-			"deploymentId": deploymentID,
-			"projectId":   projectID,   // Orchestrator combines projectId + commitHash for namespace
-			"commitHash":   commitHash,  // Namespace format: {projectId}-{commitHash}
-			"status":       "creating_namespace",
-			"logs":         []string{"🏗️ Creating Kubernetes namespace..."},
-		},
-		{
-			"deploymentId": deploymentID,
-			"projectId":   projectID,   // Same project ID, different commit = update deployment
-			"commitHash":   commitHash,  // PVC format: {projectId}-{commitHash}
-			"status":       "creating_pvc",
-			"logs":         []string{"💾 Creating Persistent Volume Claim..."},
-		},
-		{
-			"deploymentId": deploymentID,
-			"projectId":   projectID,
-			"commitHash":   commitHash,
-			"status":       "deploying",
-			"logs":         []string{"🚀 Deploying application..."},
-		},
-		{
-			"deploymentId": deploymentID,
-			"projectId":   projectID,
-			"commitHash":   commitHash,
-			"status":       "complete",
-			"url":          fmt.Sprintf("https://%s.backend.im", deploymentID[:12]), // Mock URL - for testing only
-			"logs":         []string{"✅ Deployment complete!"},
-		},
-	}
+	// Reading processes the client's control frames: pings are answered
+	// (unless the connection is stalled) and gone is closed when the client
+	// goes away
+	var stalled atomic.Bool
+	conn.SetPingHandler(func(data string) error {
+		if stalled.Load() {
+			return nil
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	sent := 0
+	for {
+		events, updated := d.eventsSince(since)
+		for _, event := range events {
+			if err := conn.WriteJSON(event); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				return
+			}
+			since = event.Seq
+			sent++
+
+			if event.final() {
+				closeStream(conn, gone, "deployment "+event.Status)
+				return
+			}
+			if wsDropAfter > 0 && sent >= wsDropAfter {
+				log.Printf("Dropping WebSocket for %s after update %d (MOCK_WS_DROP_AFTER)", deploymentID, since)
+				return
+			}
+			if wsStallAfter > 0 && sent >= wsStallAfter {
+				log.Printf("Stalling WebSocket for %s after update %d (MOCK_WS_STALL_AFTER)", deploymentID, since)
+				stalled.Store(true)
+				<-gone
+				return
+			}
+		}
+
+		if len(events) == 0 && d.finished() {
+			// Resumed after the last update: nothing left to send
+			closeStream(conn, gone, "no updates after "+strconv.Itoa(since))
+			return
+		}
 
-	for _, stage := range stages {
-		if err := conn.WriteJSON(stage); err != nil {
-			log.Printf("WebSocket write error: %v", err)
+		select {
+		case <-updated:
+		case <-gone:
 			return
 		}
-		time.Sleep(2 * time.Second)
+	}
+}
+
+// closeStream sends a normal close frame and waits briefly for the client's
+// reply, so the close handshake completes before the connection is closed
+func closeStream(conn *websocket.Conn, gone <-chan struct{}, reason string) {
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason))
+	select {
+	case <-gone:
+	case <-time.After(time.Second):
 	}
 }
 
@@ -309,40 +417,3 @@ func generateCommitHash(files map[string]string) string {
 	}
 	return hex.EncodeToString(hasher.Sum(nil))[:12]
 }
-
-func mockAuthCallback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "Missing authorization code", http.StatusBadRequest)
-		return
-	}
-
-	// Return mock JWT token
-	response := map[string]interface{}{
-		"access_token": "mock_token_" + uuid.New().String(),
-		"token_type":   "Bearer",
-		"expires_in":   3600,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-func mockVerifyAuth(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Missing authorization header", http.StatusUnauthorized)
-		return
-	}
-
-	// Simple token verification (in real app, would validate JWT)
-	response := map[string]interface{}{
-		"valid":  true,
-		"userId": "user123",
-		"email":  "user@example.com",
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// capturedResponse records a handler's response so it can be replayed
+type capturedResponse struct {
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	fingerprint [32]byte // identifies the request the response belongs to
+}
+
+func (c *capturedResponse) Header() http.Header { return c.header }
+
+func (c *capturedResponse) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(b)
+}
+
+func (c *capturedResponse) WriteHeader(status int) { c.status = status }
+
+func (c *capturedResponse) replay(w http.ResponseWriter) {
+	for k, v := range c.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(c.status)
+	w.Write(c.body.Bytes())
+}
+
+var (
+	idempotencyMu    sync.Mutex
+	idempotencyCache = make(map[string]*capturedResponse) // token + key -> response
+	idempotencyLocks = make(map[string]*sync.Mutex)       // token + key -> in-flight request
+)
+
+// maxIdempotentBody bounds the JSON request bodies idempotent() buffers to hash
+const maxIdempotentBody = 64 << 20
+
+// idempotent honours the Idempotency-Key header: the first request with a key
+// is processed and its response stored; repeats get the stored response
+// instead of creating another deployment or commit.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		fingerprint, ok := requestFingerprint(w, r)
+		if !ok {
+			return
+		}
+
+		// Keys are scoped per caller and endpoint
+		cacheKey := bearerToken(r) + "|" + r.URL.Path + "|" + key
+
+		// Concurrent retries of the same request wait for the first one
+		// rather than running the handler twice
+		inFlight := idempotencyLock(cacheKey)
+		inFlight.Lock()
+		defer inFlight.Unlock()
+
+		idempotencyMu.Lock()
+		cached, ok := idempotencyCache[cacheKey]
+		idempotencyMu.Unlock()
+		if ok {
+			if cached.fingerprint != fingerprint {
+				writeErrorDetails(w, http.StatusUnprocessableEntity, "idempotency_key_reused",
+					"Idempotency-Key was already used with a different request",
+					map[string]interface{}{"idempotencyKey": key})
+				return
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			cached.replay(w)
+			return
+		}
+
+		// Start from the real headers so the handler sees the request ID
+		captured := &capturedResponse{header: w.Header().Clone(), fingerprint: fingerprint}
+		next(captured, r)
+		// Only successful results are remembered, so failures can be retried
+		if captured.status >= 200 && captured.status < 300 {
+			idempotencyMu.Lock()
+			idempotencyCache[cacheKey] = captured
+			idempotencyMu.Unlock()
+		}
+		captured.replay(w)
+	}
+}
+
+// requestFingerprint identifies the request an Idempotency-Key was sent with.
+// JSON bodies are buffered and hashed. Archive uploads are streamed and can be
+// far larger, so they're identified by their manifest header instead - it
+// names the project, message, file count and total size. It writes the error
+// response itself if the body can't be read.
+func requestFingerprint(w http.ResponseWriter, r *http.Request) ([32]byte, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == archiveContentType {
+		return sha256.Sum256([]byte(mediaType + "|" + r.Header.Get(manifestHeader))), true
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+		return [32]byte{}, false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request")
+		return [32]byte{}, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return sha256.Sum256(body), true
+}
+
+// idempotencyLock returns the lock held while a request with cacheKey runs
+func idempotencyLock(cacheKey string) *sync.Mutex {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	lock, ok := idempotencyLocks[cacheKey]
+	if !ok {
+		lock = new(sync.Mutex)
+		idempotencyLocks[cacheKey] = lock
+	}
+	return lock
+}
+
+// withRequestID tags every response with an X-Request-ID, which error
+// envelopes echo so a failure can be matched to the request that caused it
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := "req_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:16]
+		w.Header().Set("X-Request-ID", requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// failureRate is the fraction of requests flaky() fails, set with MOCK_FAILURE_RATE
+var failureRate = func() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("MOCK_FAILURE_RATE"), 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}()
+
+// flaky simulates an unreliable network. Half the injected failures happen
+// before the handler runs (503), the other half after it has done the work but
+// the response is "lost" (504) - the case idempotency keys exist for.
+func flaky(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if failureRate == 0 || rand.Float64() >= failureRate {
+			next(w, r)
+			return
+		}
+
+		if rand.Intn(2) == 0 {
+			writeError(w, http.StatusServiceUnavailable, "Simulated outage")
+			return
+		}
+
+		next(&capturedResponse{header: make(http.Header)}, r)
+		writeError(w, http.StatusGatewayTimeout, "Simulated gateway timeout")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// resetIdempotencyCache drops the responses a test left in the shared cache
+// so it can be run again in the same process
+func resetIdempotencyCache(t *testing.T) {
+	t.Cleanup(func() {
+		idempotencyMu.Lock()
+		defer idempotencyMu.Unlock()
+		idempotencyCache = make(map[string]*capturedResponse)
+		idempotencyLocks = make(map[string]*sync.Mutex)
+	})
+}
+
+func TestIdempotentCachesOnlySuccess(t *testing.T) {
+	resetIdempotencyCache(t)
+	statuses := []int{http.StatusConflict, http.StatusOK}
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[calls])
+		calls++
+	})
+
+	send := func() int {
+		r := httptest.NewRequest("POST", "/api/deploy", strings.NewReader(`{"projectId": "p"}`))
+		r.Header.Set("Idempotency-Key", "key-"+t.Name())
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// The conflict isn't remembered, so the retry runs the handler again,
+	// and the success that follows is replayed
+	for i, want := range []int{http.StatusConflict, http.StatusOK, http.StatusOK} {
+		if got := send(); got != want {
+			t.Errorf("request %d: status %d, want %d", i+1, got, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestIdempotentBodyLimit(t *testing.T) {
+	resetIdempotencyCache(t)
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler ran for an oversized body")
+	})
+
+	r := httptest.NewRequest("POST", "/api/deploy", strings.NewReader(strings.Repeat("x", maxIdempotentBody+1)))
+	r.Header.Set("Idempotency-Key", "key-"+t.Name())
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// TestIdempotentStreamsArchives covers archive uploads, which can exceed the
+// JSON limit and are matched on their manifest rather than buffered
+func TestIdempotentStreamsArchives(t *testing.T) {
+	resetIdempotencyCache(t)
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil || n != maxIdempotentBody+1 {
+			t.Errorf("handler read %d bytes, err %v", n, err)
+		}
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	send := func(manifest string) int {
+		body := strings.NewReader(strings.Repeat("x", maxIdempotentBody+1))
+		r := httptest.NewRequest("POST", "/api/deploy", body)
+		r.Header.Set("Content-Type", archiveContentType)
+		r.Header.Set(manifestHeader, manifest)
+		r.Header.Set("Idempotency-Key", "key-"+t.Name())
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	for i, tt := range []struct {
+		manifest string
+		want     int
+	}{
+		{"bWFuaWZlc3QtMQ==", http.StatusCreated},
+		{"bWFuaWZlc3QtMQ==", http.StatusCreated},
+		{"bWFuaWZlc3QtMg==", http.StatusUnprocessableEntity},
+	} {
+		if got := send(tt.manifest); got != tt.want {
+			t.Errorf("request %d: status %d, want %d", i+1, got, tt.want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/backend-im/openapi"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+type object = openapi.Object
+
+// conformance calls the mock and checks every response against the spec
+type conformance struct {
+	spec    *openapi.Spec
+	server  *httptest.Server
+	client  *http.Client
+	covered map[string]bool
+
+	// What earlier steps of TestHandlersConformToSpec created for later ones
+	userToken    string
+	refreshToken string
+	files        map[string]string
+	deploy       object
+	updates      int
+}
+
+func newConformance(t *testing.T) *conformance {
+	generateDelay, stageDelay = 0, 10*time.Millisecond
+	server := httptest.NewServer(newHandler())
+	t.Cleanup(server.Close)
+
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &conformance{
+		spec:   spec,
+		server: server,
+		client: &http.Client{
+			// Redirects are responses to check, not to follow
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		covered: make(map[string]bool),
+	}
+}
+
+// call sends a request and checks the response against the operation's
+// declared responses. body is JSON-encoded unless it is already []byte.
+func (c *conformance) call(t *testing.T, method, path, token string, body interface{}, header http.Header) (int, object) {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, _ := json.Marshal(b)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server.URL+path, reader)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if req.Header.Get("Content-Type") == "" && reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	return resp.StatusCode, c.check(t, method, path, resp, data)
+}
+
+// check validates a response against the spec and returns its JSON body
+func (c *conformance) check(t *testing.T, method, path string, resp *http.Response, data []byte) object {
+	t.Helper()
+
+	pathOnly := strings.SplitN(path, "?", 2)[0]
+	op, template := c.spec.Operation(method, pathOnly)
+	if op == nil {
+		t.Errorf("%s %s is not in the spec", method, pathOnly)
+		return nil
+	}
+	c.covered[method+" "+template] = true
+
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Errorf("%s %s: response has no X-Request-ID", method, path)
+	}
+
+	schema, ok := c.spec.ResponseSchema(op, resp.StatusCode)
+	if !ok {
+		t.Errorf("%s %s: status %d is not in the spec", method, path, resp.StatusCode)
+		return nil
+	}
+	if schema == nil {
+		if len(bytes.TrimSpace(data)) != 0 {
+			t.Errorf("%s %s: %d has a body but the spec declares none: %s", method, path, resp.StatusCode, data)
+		}
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Errorf("%s %s: %d body is not JSON: %s", method, path, resp.StatusCode, data)
+		return nil
+	}
+	for _, err := range c.spec.Validate(schema, value, "body") {
+		t.Errorf("%s %s: %d %s", method, path, resp.StatusCode, err)
+	}
+	obj, _ := value.(object)
+	return obj
+}
+
+// expect fails the test unless status is want
+func (c *conformance) expect(t *testing.T, what string, status, want int) {
+	t.Helper()
+	if status != want {
+		t.Fatalf("%s: status %d, want %d", what, status, want)
+	}
+}
+
+// blobArchive packs blobs the way PUT /api/blobs expects them: a tar.gz
+// with one entry per blob, named by its hash
+func blobArchive(t *testing.T, blobs ...string) ([]byte, []string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	var hashes []string
+	for _, blob := range blobs {
+		sum := sha256.Sum256([]byte(blob))
+		hash := hex.EncodeToString(sum[:])
+		hashes = append(hashes, hash)
+		tw.WriteHeader(&tar.Header{Name: hash, Mode: 0644, Size: int64(len(blob)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(blob))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes(), hashes
+}
+
+// TestHandlersConformToSpec walks through the API the way the CLI does, one
+// subtest per group of operations. Later groups use what earlier ones
+// created, so the walk stops at the first group that fails. Keys and blobs
+// are unique to each run, as the handlers' state outlives the test server.
+func TestHandlersConformToSpec(t *testing.T) {
+	c := newConformance(t)
+	const saToken = serviceAccountPrefix + "conformance"
+	run := uuid.NewString()
+
+	steps := []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{"GET /api/version", func(t *testing.T) {
+			status, _ := c.call(t, "GET", "/api/version", "", nil, nil)
+			c.expect(t, "version", status, http.StatusOK)
+		}},
+		{"POST /api/generate", func(t *testing.T) {
+			status, _ := c.call(t, "POST", "/api/generate", saToken, object{"prompt": "a todo API"}, nil)
+			c.expect(t, "generate", status, http.StatusOK)
+		}},
+		{"device flow", c.testDeviceFlow},
+		{"browser login", c.testBrowserLogin},
+		{"GET /api/auth/verify", func(t *testing.T) {
+			for _, token := range []string{c.userToken, saToken} {
+				status, _ := c.call(t, "GET", "/api/auth/verify", token, nil, nil)
+				c.expect(t, "verify", status, http.StatusOK)
+			}
+		}},
+		{"personal access tokens", c.testPersonalAccessTokens},
+		{"delta upload", func(t *testing.T) { c.testDeltaUpload(t, saToken, run) }},
+		{"upload session", func(t *testing.T) { c.testUploadSession(t, saToken, run) }},
+		{"deploy", func(t *testing.T) { c.testDeploy(t, saToken, run) }},
+		{"GET /ws", func(t *testing.T) { c.testWebSocket(t, saToken) }},
+		{"GET /api/deployments/{deploymentId}/events", func(t *testing.T) { c.testEventStream(t, saToken) }},
+		{"GET /api/deployments/{deploymentId}/updates", func(t *testing.T) { c.testLongPoll(t, saToken) }},
+		{"revocation", c.testRevocation},
+	}
+	for _, step := range steps {
+		if !t.Run(step.name, step.test) {
+			return
+		}
+	}
+
+	for _, op := range c.spec.Operations() {
+		if !c.covered[op] {
+			t.Errorf("%s is in the spec but not exercised by this test", op)
+		}
+	}
+}
+
+// testDeviceFlow logs in interactively through the device flow
+func (c *conformance) testDeviceFlow(t *testing.T) {
+	status, device := c.call(t, "POST", "/api/auth/device/code", "", object{"client_id": "backend-im-cli"}, nil)
+	c.expect(t, "device code", status, http.StatusOK)
+	grant := object{"grant_type": "urn:ietf:params:oauth:grant-type:device_code", "device_code": device["device_code"], "client_id": "backend-im-cli"}
+	status, _ = c.call(t, "POST", "/api/auth/token", "", grant, nil)
+	c.expect(t, "pending device token", status, http.StatusBadRequest)
+	resp, err := http.Get(c.server.URL + "/device?user_code=" + device["user_code"].(string))
+	if err != nil {
+		t.Fatalf("approve device: %v", err)
+	}
+	resp.Body.Close()
+	status, tokens := c.call(t, "POST", "/api/auth/token", "", grant, nil)
+	c.expect(t, "device token", status, http.StatusOK)
+	c.userToken = tokens["access_token"].(string)
+	c.refreshToken = tokens["refresh_token"].(string)
+
+	status, _ = c.call(t, "POST", "/api/auth/token", "", object{"grant_type": "refresh_token", "refresh_token": c.refreshToken}, nil)
+	c.expect(t, "refresh", status, http.StatusOK)
+}
+
+// testBrowserLogin logs in interactively through the browser (PKCE)
+func (c *conformance) testBrowserLogin(t *testing.T) {
+	verifier := "conformance-verifier-0123456789-0123456789-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	redirectURI := "http://127.0.0.1:9999/callback"
+	authorize := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"backend-im-cli"},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	req, _ := http.NewRequest("GET", c.server.URL+"/api/auth/authorize?"+authorize.Encode(), nil)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	c.check(t, "GET", "/api/auth/authorize", resp, nil)
+	c.expect(t, "authorize", resp.StatusCode, http.StatusFound)
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	callback := url.Values{
+		"code":          {location.Query().Get("code")},
+		"code_verifier": {verifier},
+		"redirect_uri":  {redirectURI},
+	}
+	status, _ := c.call(t, "GET", "/api/auth/callback?"+callback.Encode(), "", nil, nil)
+	c.expect(t, "callback", status, http.StatusOK)
+	status, _ = c.call(t, "GET", "/api/auth/callback", "", nil, nil)
+	c.expect(t, "callback without code", status, http.StatusBadRequest)
+}
+
+func (c *conformance) testPersonalAccessTokens(t *testing.T) {
+	status, pat := c.call(t, "POST", "/api/tokens", c.userToken, object{"name": "ci", "scopes": []string{"commit"}, "expiresIn": 3600}, nil)
+	c.expect(t, "create token", status, http.StatusCreated)
+	status, _ = c.call(t, "GET", "/api/tokens", c.userToken, nil, nil)
+	c.expect(t, "list tokens", status, http.StatusOK)
+	status, _ = c.call(t, "GET", "/api/auth/verify", pat["token"].(string), nil, nil)
+	c.expect(t, "verify token", status, http.StatusOK)
+	status, _ = c.call(t, "POST", "/api/deploy", pat["token"].(string), object{"projectId": "p", "files": object{}}, nil)
+	c.expect(t, "deploy without scope", status, http.StatusForbidden)
+	status, _ = c.call(t, "DELETE", "/api/auth/sessions", pat["token"].(string), nil, nil)
+	c.expect(t, "revoke sessions with a token", status, http.StatusForbidden)
+	status, _ = c.call(t, "GET", "/api/auth/verify", c.userToken, nil, nil)
+	c.expect(t, "verify after a token tried to revoke sessions", status, http.StatusOK)
+	status, _ = c.call(t, "DELETE", "/api/tokens/"+pat["id"].(string), c.userToken, nil, nil)
+	c.expect(t, "revoke token", status, http.StatusNoContent)
+	status, _ = c.call(t, "DELETE", "/api/tokens/"+pat["id"].(string), c.userToken, nil, nil)
+	c.expect(t, "revoke unknown token", status, http.StatusNotFound)
+}
+
+// testDeltaUpload uploads blobs first, then commits a manifest of them
+func (c *conformance) testDeltaUpload(t *testing.T, token, run string) {
+	c.files = map[string]string{"main.py": "print('" + run + "')\n", "requirements.txt": "fastapi\n# " + run + "\n"}
+	archive, hashes := blobArchive(t, c.files["main.py"], c.files["requirements.txt"])
+	status, missing := c.call(t, "POST", "/api/blobs/missing", token, object{"hashes": hashes}, nil)
+	c.expect(t, "missing blobs", status, http.StatusOK)
+	if len(missing["missing"].([]interface{})) != len(hashes) {
+		t.Errorf("missing blobs = %v, want all of %v", missing["missing"], hashes)
+	}
+	status, _ = c.call(t, "PUT", "/api/blobs", token, archive, http.Header{"Content-Type": {"application/tar+gzip"}})
+	c.expect(t, "upload blobs", status, http.StatusNoContent)
+	manifest := object{"main.py": hashes[0], "requirements.txt": hashes[1]}
+	status, _ = c.call(t, "POST", "/api/commit", token, object{"projectId": "proj-conformance", "manifest": manifest, "message": "delta"}, nil)
+	c.expect(t, "delta commit", status, http.StatusOK)
+}
+
+func (c *conformance) testUploadSession(t *testing.T, token, run string) {
+	sessionArchive, _ := blobArchive(t, "session blob "+run)
+	sessionSum := sha256.Sum256(sessionArchive)
+	status, session := c.call(t, "POST", "/api/uploads", token, object{"size": len(sessionArchive), "sha256": hex.EncodeToString(sessionSum[:])}, nil)
+	c.expect(t, "create upload", status, http.StatusCreated)
+	uploadPath := "/api/uploads/" + session["uploadId"].(string)
+	status, _ = c.call(t, "POST", uploadPath+"/finalize", token, nil, nil)
+	c.expect(t, "finalize incomplete upload", status, http.StatusConflict)
+	status, _ = c.call(t, "PUT", uploadPath+"/chunks/0", token, sessionArchive, http.Header{
+		"Content-Type":   {"application/octet-stream"},
+		"X-Chunk-Sha256": {hex.EncodeToString(sessionSum[:])},
+	})
+	c.expect(t, "upload chunk", status, http.StatusNoContent)
+	status, _ = c.call(t, "GET", uploadPath, token, nil, nil)
+	c.expect(t, "get upload", status, http.StatusOK)
+	status, _ = c.call(t, "POST", uploadPath+"/finalize", token, nil, nil)
+	c.expect(t, "finalize upload", status, http.StatusOK)
+}
+
+// testDeploy starts the deployment the stream tests follow. Its status must
+// report the deployment's own project and commit.
+func (c *conformance) testDeploy(t *testing.T, token, run string) {
+	status, deploy := c.call(t, "POST", "/api/deploy", token, object{"projectId": "proj-conformance", "files": c.files}, http.Header{"Idempotency-Key": {"conformance-" + run}})
+	c.expect(t, "deploy", status, http.StatusOK)
+	c.deploy = deploy
+	deploymentID := deploy["deploymentId"].(string)
+
+	status, current := c.call(t, "GET", "/api/status/"+deploymentID, token, nil, nil)
+	c.expect(t, "status", status, http.StatusOK)
+	if current["projectId"] != deploy["projectId"] || current["commitHash"] != deploy["commitHash"] {
+		t.Errorf("status reports %v@%v, deploy returned %v@%v", current["projectId"], current["commitHash"], deploy["projectId"], deploy["commitHash"])
+	}
+	status, _ = c.call(t, "GET", "/api/status/unknown", token, nil, nil)
+	c.expect(t, "unknown status", status, http.StatusNotFound)
+
+	wsURL, _ := url.Parse(deploy["websocketUrl"].(string))
+	if wsURL.Host != strings.TrimPrefix(c.server.URL, "http://") {
+		t.Errorf("websocketUrl %s does not point at the server", wsURL)
+	}
+}
+
+// testWebSocket follows the deployment to the end, then resumes it. The
+// stream requires a bearer token or a single-use ticket.
+func (c *conformance) testWebSocket(t *testing.T, token string) {
+	deploymentID := c.deploy["deploymentId"].(string)
+	status, _ := c.call(t, "GET", "/ws?deploymentId="+deploymentID, "", nil, nil)
+	c.expect(t, "unauthenticated stream", status, http.StatusUnauthorized)
+	updates, pongs := c.streamDeployment(t, c.deploy["websocketUrl"].(string), http.Header{"Authorization": {"Bearer " + token}}, c.deploy)
+	if pongs == 0 {
+		t.Errorf("GET /ws: ping was not answered")
+	}
+	for i, update := range updates {
+		if update["seq"] != float64(i+1) {
+			t.Errorf("update %d has seq %v, want %d", i, update["seq"], i+1)
+		}
+	}
+	c.updates = len(updates)
+
+	status, current := c.call(t, "GET", "/api/status/"+deploymentID, token, nil, nil)
+	c.expect(t, "status", status, http.StatusOK)
+	if final := updates[len(updates)-1]; current["status"] != final["status"] || current["url"] != final["url"] {
+		t.Errorf("status reports %v at %v after the stream ended with %v at %v", current["status"], current["url"], final["status"], final["url"])
+	}
+
+	// Resuming replays the buffered updates after since, once each
+	status, ticket := c.call(t, "POST", "/api/ws/tickets", token, object{"deploymentId": deploymentID}, nil)
+	c.expect(t, "create ticket", status, http.StatusCreated)
+	resumeURL := c.deploy["websocketUrl"].(string) + "&since=2&ticket=" + url.QueryEscape(fmt.Sprint(ticket["ticket"]))
+	resumed, _ := c.streamDeployment(t, resumeURL, nil, c.deploy)
+	if len(resumed) != len(updates)-2 || resumed[0]["seq"] != float64(3) {
+		t.Errorf("resuming after 2 of %d updates gave %v", len(updates), resumed)
+	}
+	status, _ = c.call(t, "GET", "/ws?deploymentId="+deploymentID+"&ticket="+url.QueryEscape(fmt.Sprint(ticket["ticket"])), "", nil, nil)
+	c.expect(t, "reused ticket", status, http.StatusUnauthorized)
+
+	status, _ = c.call(t, "POST", "/api/ws/tickets", token, object{"deploymentId": "unknown"}, nil)
+	c.expect(t, "ticket for unknown deployment", status, http.StatusNotFound)
+	status, _ = c.call(t, "GET", "/ws?deploymentId=unknown", token, nil, nil)
+	c.expect(t, "unknown deployment stream", status, http.StatusNotFound)
+}
+
+// testEventStream checks the event stream replays the same updates as the
+// WebSocket after Last-Event-ID
+func (c *conformance) testEventStream(t *testing.T, token string) {
+	eventsPath := "/api/deployments/" + c.deploy["deploymentId"].(string) + "/events"
+	status, _ := c.call(t, "GET", eventsPath, "", nil, nil)
+	c.expect(t, "unauthenticated event stream", status, http.StatusUnauthorized)
+	events := c.streamEvents(t, eventsPath, token, 2, c.deploy)
+	if len(events) != c.updates-2 || events[0]["seq"] != float64(3) {
+		t.Errorf("event stream after 2 of %d updates gave %v", c.updates, events)
+	}
+	status, _ = c.call(t, "GET", eventsPath, token, nil, http.Header{"Last-Event-ID": {strconv.Itoa(c.updates)}})
+	c.expect(t, "event stream after the final update", status, http.StatusNoContent)
+}
+
+// testLongPoll checks long polls replay the same updates as the WebSocket
+// after since, and say when there will be no more
+func (c *conformance) testLongPoll(t *testing.T, token string) {
+	updatesPath := "/api/deployments/" + c.deploy["deploymentId"].(string) + "/updates"
+	status, polled := c.call(t, "GET", updatesPath+"?since=2&wait=1", token, nil, nil)
+	c.expect(t, "long poll", status, http.StatusOK)
+	if got := polled["updates"].([]interface{}); len(got) != c.updates-2 || got[0].(object)["seq"] != float64(3) {
+		t.Errorf("long poll after 2 of %d updates gave %v", c.updates, got)
+	}
+	status, polled = c.call(t, "GET", updatesPath+"?since="+strconv.Itoa(c.updates)+"&wait=30", token, nil, nil)
+	c.expect(t, "long poll after the final update", status, http.StatusOK)
+	if got := polled["updates"].([]interface{}); len(got) != 0 || polled["finished"] != true {
+		t.Errorf("long poll after the final update gave %v, finished %v", got, polled["finished"])
+	}
+	status, _ = c.call(t, "GET", updatesPath+"?wait=31", token, nil, nil)
+	c.expect(t, "long poll waiting too long", status, http.StatusBadRequest)
+	status, _ = c.call(t, "GET", "/api/deployments/unknown/updates", token, nil, nil)
+	c.expect(t, "long poll of an unknown deployment", status, http.StatusNotFound)
+}
+
+func (c *conformance) testRevocation(t *testing.T) {
+	status, _ := c.call(t, "POST", "/api/auth/revoke", "", object{"token": c.refreshToken, "token_type_hint": "refresh_token"}, nil)
+	c.expect(t, "revoke", status, http.StatusOK)
+	status, _ = c.call(t, "DELETE", "/api/auth/sessions", c.userToken, nil, nil)
+	c.expect(t, "revoke sessions", status, http.StatusOK)
+	status, _ = c.call(t, "GET", "/api/auth/verify", c.userToken, nil, nil)
+	c.expect(t, "verify revoked", status, http.StatusUnauthorized)
+}
+
+// streamDeployment follows a deployment over the WebSocket at url, checks
+// every message against the spec and returns them, with the number of pongs
+// answering the ping it sends first
+func (c *conformance) streamDeployment(t *testing.T, url string, header http.Header, deploy object) ([]object, int) {
+	t.Helper()
+
+	op, _ := c.spec.Operation("GET", "/ws")
+	schema := op["x-websocket"].(object)["serverMessages"].(object)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	defer conn.Close()
+	c.covered["GET /ws"] = true
+	if _, ok := c.spec.ResponseSchema(op, resp.StatusCode); !ok {
+		t.Errorf("GET /ws: status %d is not in the spec", resp.StatusCode)
+	}
+
+	pongs := 0
+	conn.SetPongHandler(func(string) error {
+		pongs++
+		return nil
+	})
+	if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("GET /ws: ping: %v", err)
+	}
+
+	var updates []object
+	for {
+		var message interface{}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("stream ended before the deployment completed: %v", err)
+		}
+		for _, err := range c.spec.Validate(schema, message, "message") {
+			t.Errorf("GET /ws: %s", err)
+		}
+		update, _ := message.(object)
+		updates = append(updates, update)
+		if update["projectId"] != deploy["projectId"] || update["commitHash"] != deploy["commitHash"] {
+			t.Errorf("stream reports %v@%v, deploy returned %v@%v", update["projectId"], update["commitHash"], deploy["projectId"], deploy["commitHash"])
+		}
+		if update["status"] == "complete" || update["status"] == "failed" {
+			if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("GET /ws: stream ended with %v, want a normal close", err)
+			}
+			return updates, pongs
+		}
+	}
+}
+
+// streamEvents follows a deployment's event stream at path from
+// Last-Event-ID since, checks every event against the spec and returns the
+// updates
+func (c *conformance) streamEvents(t *testing.T, path, token string, since int, deploy object) []object {
+	t.Helper()
+
+	op, template := c.spec.Operation("GET", path)
+	schema := op["x-sse"].(object)["events"].(object)["update"].(object)
+
+	req, _ := http.NewRequest("GET", c.server.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Last-Event-ID", strconv.Itoa(since))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	c.covered["GET "+template] = true
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s: %d %s, want an event stream", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	var updates []object
+	id, kind, data := "", "", ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			kind = value
+		case "data":
+			data = value
+		case "":
+			if data == "" {
+				continue
+			}
+			if kind != "update" {
+				t.Errorf("GET %s: event type %q is not in the spec", template, kind)
+			}
+			var message interface{}
+			if err := json.Unmarshal([]byte(data), &message); err != nil {
+				t.Fatalf("GET %s: event data is not JSON: %s", template, data)
+			}
+			for _, err := range c.spec.Validate(schema, message, "event") {
+				t.Errorf("GET %s: %s", template, err)
+			}
+			update, _ := message.(object)
+			if id != fmt.Sprint(update["seq"]) {
+				t.Errorf("GET %s: event id %q, want the update's seq %v", template, id, update["seq"])
+			}
+			if update["projectId"] != deploy["projectId"] || update["commitHash"] != deploy["commitHash"] {
+				t.Errorf("event stream reports %v@%v, deploy returned %v@%v", update["projectId"], update["commitHash"], deploy["projectId"], deploy["commitHash"])
+			}
+			updates = append(updates, update)
+			id, kind, data = "", "", ""
+		}
+	}
+	if len(updates) == 0 || (updates[len(updates)-1]["status"] != "complete" && updates[len(updates)-1]["status"] != "failed") {
+		t.Errorf("GET %s: stream ended before the deployment completed", template)
+	}
+	return updates
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimit is how many requests each token may make per rateWindow, set
+// with MOCK_RATE_LIMIT (0 disables) and MOCK_RATE_WINDOW (seconds, default 60)
+var (
+	rateLimit  = envInt("MOCK_RATE_LIMIT", 0)
+	rateWindow = time.Duration(envInt("MOCK_RATE_WINDOW", 60)) * time.Second
+)
+
+// quota is one token's fixed rate-limit window
+type quota struct {
+	used  int
+	reset time.Time
+}
+
+var (
+	quotasMu sync.Mutex
+	quotas   = make(map[string]*quota)
+)
+
+func envInt(name string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
+}
+
+// withRateLimit throttles each token (or, without one, each client address)
+// to rateLimit requests per window. Every response carries the RateLimit-*
+// headers; throttled requests get 429 with Retry-After.
+func withRateLimit(next http.Handler) http.Handler {
+	if rateLimit == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get("Authorization")
+		if key == "" {
+			key, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+
+		now := time.Now()
+		quotasMu.Lock()
+		q, ok := quotas[key]
+		if !ok || now.After(q.reset) {
+			q = &quota{reset: now.Add(rateWindow)}
+			quotas[key] = q
+		}
+		allowed := q.used < rateLimit
+		if allowed {
+			q.used++
+		}
+		remaining := rateLimit - q.used
+		resetIn := int(math.Ceil(q.reset.Sub(now).Seconds()))
+		quotasMu.Unlock()
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(rateLimit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(resetIn))
+
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(resetIn))
+			writeErrorDetails(w, http.StatusTooManyRequests, "rate_limited",
+				fmt.Sprintf("Rate limit of %d requests per %s exceeded", rateLimit, rateWindow),
+				map[string]interface{}{"limit": rateLimit, "retryAfter": resetIn})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
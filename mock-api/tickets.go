@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// wsTicketsDisabled makes the mock behave like a server that only accepts
+// the bearer token on WebSocket handshakes (MOCK_DISABLE_WS_TICKETS=1)
+var wsTicketsDisabled = os.Getenv("MOCK_DISABLE_WS_TICKETS") == "1"
+
+const wsTicketTTL = time.Minute
+
+// wsTicket authorizes one WebSocket handshake for one deployment
+type wsTicket struct {
+	DeploymentID string
+	ExpiresAt    time.Time
+}
+
+var (
+	wsTicketsMu sync.Mutex
+	wsTickets   = make(map[string]wsTicket)
+)
+
+// POST /api/ws/tickets - Issues a single-use ticket for a deployment's stream
+func mockCreateWSTicket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if rejectMissingDeployToken(w, r) {
+		return
+	}
+
+	var req struct {
+		DeploymentID string `json:"deploymentId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DeploymentID == "" {
+		writeError(w, http.StatusBadRequest, "deploymentId is required")
+		return
+	}
+	if _, ok := findDeployment(req.DeploymentID); !ok {
+		writeError(w, http.StatusNotFound, "Unknown deployment")
+		return
+	}
+
+	ticket := "wst_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	expiresAt := time.Now().Add(wsTicketTTL).UTC()
+	wsTicketsMu.Lock()
+	wsTickets[ticket] = wsTicket{DeploymentID: req.DeploymentID, ExpiresAt: expiresAt}
+	wsTicketsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ticket":       ticket,
+		"deploymentId": req.DeploymentID,
+		"expiresAt":    expiresAt,
+	})
+}
+
+// rejectUnauthenticatedStream writes a 401 (or 403) and returns true unless
+// the /ws handshake carries a valid ticket for the deployment, or a bearer
+// token with the deploy scope. A ticket is used up by the attempt.
+func rejectUnauthenticatedStream(w http.ResponseWriter, r *http.Request, deploymentID string) bool {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		wsTicketsMu.Lock()
+		issued, ok := wsTickets[ticket]
+		delete(wsTickets, ticket)
+		wsTicketsMu.Unlock()
+
+		if !ok || issued.DeploymentID != deploymentID || time.Now().After(issued.ExpiresAt) {
+			writeError(w, http.StatusUnauthorized, "Invalid or expired ticket")
+			return true
+		}
+		return false
+	}
+
+	if bearerToken(r) == "" {
+		writeError(w, http.StatusUnauthorized, "Authentication required: send a bearer token or a ticket")
+		return true
+	}
+	return rejectInvalidToken(w, r) || rejectMissingScope(w, r, "deploy")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MOCK_TLS=1 serves HTTPS with a development CA generated into MOCK_TLS_DIR
+// (default ./mock-tls), along with a client certificate for mutual TLS.
+// MOCK_TLS_CLIENT_AUTH=1 additionally requires that client certificate.
+var (
+	tlsEnabled    = os.Getenv("MOCK_TLS") == "1"
+	tlsClientAuth = os.Getenv("MOCK_TLS_CLIENT_AUTH") == "1"
+)
+
+func tlsDir() string {
+	if dir := os.Getenv("MOCK_TLS_DIR"); dir != "" {
+		return dir
+	}
+	return "mock-tls"
+}
+
+func listenAndServe(addr string, handler http.Handler) error {
+	if !tlsEnabled {
+		return http.ListenAndServe(addr, handler)
+	}
+
+	dir := tlsDir()
+	if err := ensureCertificates(dir); err != nil {
+		return fmt.Errorf("failed to set up TLS certificates: %w", err)
+	}
+
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: &tls.Config{}}
+	if tlsClientAuth {
+		caPEM, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caPEM)
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	fmt.Printf("🔒 TLS enabled - trust %s (--ca-cert)\n", filepath.Join(dir, "ca.pem"))
+	if tlsClientAuth {
+		fmt.Printf("🔑 Client certificates required - use %s and %s (--client-cert, --client-key)\n",
+			filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	}
+	return server.ListenAndServeTLS(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+}
+
+// ensureCertificates creates the development CA and the server and client
+// certificates it signs, unless they already exist. Keeping them across
+// restarts means a CLI context pointing at them keeps working.
+func ensureCertificates(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "ca.pem")); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTemplate := certificateTemplate("Backend.im Mock CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+
+	server := certificateTemplate("localhost")
+	server.DNSNames = []string{"localhost"}
+	server.IPAddresses = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	server.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	client := certificateTemplate("backend-im-cli")
+	client.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	if err := issueCertificate(dir, "server", server, caCert, caKey); err != nil {
+		return err
+	}
+	if err := issueCertificate(dir, "client", client, caCert, caKey); err != nil {
+		return err
+	}
+	return writePEM(filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER)
+}
+
+func certificateTemplate(commonName string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+// issueCertificate signs template with the CA, writing <name>.pem and <name>-key.pem
+func issueCertificate(dir, name string, template, ca *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(filepath.Join(dir, name+".pem"), "CERTIFICATE", der); err != nil {
+		return err
+	}
+	return writePEM(filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(path, blockType string, der []byte) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
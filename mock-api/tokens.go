@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Personal access tokens: long-lived, scoped tokens for automation
+const patPrefix = "bim_pat_"
+
+// Scopes a personal access token can be granted. Interactive and service
+// account tokens implicitly have all of them.
+var knownScopes = map[string]bool{
+	"generate": true,
+	"commit":   true,
+	"deploy":   true,
+}
+
+type personalAccessToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	UserID    string     `json:"-"`
+	Token     string     `json:"-"`
+}
+
+var (
+	patsMu      sync.Mutex
+	pats        = make(map[string]*personalAccessToken) // id -> token
+	patsByToken = make(map[string]*personalAccessToken)
+)
+
+// lookupPAT returns the personal access token presented by the request, if any
+func lookupPAT(r *http.Request) (*personalAccessToken, bool) {
+	patsMu.Lock()
+	defer patsMu.Unlock()
+	pat, ok := patsByToken[bearerToken(r)]
+	return pat, ok
+}
+
+// rejectMissingScope writes a 403 and returns true if the request uses a
+// personal access token that was granted none of scopes
+func rejectMissingScope(w http.ResponseWriter, r *http.Request, scopes ...string) bool {
+	pat, ok := lookupPAT(r)
+	if !ok {
+		return false
+	}
+	for _, granted := range pat.Scopes {
+		for _, scope := range scopes {
+			if granted == scope {
+				return false
+			}
+		}
+	}
+	required := strings.Join(scopes, " or ")
+	writeErrorDetails(w, http.StatusForbidden, "insufficient_scope",
+		fmt.Sprintf("Token lacks required scope: %s", required),
+		map[string]interface{}{"requiredScope": required, "grantedScopes": pat.Scopes})
+	return true
+}
+
+// /api/tokens - GET lists, POST creates personal access tokens
+func mockTokens(w http.ResponseWriter, r *http.Request) {
+	if !requireInteractiveToken(w, r, "manage tokens") {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		patsMu.Lock()
+		list := []*personalAccessToken{}
+		for _, pat := range pats {
+			list = append(list, pat)
+		}
+		patsMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"tokens": list})
+
+	case http.MethodPost:
+		var req struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			ExpiresIn int      `json:"expiresIn"` // seconds, 0 = never
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		if len(req.Scopes) == 0 {
+			writeError(w, http.StatusBadRequest, "At least one scope is required")
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !knownScopes[scope] {
+				writeErrorDetails(w, http.StatusBadRequest, "unknown_scope",
+					fmt.Sprintf("Unknown scope: %s", scope),
+					map[string]interface{}{"scope": scope})
+				return
+			}
+		}
+
+		pat := &personalAccessToken{
+			ID:        "tok_" + uuid.New().String()[:8],
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			CreatedAt: time.Now().UTC(),
+			UserID:    mockUserID,
+			Token:     patPrefix + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		}
+		if req.ExpiresIn > 0 {
+			expiresAt := pat.CreatedAt.Add(time.Duration(req.ExpiresIn) * time.Second)
+			pat.ExpiresAt = &expiresAt
+		}
+
+		patsMu.Lock()
+		pats[pat.ID] = pat
+		patsByToken[pat.Token] = pat
+		patsMu.Unlock()
+
+		// The secret is only ever returned on creation
+		response := map[string]interface{}{
+			"id":        pat.ID,
+			"name":      pat.Name,
+			"scopes":    pat.Scopes,
+			"createdAt": pat.CreatedAt,
+			"token":     pat.Token,
+		}
+		if pat.ExpiresAt != nil {
+			response["expiresAt"] = pat.ExpiresAt
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// DELETE /api/tokens/{id} - Revokes a personal access token
+func mockTokenByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !requireInteractiveToken(w, r, "manage tokens") {
+		return
+	}
+
+	id := r.URL.Path[len("/api/tokens/"):]
+
+	patsMu.Lock()
+	pat, ok := pats[id]
+	if ok {
+		delete(pats, id)
+		delete(patsByToken, pat.Token)
+	}
+	patsMu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+
+	tokensMu.Lock()
+	revokeToken(pat.Token)
+	tokensMu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireInteractiveToken rejects requests that are unauthenticated or use a
+// personal access token - tokens can't manage other tokens or sessions.
+// action completes the 403 message.
+func requireInteractiveToken(w http.ResponseWriter, r *http.Request, action string) bool {
+	if r.Header.Get("Authorization") == "" {
+		writeError(w, http.StatusUnauthorized, "Missing authorization header")
+		return false
+	}
+	if rejectInvalidToken(w, r) {
+		return false
+	}
+	if _, isPAT := lookupPAT(r); isPAT {
+		writeError(w, http.StatusForbidden, "Personal access tokens cannot "+action)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The fallbacks for networks that don't pass WebSockets:
+// MOCK_DISABLE_SSE=1 and MOCK_DISABLE_LONGPOLL=1 turn off the event stream
+// and long polling, and MOCK_BLOCK_WEBSOCKET=1 makes /ws answer 502 like a
+// proxy that doesn't support WebSockets
+var (
+	sseDisabled      = os.Getenv("MOCK_DISABLE_SSE") == "1"
+	longPollDisabled = os.Getenv("MOCK_DISABLE_LONGPOLL") == "1"
+	wsBlocked        = os.Getenv("MOCK_BLOCK_WEBSOCKET") == "1"
+)
+
+// sseKeepalive is how often the event stream sends a comment while the
+// deployment is quiet, so clients can tell it from a dead connection
+const sseKeepalive = 15 * time.Second
+
+// longPollMaxWait caps how many seconds a long poll may wait
+const longPollMaxWait = 30
+
+// GET /api/deployments/{id}/events and /api/deployments/{id}/updates -
+// The deployment's updates as Server-Sent Events or by long polling
+func mockDeploymentUpdates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	deploymentID, transport, _ := strings.Cut(r.URL.Path[len("/api/deployments/"):], "/")
+	switch {
+	case deploymentID == "":
+		writeError(w, http.StatusBadRequest, "Deployment ID required")
+	case transport == "events" && !sseDisabled:
+		mockEventStream(w, r, deploymentID)
+	case transport == "updates" && !longPollDisabled:
+		mockLongPoll(w, r, deploymentID)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// mockEventStream streams the deployment's buffer as update events, after
+// the Last-Event-ID the client resumes from
+func mockEventStream(w http.ResponseWriter, r *http.Request, deploymentID string) {
+	since := 0
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		n, err := strconv.Atoi(id)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Last-Event-ID must be a sequence number")
+			return
+		}
+		since = n
+	}
+
+	if rejectMissingDeployToken(w, r) {
+		return
+	}
+	d, ok := findDeployment(deploymentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown deployment")
+		return
+	}
+
+	events, updated := d.eventsSince(since)
+	if len(events) == 0 && d.finished() {
+		// Resumed after the last update: tells the client not to reconnect
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	flusher := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: update\ndata: %s\n\n", event.Seq, data); err != nil {
+				log.Printf("Event stream write error: %v", err)
+				return
+			}
+			flusher.Flush()
+			since = event.Seq
+
+			if event.final() {
+				return
+			}
+		}
+
+		select {
+		case <-updated:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+		events, updated = d.eventsSince(since)
+	}
+}
+
+// mockLongPoll answers with the updates after since, waiting up to wait
+// seconds for one if there are none yet and the deployment is still running
+func mockLongPoll(w http.ResponseWriter, r *http.Request, deploymentID string) {
+	since, wait := 0, 0
+	for name, value := range map[string]*int{"since": &since, "wait": &wait} {
+		if s := r.URL.Query().Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, name+" must be a non-negative integer")
+				return
+			}
+			*value = n
+		}
+	}
+	if wait > longPollMaxWait {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("wait must be at most %d seconds", longPollMaxWait))
+		return
+	}
+
+	if rejectMissingDeployToken(w, r) {
+		return
+	}
+	d, ok := findDeployment(deploymentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown deployment")
+		return
+	}
+
+	timeout := time.NewTimer(time.Duration(wait) * time.Second)
+	defer timeout.Stop()
+	events, updated := d.eventsSince(since)
+poll:
+	for len(events) == 0 && !d.finished() {
+		select {
+		case <-updated:
+			events, updated = d.eventsSince(since)
+		case <-timeout.C:
+			break poll
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if events == nil {
+		events = []deploymentEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"updates": events, "finished": d.finished()})
+}
+
+// rejectMissingDeployToken writes a 401 (or 403) and returns true unless
+// the request carries a valid bearer token with the deploy scope
+func rejectMissingDeployToken(w http.ResponseWriter, r *http.Request) bool {
+	if bearerToken(r) == "" {
+		writeError(w, http.StatusUnauthorized, "Authentication required")
+		return true
+	}
+	return rejectInvalidToken(w, r) || rejectMissingScope(w, r, "deploy")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+)
+
+const (
+	archiveContentType = "application/tar+gzip"
+	manifestHeader     = "X-Upload-Manifest"
+)
+
+// archivesDisabled makes the mock behave like a server without archive
+// support (MOCK_DISABLE_ARCHIVE=1), to exercise the CLI's JSON fallback
+var archivesDisabled = os.Getenv("MOCK_DISABLE_ARCHIVE") == "1"
+
+var errUnsupportedMediaType = errors.New("unsupported content type")
+
+// uploadRequest is the body of /api/deploy and /api/commit, sent either as
+// JSON or as a streamed archive with the other fields in a manifest header.
+// JSON bodies carry either the files themselves or, after uploading blobs, a
+// manifest mapping each path to the hash of its contents.
+type uploadRequest struct {
+	Files     map[string]string `json:"files"`
+	Manifest  map[string]string `json:"manifest,omitempty"`
+	ProjectID string            `json:"projectId"` // Unique project ID (includes user ID)
+	Message   string            `json:"message"`
+}
+
+// uploadManifest accompanies an archive upload
+type uploadManifest struct {
+	ProjectID  string `json:"projectId"`
+	Message    string `json:"message"`
+	FileCount  int    `json:"fileCount"`
+	TotalBytes int64  `json:"totalBytes"`
+}
+
+// readUpload decodes a deploy or commit body in either format and writes the
+// error response itself if it can't
+func readUpload(w http.ResponseWriter, r *http.Request) (*uploadRequest, bool) {
+	req, err := decodeUpload(r)
+	if errors.Is(err, errUnsupportedMediaType) {
+		writeErrorDetails(w, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			"Content-Type must be application/json or "+archiveContentType,
+			map[string]interface{}{"contentType": r.Header.Get("Content-Type")})
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+		return nil, false
+	}
+
+	if req.Manifest != nil {
+		files, missing := resolveManifest(req.Manifest)
+		if len(missing) > 0 {
+			writeErrorDetails(w, http.StatusConflict, "missing_blobs",
+				fmt.Sprintf("%d blobs in the manifest have not been uploaded", len(missing)),
+				map[string]interface{}{"missing": missing})
+			return nil, false
+		}
+		req.Files = files
+	}
+	return req, true
+}
+
+func decodeUpload(r *http.Request) (*uploadRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch {
+	case mediaType == "application/json" || mediaType == "":
+		var req uploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		return &req, nil
+	case mediaType == archiveContentType && !archivesDisabled:
+		return decodeArchiveUpload(r)
+	default:
+		return nil, errUnsupportedMediaType
+	}
+}
+
+func decodeArchiveUpload(r *http.Request) (*uploadRequest, error) {
+	manifestJSON, err := base64.StdEncoding.DecodeString(r.Header.Get(manifestHeader))
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s header: %w", manifestHeader, err)
+	}
+	var manifest uploadManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("malformed %s header: %w", manifestHeader, err)
+	}
+
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("archive is not gzip: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	req := &uploadRequest{
+		Files:     make(map[string]string),
+		ProjectID: manifest.ProjectID,
+		Message:   manifest.Message,
+	}
+	var totalBytes int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %w", err)
+		}
+		req.Files[header.Name] = string(content)
+		totalBytes += int64(len(content))
+	}
+
+	if len(req.Files) != manifest.FileCount || totalBytes != manifest.TotalBytes {
+		return nil, fmt.Errorf("archive has %d files (%d bytes), manifest says %d files (%d bytes)",
+			len(req.Files), totalBytes, manifest.FileCount, manifest.TotalBytes)
+	}
+
+	log.Printf("Received archive upload: %d files, %d bytes", len(req.Files), totalBytes)
+	return req, nil
+}
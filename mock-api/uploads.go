@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sessionsDisabled makes the mock behave like a server without resumable
+// uploads (MOCK_DISABLE_SESSIONS=1), so blobs are sent in one request
+var sessionsDisabled = os.Getenv("MOCK_DISABLE_SESSIONS") == "1"
+
+const (
+	minChunkSize = 64 << 10
+	maxChunkSize = 8 << 20
+	sessionTTL   = 24 * time.Hour
+)
+
+// uploadSession is a resumable blob upload. Chunks are kept until the
+// session is finalized, when the assembled payload is unpacked into the
+// blob store.
+type uploadSession struct {
+	ID        string
+	Size      int64
+	SHA256    string
+	ChunkSize int64
+	Chunks    map[int][]byte
+	ExpiresAt time.Time
+	Finalized bool
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]*uploadSession)
+)
+
+func (s *uploadSession) chunkCount() int {
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+// chunkSizeOf is the size chunk index must have: full, except for the last
+func (s *uploadSession) chunkSizeOf(index int) int64 {
+	if index == s.chunkCount()-1 {
+		return s.Size - int64(index)*s.ChunkSize
+	}
+	return s.ChunkSize
+}
+
+func (s *uploadSession) response() map[string]interface{} {
+	received := []map[string]interface{}{}
+	for index := 0; index < s.chunkCount(); index++ {
+		if chunk, ok := s.Chunks[index]; ok {
+			received = append(received, map[string]interface{}{
+				"index":  index,
+				"offset": int64(index) * s.ChunkSize,
+				"size":   len(chunk),
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"uploadId":  s.ID,
+		"size":      s.Size,
+		"sha256":    s.SHA256,
+		"chunkSize": s.ChunkSize,
+		"received":  received,
+		"expiresAt": s.ExpiresAt,
+		"finalized": s.Finalized,
+	}
+}
+
+// POST /api/uploads - Creates a resumable upload session
+func mockCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if rejectInvalidToken(w, r) {
+		return
+	}
+	if rejectMissingScope(w, r, "deploy", "commit") {
+		return
+	}
+
+	var req struct {
+		Size      int64  `json:"size"`
+		SHA256    string `json:"sha256"`
+		ChunkSize int64  `json:"chunkSize"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Size <= 0 || req.SHA256 == "" {
+		writeError(w, http.StatusBadRequest, "size and sha256 are required")
+		return
+	}
+
+	// Honour the client's chunk size within limits
+	chunkSize := req.ChunkSize
+	if chunkSize < minChunkSize {
+		chunkSize = minChunkSize
+	}
+	if chunkSize > maxChunkSize {
+		chunkSize = maxChunkSize
+	}
+
+	session := &uploadSession{
+		ID:        "up_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:16],
+		Size:      req.Size,
+		SHA256:    req.SHA256,
+		ChunkSize: chunkSize,
+		Chunks:    make(map[int][]byte),
+		ExpiresAt: time.Now().Add(sessionTTL).UTC(),
+	}
+
+	sessionsMu.Lock()
+	sessions[session.ID] = session
+	response := session.response()
+	sessionsMu.Unlock()
+
+	log.Printf("Upload session %s: %d bytes in %d chunks", session.ID, session.Size, session.chunkCount())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// /api/uploads/{id} - GET returns the session and the chunks received
+// /api/uploads/{id}/chunks/{n} - PUT stores a chunk
+// /api/uploads/{id}/finalize - POST assembles the chunks into blobs
+func mockUploadSession(w http.ResponseWriter, r *http.Request) {
+	if rejectInvalidToken(w, r) {
+		return
+	}
+	if rejectMissingScope(w, r, "deploy", "commit") {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/uploads/"), "/")
+
+	sessionsMu.Lock()
+	session, ok := sessions[parts[0]]
+	if ok && time.Now().After(session.ExpiresAt) {
+		delete(sessions, parts[0])
+		ok = false
+	}
+	sessionsMu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Upload session not found or expired")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		sessionsMu.Lock()
+		response := session.response()
+		sessionsMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case len(parts) == 3 && parts[1] == "chunks" && r.Method == http.MethodPut:
+		mockUploadChunk(w, r, session, parts[2])
+
+	case len(parts) == 2 && parts[1] == "finalize" && r.Method == http.MethodPost:
+		idempotent(func(w http.ResponseWriter, r *http.Request) {
+			mockFinalizeUpload(w, session)
+		})(w, r)
+
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func mockUploadChunk(w http.ResponseWriter, r *http.Request, session *uploadSession, indexParam string) {
+	index, err := strconv.Atoi(indexParam)
+	if err != nil || index < 0 || index >= session.chunkCount() {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Chunk index must be between 0 and %d", session.chunkCount()-1))
+		return
+	}
+
+	sessionsMu.Lock()
+	finalized := session.Finalized
+	sessionsMu.Unlock()
+	if finalized {
+		writeError(w, http.StatusConflict, "Upload session was already finalized")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, session.ChunkSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read chunk")
+		return
+	}
+	if int64(len(data)) != session.chunkSizeOf(index) {
+		writeErrorDetails(w, http.StatusBadRequest, "bad_chunk_size",
+			fmt.Sprintf("Chunk %d must be %d bytes, got %d", index, session.chunkSizeOf(index), len(data)),
+			map[string]interface{}{"index": index, "expected": session.chunkSizeOf(index), "actual": len(data)})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	if r.Header.Get("X-Chunk-SHA256") != hex.EncodeToString(sum[:]) {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, "checksum_mismatch",
+			fmt.Sprintf("Chunk %d does not match its X-Chunk-SHA256 checksum", index),
+			map[string]interface{}{"index": index})
+		return
+	}
+
+	sessionsMu.Lock()
+	session.Chunks[index] = data
+	sessionsMu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func mockFinalizeUpload(w http.ResponseWriter, session *uploadSession) {
+	sessionsMu.Lock()
+	var missing []int
+	var payload bytes.Buffer
+	for index := 0; index < session.chunkCount(); index++ {
+		chunk, ok := session.Chunks[index]
+		if !ok {
+			missing = append(missing, index)
+			continue
+		}
+		payload.Write(chunk)
+	}
+	sessionsMu.Unlock()
+
+	if len(missing) > 0 {
+		writeErrorDetails(w, http.StatusConflict, "missing_chunks",
+			fmt.Sprintf("%d chunks have not been uploaded", len(missing)),
+			map[string]interface{}{"missing": missing})
+		return
+	}
+
+	sum := sha256.Sum256(payload.Bytes())
+	if hex.EncodeToString(sum[:]) != session.SHA256 {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, "checksum_mismatch",
+			"Assembled upload does not match the session's sha256", nil)
+		return
+	}
+
+	received, err := readBlobArchive(&payload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid blob archive: %v", err))
+		return
+	}
+
+	blobsMu.Lock()
+	for hash, content := range received {
+		blobs[hash] = content
+	}
+	blobsMu.Unlock()
+
+	sessionsMu.Lock()
+	session.Finalized = true
+	session.Chunks = nil // the payload now lives in the blob store
+	sessionsMu.Unlock()
+
+	log.Printf("Upload session %s finalized: %d blobs", session.ID, len(received))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"uploadId": session.ID, "blobs": len(received)})
+}
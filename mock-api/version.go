@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// The API versions the mock speaks. MOCK_API_VERSION and MOCK_MIN_API_VERSION
+// simulate servers older or newer than the CLI; MOCK_DISABLE_VERSION=1 one
+// that predates the version handshake.
+var (
+	apiVersion      = envInt("MOCK_API_VERSION", 1)
+	minAPIVersion   = envInt("MOCK_MIN_API_VERSION", 1)
+	versionDisabled = os.Getenv("MOCK_DISABLE_VERSION") == "1"
+)
+
+const serverVersion = "1.0.0-mock"
+
+// capabilities lists the features this mock has enabled
+func capabilities() []string {
+	caps := []string{}
+	if !archivesDisabled {
+		caps = append(caps, "archive_uploads")
+	}
+	if !deltaDisabled {
+		caps = append(caps, "delta_uploads")
+		if !sessionsDisabled {
+			caps = append(caps, "upload_sessions")
+		}
+	}
+	if !wsTicketsDisabled {
+		caps = append(caps, "websocket_tickets")
+	}
+	if !sseDisabled {
+		caps = append(caps, "sse_updates")
+	}
+	if !longPollDisabled {
+		caps = append(caps, "longpoll_updates")
+	}
+	return caps
+}
+
+// GET /api/version - Returns the server version and capabilities
+func mockVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version":       serverVersion,
+		"apiVersion":    apiVersion,
+		"minApiVersion": minAPIVersion,
+		"capabilities":  capabilities(),
+	})
+}